@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
+	"docker-cr/pkg/check"
 	"docker-cr/pkg/checkpoint"
 	"docker-cr/pkg/docker"
 	"docker-cr/pkg/inspect"
 	"docker-cr/pkg/restore"
+	"docker-cr/pkg/schedule"
+	"docker-cr/pkg/server"
 	"docker-cr/pkg/utils"
+	"docker-cr/pkg/watch"
 	"fmt"
 
 	"github.com/sirupsen/logrus"
@@ -15,9 +26,12 @@ import (
 )
 
 var (
-	logger    *logrus.Logger
-	logLevel  string
-	verbose   bool
+	logger   *logrus.Logger
+	logLevel string
+	verbose  bool
+
+	// Key of encrypted checkpoints for inspect and its subcommands
+	inspectKey checkpoint.EncryptionKey
 )
 
 func main() {
@@ -43,6 +57,14 @@ Supports checkpointing running containers and restoring them with proper mount n
 	rootCmd.AddCommand(newCheckpointCommand())
 	rootCmd.AddCommand(newRestoreCommand())
 	rootCmd.AddCommand(newInspectCommand())
+	rootCmd.AddCommand(newPruneCommand())
+	rootCmd.AddCommand(newScheduleCommand())
+	rootCmd.AddCommand(newWatchCommand())
+	rootCmd.AddCommand(newServeCommand())
+	rootCmd.AddCommand(newRemoveCommand())
+	rootCmd.AddCommand(newCloneCommand())
+	rootCmd.AddCommand(newCheckCommand())
+	rootCmd.AddCommand(newExportCommand())
 	rootCmd.AddCommand(newVersionCommand())
 
 	if err := rootCmd.Execute(); err != nil {
@@ -85,15 +107,33 @@ func newCheckpointCommand() *cobra.Command {
 		preDump        bool
 		manageCgroups  bool
 		shell          bool
+		parent         string
+		trackMem       bool
+		freezeCgroup   bool
+		hooks          []string
+		timeout        time.Duration
+		group          string
+		keyFile        string
+		passphraseFile string
+		signKey        string
+		store          bool
 	)
 
 	cmd := &cobra.Command{
-		Use:   "checkpoint <container-name>",
+		Use:   "checkpoint <container-name>...",
 		Short: "Checkpoint a running container",
-		Long:  `Create a checkpoint of a running Docker container using CRIU.`,
-		Args:  cobra.ExactArgs(1),
+		Long: `Create a checkpoint of a running Docker container using CRIU.
+
+With several containers, or --group <compose-project>, all of them are frozen
+first and checkpointed as one consistent group. A group manifest linking the
+member checkpoints is written to <output>/groups/<group>/<name>.json.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if group != "" {
+				return cobra.MaximumNArgs(0)(cmd, args)
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			containerName := args[0]
 
 			// Initialize managers
 			dockerManager, err := docker.NewManager(logger)
@@ -111,20 +151,56 @@ func newCheckpointCommand() *cobra.Command {
 
 			// Prepare checkpoint config
 			config := checkpoint.CheckpointConfig{
-				OutputDir:       outputDir,
-				CheckpointName:  checkpointName,
-				LeaveRunning:    leaveRunning,
-				TcpEstablished:  tcpEstablished,
-				FileLocks:       fileLocks,
-				PreDump:         preDump,
-				LogLevel:        4, // Debug level
-				ManageCgroups:   manageCgroups,
-				Shell:           shell,
+				OutputDir:        outputDir,
+				CheckpointName:   checkpointName,
+				LeaveRunning:     leaveRunning,
+				TcpEstablished:   tcpEstablished,
+				FileLocks:        fileLocks,
+				PreDump:          preDump,
+				LogLevel:         4, // Debug level
+				ManageCgroups:    manageCgroups,
+				Shell:            shell,
+				ParentCheckpoint: parent,
+				TrackMem:         trackMem,
+				FreezeCgroup:     freezeCgroup,
+				Hooks:            hookScripts(hooks),
+				Encryption:       encryptionKey(keyFile, passphraseFile),
+				SigningKey:       signKey,
+				Store:            store,
 			}
 
+			ctx, cancel := operationContext(timeout)
+			defer cancel()
+
+			if group != "" || len(args) > 1 {
+				members := args
+				if group != "" {
+					members, err = checkpointManager.ResolveGroupContainers(ctx, group)
+					if err != nil {
+						return err
+					}
+				} else {
+					group = checkpointName
+				}
+
+				logger.Infof("Starting group checkpoint of %s: %v", group, members)
+				manifest, err := checkpointManager.CheckpointGroup(ctx, group, members, config)
+				if err != nil {
+					return fmt.Errorf("group checkpoint failed: %w", err)
+				}
+
+				fmt.Printf("\nGroup checkpoint completed successfully!\n")
+				for _, member := range manifest.Members {
+					fmt.Printf("  %-24s %s\n", member.Container, member.CheckpointDir)
+				}
+				fmt.Printf("Manifest: %s\n", checkpoint.GroupManifestPath(outputDir, group, checkpointName))
+				return nil
+			}
+			containerName := args[0]
+
 			// Perform checkpoint
 			logger.Infof("Starting checkpoint of container: %s", containerName)
-			if err := checkpointManager.Checkpoint(containerName, config); err != nil {
+			if err := checkpointManager.Checkpoint(ctx, containerName, config); err != nil {
 				return fmt.Errorf("checkpoint failed: %w", err)
 			}
 
@@ -146,13 +222,23 @@ func newCheckpointCommand() *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&outputDir, "output", "o", "/tmp/docker-checkpoints", "Output directory for checkpoints")
-	cmd.Flags().StringVarP(&checkpointName, "name", "n", "checkpoint1", "Name for the checkpoint")
+	cmd.Flags().StringVarP(&checkpointName, "name", "n", "checkpoint1", "Name for the checkpoint (must not already exist)")
 	cmd.Flags().BoolVar(&leaveRunning, "leave-running", true, "Leave container running after checkpoint")
 	cmd.Flags().BoolVar(&tcpEstablished, "tcp", false, "Checkpoint established TCP connections")
 	cmd.Flags().BoolVar(&fileLocks, "file-locks", false, "Checkpoint file locks")
 	cmd.Flags().BoolVar(&preDump, "pre-dump", false, "Perform pre-dump for optimization")
 	cmd.Flags().BoolVar(&manageCgroups, "manage-cgroups", false, "Manage cgroups during checkpoint")
 	cmd.Flags().BoolVar(&shell, "shell", false, "Checkpoint as shell job")
+	cmd.Flags().StringVar(&parent, "parent", "", "Parent checkpoint directory for an incremental checkpoint")
+	cmd.Flags().BoolVar(&trackMem, "track-mem", false, "Track memory changes so later checkpoints can be incremental")
+	cmd.Flags().StringArrayVar(&hooks, "hook", []string{}, "Executable run at each CRIU event, with the event in CRTOOLS_SCRIPT_ACTION")
+	cmd.Flags().BoolVar(&freezeCgroup, "freeze-cgroup", false, "Freeze the whole container through its freezer cgroup instead of ptrace (fails if the cgroup cannot be resolved)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Abort the checkpoint and resume the container after this long (0 = no timeout)")
+	cmd.Flags().StringVar(&group, "group", "", "Checkpoint all running containers of this compose project together")
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Encrypt the checkpoint with the 32-byte key in this file (raw, hex or base64)")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Encrypt the checkpoint with a key derived from the passphrase in this file")
+	cmd.Flags().StringVar(&signKey, "sign-key", "", "Sign the checkpoint manifest with this ed25519 private key (PEM)")
+	cmd.Flags().BoolVar(&store, "store", false, "Deduplicate page images in the chunk store shared by checkpoints under --output")
 
 	return cmd
 }
@@ -169,6 +255,20 @@ func newRestoreCommand() *cobra.Command {
 		validateEnv      bool
 		autoFixMounts    bool
 		skipMounts       []string
+		latestOf         string
+		outputDir        string
+		timeout          time.Duration
+		networks         []string
+		ipAddress        string
+		publish          []string
+		keepPorts        bool
+		remapMounts      []string
+		remapFile        string
+		group            string
+		hooks            []string
+		keyFile          string
+		passphraseFile   string
+		trustedKeys      []string
 	)
 
 	cmd := &cobra.Command{
@@ -186,6 +286,14 @@ func newRestoreCommand() *cobra.Command {
 			checkpointManager := checkpoint.NewManager(dockerManager, logger)
 			restoreManager := restore.NewManager(dockerManager, checkpointManager, logger)
 
+			ctx, cancel := operationContext(timeout)
+			defer cancel()
+
+			remaps, err := restore.ParseMountRemaps(remapMounts, remapFile)
+			if err != nil {
+				return err
+			}
+
 			var restoreConfig restore.RestoreConfig
 
 			if archivePath != "" {
@@ -205,13 +313,69 @@ func newRestoreCommand() *cobra.Command {
 					ValidateEnv:      validateEnv,
 					AutoFixMounts:    autoFixMounts,
 					SkipMounts:       skipMounts,
+					Networks:         networks,
+					IPAddress:        ipAddress,
+					Publish:          publish,
+					KeepPorts:        keepPorts,
+					RemapMounts:      remaps,
+					Hooks:            hookScripts(hooks),
+					Encryption:       encryptionKey(keyFile, passphraseFile),
+					TrustedKeys:      trustedKeys,
+				}
+
+				return restoreManager.RestoreFromArchive(ctx, archivePath, newContainerName, restoreConfig)
+			}
+
+			if group != "" {
+				manifestPath := group
+				if !utils.FileExists(manifestPath) {
+					groupName, name, ok := strings.Cut(group, "/")
+					if !ok {
+						return fmt.Errorf("--group expects a manifest file or <group>/<checkpoint-name>")
+					}
+					manifestPath = checkpoint.GroupManifestPath(outputDir, groupName, name)
+				}
+
+				restored, err := restoreManager.RestoreGroup(ctx, manifestPath, restore.RestoreConfig{
+					LogLevel:       4,
+					ManageCgroups:  manageCgroups,
+					TcpEstablished: tcpEstablished,
+					RestoreSibling: restoreSibling,
+					Shell:          shell,
+					ValidateEnv:    validateEnv,
+					AutoFixMounts:  autoFixMounts,
+					SkipMounts:     skipMounts,
+					RemapMounts:    remaps,
+					Hooks:          hookScripts(hooks),
+					Encryption:     encryptionKey(keyFile, passphraseFile),
+					TrustedKeys:    trustedKeys,
+				})
+				if err != nil {
+					return fmt.Errorf("group restore failed: %w", err)
+				}
+
+				fmt.Printf("\nGroup restore completed successfully!\n")
+				for _, name := range restored {
+					fmt.Printf("  %s\n", name)
+				}
+				return nil
+			}
+
+			if latestOf != "" {
+				if checkpointDir != "" {
+					return fmt.Errorf("--from and --latest are mutually exclusive")
 				}
 
-				return restoreManager.RestoreFromArchive(archivePath, newContainerName, restoreConfig)
+				latest, err := checkpointManager.LatestCheckpoint(outputDir, latestOf)
+				if err != nil {
+					return err
+				}
+				checkpointDir = latest.Path
+				logger.Infof("Using latest checkpoint of %s: %s (%s)", latestOf, latest.Name, latest.CreatedAt.Format("2006-01-02 15:04:05"))
 			}
 
 			if checkpointDir == "" {
-				return fmt.Errorf("either --from or --archive must be specified")
+				return fmt.Errorf("one of --from, --latest or --archive must be specified")
 			}
 
 			// Get default restore config if not provided
@@ -234,11 +398,19 @@ func newRestoreCommand() *cobra.Command {
 				ValidateEnv:      validateEnv,
 				AutoFixMounts:    autoFixMounts,
 				SkipMounts:       skipMounts,
+				Networks:         networks,
+				IPAddress:        ipAddress,
+				Publish:          publish,
+				KeepPorts:        keepPorts,
+				RemapMounts:      remaps,
+				Hooks:            hookScripts(hooks),
+				Encryption:       encryptionKey(keyFile, passphraseFile),
+				TrustedKeys:      trustedKeys,
 			}
 
 			// Perform restore
 			logger.Infof("Starting restore from: %s", checkpointDir)
-			if err := restoreManager.Restore(restoreConfig); err != nil {
+			if err := restoreManager.Restore(ctx, restoreConfig); err != nil {
 				return fmt.Errorf("restore failed: %w", err)
 			}
 
@@ -251,6 +423,8 @@ func newRestoreCommand() *cobra.Command {
 
 	cmd.Flags().StringVar(&checkpointDir, "from", "", "Checkpoint directory to restore from")
 	cmd.Flags().StringVar(&archivePath, "archive", "", "Checkpoint archive to restore from")
+	cmd.Flags().StringVar(&latestOf, "latest", "", "Restore the newest checkpoint of this container")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", "/tmp/docker-checkpoints", "Checkpoint output directory searched by --latest")
 	cmd.Flags().StringVar(&newContainerName, "new-name", "", "Name for the restored container")
 	cmd.Flags().BoolVar(&manageCgroups, "manage-cgroups", false, "Manage cgroups during restore")
 	cmd.Flags().BoolVar(&tcpEstablished, "tcp", false, "Restore established TCP connections")
@@ -259,10 +433,53 @@ func newRestoreCommand() *cobra.Command {
 	cmd.Flags().BoolVar(&validateEnv, "validate-env", true, "Validate restore environment")
 	cmd.Flags().BoolVar(&autoFixMounts, "auto-fix-mounts", true, "Automatically create missing mount sources")
 	cmd.Flags().StringSliceVar(&skipMounts, "skip-mounts", []string{}, "Mount paths to skip during restore")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Abort the restore after this long (0 = no timeout)")
+	cmd.Flags().StringSliceVar(&networks, "network", []string{}, "Networks to attach the restored container to (default: the original networks)")
+	cmd.Flags().StringVar(&ipAddress, "ip", "", "IP address of the restored container on its first network")
+	cmd.Flags().StringSliceVarP(&publish, "publish", "p", []string{}, "Publish a port, [ip:]host:container[/proto]")
+	cmd.Flags().BoolVar(&keepPorts, "keep-ports", false, "Bind the original host ports (default: Docker assigns new ones)")
+	cmd.Flags().StringArrayVar(&remapMounts, "remap-mount", []string{}, "Restore a bind mount from a different host path, <container-path>=<host-path>")
+	cmd.Flags().StringVar(&remapFile, "remap-file", "", "JSON file mapping container paths to new host paths")
+	cmd.Flags().StringVar(&group, "group", "", "Restore a group checkpoint, as a manifest file or <group>/<checkpoint-name> under --output")
+	cmd.Flags().StringArrayVar(&hooks, "hook", []string{}, "Executable run at each CRIU event, with the event in CRTOOLS_SCRIPT_ACTION")
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Key file of an encrypted checkpoint")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Passphrase file of an encrypted checkpoint")
+	cmd.Flags().StringArrayVar(&trustedKeys, "trusted-key", []string{}, "Only restore checkpoints signed by this ed25519 public key (PEM); repeatable")
 
 	return cmd
 }
 
+// hookScripts turns --hook flags into checkpoint hooks.
+func hookScripts(scripts []string) *checkpoint.Hooks {
+	if len(scripts) == 0 {
+		return nil
+	}
+	return &checkpoint.Hooks{Scripts: scripts}
+}
+
+// encryptionKey turns --key-file/--passphrase-file flags into a checkpoint key.
+func encryptionKey(keyFile, passphraseFile string) *checkpoint.EncryptionKey {
+	if keyFile == "" && passphraseFile == "" {
+		return nil
+	}
+	return &checkpoint.EncryptionKey{KeyFile: keyFile, PassphraseFile: passphraseFile}
+}
+
+// operationContext returns a context cancelled on SIGINT/SIGTERM and, when
+// timeout is non-zero, after timeout.
+func operationContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
 func newInspectCommand() *cobra.Command {
 	var (
 		outputFormat    string
@@ -273,6 +490,7 @@ func newInspectCommand() *cobra.Command {
 		showMounts      bool
 		showAll         bool
 		summary         bool
+		redact          bool
 	)
 
 	cmd := &cobra.Command{
@@ -281,11 +499,11 @@ func newInspectCommand() *cobra.Command {
 		Long:  `Analyze and display information about a checkpoint (like checkpointctl).`,
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			checkpointDir := args[0]
-
-			if !utils.DirExists(checkpointDir) {
-				return fmt.Errorf("checkpoint directory does not exist: %s", checkpointDir)
+			checkpointDir, cleanup, err := openInspectCheckpoint(args[0])
+			if err != nil {
+				return err
 			}
+			defer cleanup()
 
 			viewer := inspect.NewViewer(logger)
 
@@ -307,6 +525,7 @@ func newInspectCommand() *cobra.Command {
 				ShowAll:         showAll,
 				OutputFormat:    outputFormat,
 				Verbose:         verbose,
+				Redact:          redact,
 			}
 
 			output, err := viewer.ShowCheckpoint(checkpointDir, options)
@@ -327,6 +546,820 @@ func newInspectCommand() *cobra.Command {
 	cmd.Flags().BoolVar(&showMounts, "mounts", false, "Show mount mappings")
 	cmd.Flags().BoolVar(&showAll, "all", false, "Show all information")
 	cmd.Flags().BoolVar(&summary, "summary", false, "Show brief summary")
+	cmd.Flags().BoolVar(&redact, "redact", true, "Mask environment values that look like credentials (--redact=false to show them)")
+	cmd.PersistentFlags().StringVar(&inspectKey.KeyFile, "key-file", "", "Key file of an encrypted checkpoint")
+	cmd.PersistentFlags().StringVar(&inspectKey.PassphraseFile, "passphrase-file", "", "Passphrase file of an encrypted checkpoint")
+
+	cmd.AddCommand(newInspectDiffCommand())
+	cmd.AddCommand(newInspectDriftCommand())
+	cmd.AddCommand(newInspectMemoryCommand())
+	cmd.AddCommand(newInspectCoredumpCommand())
+	cmd.AddCommand(newInspectSecretsCommand())
+
+	return cmd
+}
+
+// openInspectCheckpoint returns a readable checkpoint directory. Encrypted
+// and stored checkpoints are copied to a private temporary directory that
+// cleanup removes.
+func openInspectCheckpoint(dir string) (string, func(), error) {
+	if !utils.DirExists(dir) {
+		return "", nil, fmt.Errorf("checkpoint directory does not exist: %s", dir)
+	}
+	return checkpoint.NewManager(nil, logger).OpenCheckpoint(dir, encryptionKey(inspectKey.KeyFile, inspectKey.PassphraseFile))
+}
+
+func newInspectDiffCommand() *cobra.Command {
+	var outputFormat string
+
+	cmd := &cobra.Command{
+		Use:   "diff <checkpoint-a> <checkpoint-b>",
+		Short: "Compare two checkpoints of a container",
+		Long: `Show what changed between two checkpoints, e.g. consecutive scheduled
+checkpoints of a long-running service: processes, file descriptors, sockets,
+memory mappings, environment, mount mappings and image file sizes.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dirs := make([]string, len(args))
+			for i, dir := range args {
+				opened, cleanup, err := openInspectCheckpoint(dir)
+				if err != nil {
+					return err
+				}
+				defer cleanup()
+				dirs[i] = opened
+			}
+
+			output, err := inspect.NewViewer(logger).ShowDiff(dirs[0], dirs[1], outputFormat)
+			if err != nil {
+				return fmt.Errorf("failed to diff checkpoints: %w", err)
+			}
+
+			fmt.Print(output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format (text, json)")
+
+	return cmd
+}
+
+func newInspectDriftCommand() *cobra.Command {
+	var outputFormat string
+
+	cmd := &cobra.Command{
+		Use:   "drift <checkpoint-dir> <container-name>",
+		Short: "Compare a checkpoint with the running container",
+		Long: `Show how a running container differs from a checkpoint of it: configuration,
+environment, mount mappings, processes and listening ports.
+
+Exits non-zero when there is drift, for use in CI.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checkpointDir, cleanup, err := openInspectCheckpoint(args[0])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			dockerManager, err := docker.NewManager(logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Docker manager: %w", err)
+			}
+			defer dockerManager.Close()
+
+			ctx, cancel := operationContext(0)
+			defer cancel()
+
+			report, err := inspect.NewAnalyzer(logger).DetectDrift(ctx, checkpointDir, dockerManager, args[1])
+			if err != nil {
+				return fmt.Errorf("drift check failed: %w", err)
+			}
+			report.Checkpoint = args[0]
+
+			output, err := inspect.NewViewer(logger).FormatDrift(report, outputFormat)
+			if err != nil {
+				return err
+			}
+			fmt.Print(output)
+
+			if report.HasDrift() {
+				return fmt.Errorf("container %s has drifted from %s", report.Container, report.Checkpoint)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format (text, json)")
+
+	return cmd
+}
+
+func newInspectMemoryCommand() *cobra.Command {
+	var (
+		outputFormat string
+		pid          int
+		search       string
+		dumpVMA      string
+		outputFile   string
+		maxMatches   int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "memory <checkpoint-dir>",
+		Short: "Search or extract the memory of checkpointed processes",
+		Long: `Read process memory from the pagemap and pages images of a checkpoint.
+
+--search reports every match of a regular expression with its process, VMA
+and offset. --dump-vma writes the VMA containing an address to a file, like
+checkpointctl memparse. Pages that were not dumped read as zeroes.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (search == "") == (dumpVMA == "") {
+				return fmt.Errorf("specify exactly one of --search or --dump-vma")
+			}
+
+			checkpointDir, cleanup, err := openInspectCheckpoint(args[0])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			analyzer := inspect.NewAnalyzer(logger)
+
+			if dumpVMA != "" {
+				addr, err := inspect.ParseMemoryAddress(dumpVMA)
+				if err != nil {
+					return err
+				}
+				if outputFile == "" {
+					outputFile = fmt.Sprintf("vma-%x.bin", addr)
+				}
+
+				vma, err := analyzer.DumpVMA(checkpointDir, pid, addr, outputFile)
+				if err != nil {
+					return fmt.Errorf("failed to dump VMA: %w", err)
+				}
+				fmt.Printf("Wrote %s-%s %s (%s) to %s\n", vma.StartAddr, vma.EndAddr, vma.Path, utils.FormatSize(vma.Size), outputFile)
+				return nil
+			}
+
+			pattern, err := regexp.Compile(search)
+			if err != nil {
+				return fmt.Errorf("invalid search pattern: %w", err)
+			}
+
+			matches, err := analyzer.SearchMemory(checkpointDir, pid, pattern, maxMatches)
+			if err != nil {
+				return fmt.Errorf("failed to search memory: %w", err)
+			}
+
+			output, err := inspect.NewViewer(logger).FormatMemoryMatches(matches, outputFormat)
+			if err != nil {
+				return err
+			}
+			fmt.Print(output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format (text, json)")
+	cmd.Flags().IntVar(&pid, "pid", 0, "PID in the checkpoint (default: all processes for --search, init for --dump-vma)")
+	cmd.Flags().StringVar(&search, "search", "", "Regular expression to search for")
+	cmd.Flags().StringVar(&dumpVMA, "dump-vma", "", "Extract the VMA containing this hex address")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "File for --dump-vma (default: vma-<addr>.bin)")
+	cmd.Flags().IntVar(&maxMatches, "max-matches", 100, "Stop after this many matches (0 for no limit)")
+
+	return cmd
+}
+
+func newInspectCoredumpCommand() *cobra.Command {
+	var (
+		pid       int
+		outputDir string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "coredump <checkpoint-dir>",
+		Short: "Generate ELF core dumps from checkpoint images",
+		Long: `Turn the core, mm, pagemap and pages images of checkpointed processes into
+ELF core files (core.<pid>) that gdb can load without restoring, like
+criu-coredump. Only x86_64 checkpoints are supported.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Cores would otherwise land in the temporary copy, and must not
+			// sit in plain text next to an encrypted checkpoint
+			if outputDir == "" && checkpoint.IsEncrypted(args[0]) {
+				return fmt.Errorf("--output is required for an encrypted checkpoint")
+			}
+			if outputDir == "" {
+				outputDir = args[0]
+			}
+
+			checkpointDir, cleanup, err := openInspectCheckpoint(args[0])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			dumps, err := inspect.NewAnalyzer(logger).GenerateCoreDumps(checkpointDir, pid, outputDir)
+			if err != nil {
+				return fmt.Errorf("failed to generate core dumps: %w", err)
+			}
+
+			for _, dump := range dumps {
+				fmt.Printf("%s: PID %d (%s), %d threads, %d segments, %s\n",
+					dump.Path, dump.PID, dump.Command, dump.Threads, dump.Segments, utils.FormatSize(dump.Size))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&pid, "pid", 0, "PID in the checkpoint (default: all processes)")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", "", "Directory for the core files (default: the checkpoint directory)")
+
+	return cmd
+}
+
+func newInspectSecretsCommand() *cobra.Command {
+	var (
+		outputFormat string
+		scanMemory   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "secrets <checkpoint-dir>",
+		Short: "Find likely credentials in a checkpoint",
+		Long: `Scan the saved environment and metadata of a checkpoint, and with --memory the
+dumped process memory, for likely credentials: well-known key and token
+formats, variables named like passwords or tokens, and high-entropy values.
+Matches are shown masked.
+
+Exits non-zero when anything is found.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checkpointDir, cleanup, err := openInspectCheckpoint(args[0])
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			findings, err := inspect.NewAnalyzer(logger).ScanSecrets(checkpointDir, scanMemory)
+			if err != nil {
+				return fmt.Errorf("secret scan failed: %w", err)
+			}
+
+			output, err := inspect.NewViewer(logger).FormatSecrets(findings, outputFormat)
+			if err != nil {
+				return err
+			}
+			fmt.Print(output)
+
+			if len(findings) > 0 {
+				return fmt.Errorf("%d likely secrets found in %s", len(findings), args[0])
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format (text, json)")
+	cmd.Flags().BoolVar(&scanMemory, "memory", false, "Also scan the dumped process memory")
+
+	return cmd
+}
+
+func newPruneCommand() *cobra.Command {
+	var (
+		outputDir    string
+		keepLast     int
+		maxAge       string
+		maxTotalSize string
+		useLabels    bool
+		dryRun       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "prune [container-name]",
+		Short: "Delete stale checkpoints according to a retention policy",
+		Long: `Delete old checkpoints below the output directory.
+
+Policies are applied per container and can be overridden with the container labels
+docker-cr.retention.keep-last, docker-cr.retention.max-age and
+docker-cr.retention.max-total-size. Checkpoints that are parents of a kept
+incremental checkpoint are never deleted.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			age, err := utils.ParseDuration(maxAge)
+			if err != nil {
+				return fmt.Errorf("invalid --max-age: %w", err)
+			}
+
+			size, err := utils.ParseSize(maxTotalSize)
+			if err != nil {
+				return fmt.Errorf("invalid --max-total-size: %w", err)
+			}
+
+			config := checkpoint.PruneConfig{
+				OutputDir: outputDir,
+				Policy: checkpoint.RetentionPolicy{
+					KeepLast:     keepLast,
+					MaxAge:       age,
+					MaxTotalSize: size,
+				},
+				UseLabels: useLabels,
+				DryRun:    dryRun,
+			}
+			if len(args) == 1 {
+				config.Container = args[0]
+			}
+
+			// Pruning only touches the filesystem, no Docker connection needed
+			checkpointManager := checkpoint.NewManager(nil, logger)
+
+			result, err := checkpointManager.Prune(config)
+			if err != nil {
+				return fmt.Errorf("prune failed: %w", err)
+			}
+
+			action := "Deleted"
+			if dryRun {
+				action = "Would delete"
+			}
+
+			for _, decision := range result.Decisions {
+				status := "keep  "
+				if decision.Delete {
+					status = "delete"
+				}
+				fmt.Printf("%s %s/%s (%s, %s) - %s\n", status,
+					decision.Checkpoint.Container, decision.Checkpoint.Name,
+					decision.Checkpoint.CreatedAt.Format("2006-01-02 15:04:05"),
+					utils.FormatSize(decision.Checkpoint.Size), decision.Reason)
+			}
+
+			fmt.Printf("\n%s %d checkpoint(s), %s freed\n", action, result.Deleted, utils.FormatSize(result.FreedBytes))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output", "o", "/tmp/docker-checkpoints", "Checkpoint output directory to prune")
+	cmd.Flags().IntVar(&keepLast, "keep-last", 0, "Keep only the N newest checkpoints per container (0 = unlimited)")
+	cmd.Flags().StringVar(&maxAge, "max-age", "", "Delete checkpoints older than this (e.g. 12h, 7d, 2w)")
+	cmd.Flags().StringVar(&maxTotalSize, "max-total-size", "", "Maximum total size per container (e.g. 500M, 10G)")
+	cmd.Flags().BoolVar(&useLabels, "use-labels", true, "Allow container labels to override the policy")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show what would be deleted")
+
+	return cmd
+}
+
+func newScheduleCommand() *cobra.Command {
+	var (
+		scheduleFile   string
+		outputDir      string
+		every          string
+		keep           int
+		fullEvery      int
+		incremental    bool
+		tcpEstablished bool
+		fileLocks      bool
+		manageCgroups  bool
+		maxFailures    int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "schedule [container-name]",
+		Short: "Take periodic checkpoints of long-running containers",
+		Long: `Periodically checkpoint a running container (leaving it running) and rotate old checkpoints.
+
+Either pass a container with --every, or run in daemon mode with --file pointing to a
+JSON schedule file:
+
+  {"jobs": [{"container": "batch-job", "every": "30m", "keep": 5, "incremental": true}]}
+
+Runs until interrupted. The status of each job is written to
+<output>/<container>/schedule_status.json; resume a crashed job with
+'docker-cr restore --latest <container>'.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var jobs []schedule.Job
+
+			switch {
+			case scheduleFile != "":
+				file, err := schedule.LoadScheduleFile(scheduleFile)
+				if err != nil {
+					return err
+				}
+				jobs = file.Jobs
+			case len(args) == 1:
+				jobs = []schedule.Job{{
+					Container:      args[0],
+					Every:          every,
+					Keep:           keep,
+					FullEvery:      fullEvery,
+					OutputDir:      outputDir,
+					Incremental:    incremental,
+					TcpEstablished: tcpEstablished,
+					FileLocks:      fileLocks,
+					ManageCgroups:  manageCgroups,
+					MaxFailures:    maxFailures,
+				}}
+			default:
+				return fmt.Errorf("either a container name or --file must be specified")
+			}
+
+			dockerManager, err := docker.NewManager(logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Docker manager: %w", err)
+			}
+			defer dockerManager.Close()
+
+			checkpointManager := checkpoint.NewManager(dockerManager, logger)
+			if err := checkpointManager.CheckCRIUSupport(); err != nil {
+				return fmt.Errorf("CRIU support check failed: %w", err)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			scheduler := schedule.NewScheduler(dockerManager, checkpointManager, logger)
+			return scheduler.Run(ctx, jobs)
+		},
+	}
+
+	cmd.Flags().StringVarP(&scheduleFile, "file", "f", "", "Schedule file for daemon mode (JSON)")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", "/tmp/docker-checkpoints", "Output directory for checkpoints")
+	cmd.Flags().StringVar(&every, "every", "1h", "Checkpoint interval (e.g. 30m, 6h, 1d)")
+	cmd.Flags().IntVar(&keep, "keep", 5, "Number of scheduled checkpoints to keep (0 = keep all)")
+	cmd.Flags().IntVar(&fullEvery, "full-every", 0, "Start a new full checkpoint after N checkpoints in a chain (default: --keep)")
+	cmd.Flags().BoolVar(&incremental, "incremental", true, "Take incremental checkpoints on top of the previous one")
+	cmd.Flags().BoolVar(&tcpEstablished, "tcp", false, "Checkpoint established TCP connections")
+	cmd.Flags().BoolVar(&fileLocks, "file-locks", false, "Checkpoint file locks")
+	cmd.Flags().BoolVar(&manageCgroups, "manage-cgroups", false, "Manage cgroups during checkpoint")
+	cmd.Flags().IntVar(&maxFailures, "max-failures", 0, "Stop after N consecutive failures (0 = never)")
+
+	return cmd
+}
+
+func newWatchCommand() *cobra.Command {
+	var (
+		outputDir            string
+		autoRestore          bool
+		checkpointOnShutdown bool
+		tcpEstablished       bool
+		fileLocks            bool
+		manageCgroups        bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Checkpoint labelled containers when they are stopped",
+		Long: `Follow the Docker event stream and checkpoint containers labelled
+docker-cr.checkpoint-on-stop=true as soon as they are asked to stop.
+
+Stop checkpoints are best effort: the dump races the container's own shutdown, so
+give such containers a generous --stop-timeout. When the watcher itself receives
+SIGTERM (host shutdown, with the service ordered before docker.service) it
+checkpoints all labelled containers while they are still running and, with
+--auto-restore, restores them once the daemon is reachable again.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dockerManager, err := docker.NewManager(logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Docker manager: %w", err)
+			}
+			defer dockerManager.Close()
+
+			checkpointManager := checkpoint.NewManager(dockerManager, logger)
+			if err := checkpointManager.CheckCRIUSupport(); err != nil {
+				return fmt.Errorf("CRIU support check failed: %w", err)
+			}
+			restoreManager := restore.NewManager(dockerManager, checkpointManager, logger)
+
+			watcher := watch.NewWatcher(dockerManager, checkpointManager, restoreManager, logger, watch.WatchConfig{
+				OutputDir:      outputDir,
+				AutoRestore:    autoRestore,
+				TcpEstablished: tcpEstablished,
+				FileLocks:      fileLocks,
+				ManageCgroups:  manageCgroups,
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(signals)
+
+			go func() {
+				sig := <-signals
+				if sig == syscall.SIGTERM && checkpointOnShutdown {
+					if err := watcher.CheckpointAll(context.Background(), "host shutdown"); err != nil {
+						logger.Errorf("Shutdown checkpoint failed: %v", err)
+					}
+				}
+				cancel()
+			}()
+
+			return watcher.Run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputDir, "output", "o", "/tmp/docker-checkpoints", "Output directory for checkpoints")
+	cmd.Flags().BoolVar(&autoRestore, "auto-restore", false, "Restore containers checkpointed at shutdown once the daemon is back")
+	cmd.Flags().BoolVar(&checkpointOnShutdown, "checkpoint-on-shutdown", true, "Checkpoint all labelled containers when receiving SIGTERM")
+	cmd.Flags().BoolVar(&tcpEstablished, "tcp", false, "Checkpoint established TCP connections")
+	cmd.Flags().BoolVar(&fileLocks, "file-locks", false, "Checkpoint file locks")
+	cmd.Flags().BoolVar(&manageCgroups, "manage-cgroups", false, "Manage cgroups during checkpoint")
+
+	return cmd
+}
+
+func newServeCommand() *cobra.Command {
+	var (
+		socketPath     string
+		tcpAddr        string
+		outputDir      string
+		hooks          []string
+		keyFile        string
+		passphraseFile string
+		signKey        string
+		trustedKeys    []string
+		maxJobs        int
+		jobTTL         time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the checkpoint/restore API over a Unix socket",
+		Long: `Expose checkpoint, restore, inspect, list and delete as a JSON HTTP API.
+
+Checkpoint and restore requests run as asynchronous jobs that can be polled with
+GET /v1/jobs/{id} and cancelled with DELETE /v1/jobs/{id}. The TCP listener has no
+authentication; only bind it to trusted addresses. Hook scripts and key files
+are set here for every job and cannot be given in requests.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dockerManager, err := docker.NewManager(logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Docker manager: %w", err)
+			}
+			defer dockerManager.Close()
+
+			checkpointManager := checkpoint.NewManager(dockerManager, logger)
+			if err := checkpointManager.CheckCRIUSupport(); err != nil {
+				logger.Warnf("CRIU support check failed, checkpoint and restore jobs will fail: %v", err)
+			}
+			restoreManager := restore.NewManager(dockerManager, checkpointManager, logger)
+			viewer := inspect.NewViewer(logger)
+
+			srv := server.NewServer(dockerManager, checkpointManager, restoreManager, viewer, logger, server.ServerConfig{
+				SocketPath:  socketPath,
+				TCPAddr:     tcpAddr,
+				OutputDir:   outputDir,
+				Hooks:       hookScripts(hooks),
+				Encryption:  encryptionKey(keyFile, passphraseFile),
+				SigningKey:  signKey,
+				TrustedKeys: trustedKeys,
+				MaxJobs:     maxJobs,
+				JobTTL:      jobTTL,
+			})
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return srv.Serve(ctx)
+		},
+	}
+
+	cmd.Flags().StringVar(&socketPath, "socket", "/run/docker-cr.sock", "Unix socket to listen on (empty to disable)")
+	cmd.Flags().StringVar(&tcpAddr, "tcp", "", "Optional TCP address to listen on (e.g. 127.0.0.1:8642)")
+	cmd.Flags().StringVarP(&outputDir, "output", "o", "/tmp/docker-checkpoints", "Checkpoint directory served by the API")
+	cmd.Flags().StringArrayVar(&hooks, "hook", []string{}, "Executable run at each CRIU event of every job")
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Key file to encrypt checkpoints with and decrypt them for restore")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Passphrase file to encrypt checkpoints with and decrypt them for restore")
+	cmd.Flags().StringVar(&signKey, "sign-key", "", "Sign checkpoint manifests with this ed25519 private key (PEM)")
+	cmd.Flags().StringArrayVar(&trustedKeys, "trusted-key", []string{}, "Only restore checkpoints signed by this ed25519 public key (PEM); repeatable")
+	cmd.Flags().IntVar(&maxJobs, "max-jobs", 100, "Finished jobs kept for polling; older ones are dropped")
+	cmd.Flags().DurationVar(&jobTTL, "job-ttl", time.Hour, "Drop finished jobs after this long")
+
+	return cmd
+}
+
+func newRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <checkpoint-dir>...",
+		Short: "Delete checkpoints",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checkpointManager := checkpoint.NewManager(nil, logger)
+
+			stores := make(map[string]bool)
+			for _, checkpointDir := range args {
+				if store := checkpoint.StoreOf(checkpointDir); store != "" {
+					stores[store] = true
+				}
+				if err := checkpointManager.DeleteCheckpoint(checkpointDir); err != nil {
+					return err
+				}
+				fmt.Printf("Deleted %s\n", checkpointDir)
+			}
+
+			for store := range stores {
+				freed, err := checkpointManager.CollectGarbage(store)
+				if err != nil {
+					return err
+				}
+				if freed > 0 {
+					fmt.Printf("Freed %s of unused chunks in %s\n", utils.FormatSize(freed), store)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func newCloneCommand() *cobra.Command {
+	var (
+		replicas       int
+		namePrefix     string
+		workDir        string
+		networks       []string
+		publish        []string
+		manageCgroups  bool
+		tcpEstablished bool
+		shell          bool
+		validateEnv    bool
+		autoFixMounts  bool
+		remapMounts    []string
+		hooks          []string
+		timeout        time.Duration
+		keyFile        string
+		passphraseFile string
+		trustedKeys    []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "clone <checkpoint-dir>",
+		Short: "Restore one checkpoint as several replicas",
+		Long: `Restore the same checkpoint several times concurrently, e.g. to start
+warm-cache replicas. Each replica gets its own name and hostname
+(<name-prefix>N), its own address, and copy-on-write copies of the writable
+mounts under --work-dir, which must be kept while the replicas run.
+Established TCP connections are closed in every replica.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dockerManager, err := docker.NewManager(logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Docker manager: %w", err)
+			}
+			defer dockerManager.Close()
+
+			checkpointManager := checkpoint.NewManager(dockerManager, logger)
+			restoreManager := restore.NewManager(dockerManager, checkpointManager, logger)
+
+			remaps, err := restore.ParseMountRemaps(remapMounts, "")
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := operationContext(timeout)
+			defer cancel()
+
+			results, err := restoreManager.Clone(ctx, restore.CloneConfig{
+				CheckpointDir: args[0],
+				Replicas:      replicas,
+				NamePrefix:    namePrefix,
+				WorkDir:       workDir,
+				Restore: restore.RestoreConfig{
+					LogLevel:       4,
+					ManageCgroups:  manageCgroups,
+					TcpEstablished: tcpEstablished,
+					Shell:          shell,
+					ValidateEnv:    validateEnv,
+					AutoFixMounts:  autoFixMounts,
+					Networks:       networks,
+					Publish:        publish,
+					RemapMounts:    remaps,
+					Hooks:          hookScripts(hooks),
+					Encryption:     encryptionKey(keyFile, passphraseFile),
+					TrustedKeys:    trustedKeys,
+				},
+			})
+
+			for _, result := range results {
+				if result.Error != "" {
+					fmt.Printf("  %-24s FAILED: %s\n", result.Name, result.Error)
+				} else {
+					fmt.Printf("  %-24s restored (%s)\n", result.Name, result.WorkDir)
+				}
+			}
+
+			return err
+		},
+	}
+
+	cmd.Flags().IntVar(&replicas, "replicas", 2, "Number of replicas to restore")
+	cmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Replica name prefix (default: <container>-clone-)")
+	cmd.Flags().StringVar(&workDir, "work-dir", "/tmp/docker-cr-clones", "Directory for per-replica files and mount copies")
+	cmd.Flags().StringSliceVar(&networks, "network", []string{}, "Networks to attach the replicas to (default: the original networks)")
+	cmd.Flags().StringSliceVarP(&publish, "publish", "p", []string{}, "Publish a container port on a random host port")
+	cmd.Flags().BoolVar(&manageCgroups, "manage-cgroups", false, "Manage cgroups during restore")
+	cmd.Flags().BoolVar(&tcpEstablished, "tcp", false, "Checkpoint contains established TCP connections (they are closed)")
+	cmd.Flags().BoolVar(&shell, "shell", true, "Restore as shell job")
+	cmd.Flags().BoolVar(&validateEnv, "validate-env", true, "Validate restore environment")
+	cmd.Flags().BoolVar(&autoFixMounts, "auto-fix-mounts", true, "Automatically create missing mount sources")
+	cmd.Flags().StringArrayVar(&remapMounts, "remap-mount", []string{}, "Copy a bind mount from a different host path, <container-path>=<host-path>")
+	cmd.Flags().StringArrayVar(&hooks, "hook", []string{}, "Executable run at each CRIU event of every replica (DOCKER_CR_CONTAINER names it)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Abort the clone after this long (0 = no timeout)")
+	cmd.Flags().StringVar(&keyFile, "key-file", "", "Key file of an encrypted checkpoint")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Passphrase file of an encrypted checkpoint")
+	cmd.Flags().StringArrayVar(&trustedKeys, "trusted-key", []string{}, "Only clone checkpoints signed by this ed25519 public key (PEM); repeatable")
+
+	return cmd
+}
+
+func newCheckCommand() *cobra.Command {
+	var outputFormat string
+
+	cmd := &cobra.Command{
+		Use:   "check <container-name>",
+		Short: "Check whether a container can be checkpointed",
+		Long: `Analyze a running container through /proc without freezing it, and report
+resources CRIU cannot dump or needs extra flags for: established TCP
+connections, file locks, terminals, devices, external Unix sockets, ptrace,
+io_uring, nested namespaces and mounts unknown to Docker.
+
+Exits non-zero when the container cannot be checkpointed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dockerManager, err := docker.NewManager(logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize Docker manager: %w", err)
+			}
+			defer dockerManager.Close()
+
+			ctx, cancel := operationContext(0)
+			defer cancel()
+
+			report, err := check.NewChecker(dockerManager, logger).Check(ctx, args[0])
+			if err != nil {
+				return fmt.Errorf("check failed: %w", err)
+			}
+
+			output, err := check.FormatReport(report, outputFormat)
+			if err != nil {
+				return err
+			}
+			fmt.Print(output)
+
+			if report.Verdict == check.VerdictBlocked {
+				return fmt.Errorf("container %s cannot be checkpointed", report.Container)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format (text, json)")
+
+	return cmd
+}
+
+func newExportCommand() *cobra.Command {
+	var (
+		outputFile string
+		redact     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export <checkpoint-dir>",
+		Short: "Export a checkpoint as a tar.gz archive",
+		Long: `Write a checkpoint directory to a gzipped tarball, e.g. to move it to another
+host. With --redact, environment values that look like credentials are masked
+in the metadata and the manifest is updated to match; a manifest signature no
+longer applies and is left out. Process memory in the images is not redacted.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checkpointDir := args[0]
+			if !utils.DirExists(checkpointDir) {
+				return fmt.Errorf("checkpoint directory does not exist: %s", checkpointDir)
+			}
+			if outputFile == "" {
+				outputFile = filepath.Base(filepath.Clean(checkpointDir)) + ".tar.gz"
+			}
+
+			checkpointManager := checkpoint.NewManager(nil, logger)
+			if err := checkpointManager.ExportCheckpoint(checkpointDir, outputFile, redact); err != nil {
+				return err
+			}
+
+			fmt.Printf("Exported %s to %s\n", checkpointDir, outputFile)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Archive to write (default: <checkpoint-name>.tar.gz)")
+	cmd.Flags().BoolVar(&redact, "redact", false, "Mask environment values that look like credentials in the metadata")
 
 	return cmd
 }
@@ -341,4 +1374,4 @@ func newVersionCommand() *cobra.Command {
 			fmt.Println("Built with love for container migration and forensic analysis")
 		},
 	}
-}
\ No newline at end of file
+}
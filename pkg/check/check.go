@@ -0,0 +1,324 @@
+package check
+
+import (
+	"context"
+	"docker-cr/pkg/docker"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Severity string
+
+const (
+	SeverityWarning Severity = "warning"
+	// SeverityError findings make the dump fail unless their Flag is given
+	SeverityError Severity = "error"
+)
+
+// Verdicts of a feasibility check
+const (
+	VerdictReady          = "ready"
+	VerdictReadyWithFlags = "ready-with-flags"
+	VerdictBlocked        = "blocked"
+)
+
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Resource string   `json:"resource"`
+	PID      int      `json:"pid,omitempty"`
+	Process  string   `json:"process,omitempty"`
+	Detail   string   `json:"detail"`
+	// Flag is the checkpoint flag that makes CRIU handle the resource
+	Flag string `json:"flag,omitempty"`
+}
+
+type Report struct {
+	Container string    `json:"container"`
+	PID       int       `json:"pid"`
+	Processes int       `json:"processes"`
+	Findings  []Finding `json:"findings"`
+	Flags     []string  `json:"flags"`
+	Verdict   string    `json:"verdict"`
+	Command   string    `json:"command"`
+}
+
+type Checker struct {
+	dockerManager *docker.Manager
+	logger        *logrus.Logger
+}
+
+func NewChecker(dockerManager *docker.Manager, logger *logrus.Logger) *Checker {
+	return &Checker{
+		dockerManager: dockerManager,
+		logger:        logger,
+	}
+}
+
+// Check analyses whether a running container can be checkpointed, only by
+// reading /proc: nothing is frozen or dumped.
+func (c *Checker) Check(ctx context.Context, nameOrID string) (*Report, error) {
+	state, err := c.dockerManager.GetContainerState(ctx, nameOrID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container state: %w", err)
+	}
+	if state.ProcessPID == 0 {
+		return nil, fmt.Errorf("container %s is not running", state.Name)
+	}
+
+	mappings, err := c.dockerManager.GetMountMappings(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mount mappings: %w", err)
+	}
+
+	c.logger.Infof("Checking %s (PID %d)", state.Name, state.ProcessPID)
+	report, err := AnalyzeProcess(state.ProcessPID, mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Container = state.Name
+	report.Command = checkpointCommand(state.Name, report.Flags)
+	return report, nil
+}
+
+// AnalyzeProcess checks pid and its descendants for resources CRIU cannot
+// dump, or can only dump with extra flags. mappings are the mounts that will
+// be dumped as external.
+func AnalyzeProcess(pid int, mappings []docker.MountMapping) (*Report, error) {
+	pids, err := ProcessTree(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &analysis{report: &Report{PID: pid, Processes: len(pids)}}
+
+	if err := a.checkFiles(pid, pids, mappings); err != nil {
+		return nil, err
+	}
+	if err := a.checkLocks(pids); err != nil {
+		return nil, err
+	}
+	a.checkTracers(pids)
+	a.checkNamespaces(pid, pids)
+	if err := a.checkMounts(pid, mappings); err != nil {
+		return nil, err
+	}
+
+	a.finish()
+	return a.report, nil
+}
+
+type analysis struct {
+	report *Report
+}
+
+func (a *analysis) add(severity Severity, resource string, pid int, detail, flag string) {
+	finding := Finding{Severity: severity, Resource: resource, PID: pid, Detail: detail, Flag: flag}
+	if pid > 0 {
+		finding.Process = processName(pid)
+	}
+	a.report.Findings = append(a.report.Findings, finding)
+}
+
+// Devices CRIU restores without help
+var harmlessDevices = map[string]bool{
+	"/dev/null": true, "/dev/zero": true, "/dev/full": true,
+	"/dev/random": true, "/dev/urandom": true, "/dev/net/tun": true,
+}
+
+// Anonymous inodes CRIU has no dumper for
+var unsupportedAnonInodes = map[string]string{
+	"anon_inode:[io_uring]":   "io_uring instance",
+	"anon_inode:bpf-map":      "BPF map",
+	"anon_inode:bpf-prog":     "BPF program",
+	"anon_inode:[perf_event]": "perf event",
+}
+
+func (a *analysis) checkFiles(initPID int, pids []int, mappings []docker.MountMapping) error {
+	tcp, err := tcpSockets(initPID)
+	if err != nil {
+		return err
+	}
+	unix, err := unixSocketPaths(initPID)
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		files, err := openFiles(pid)
+		if err != nil {
+			// The process exited since the scan
+			continue
+		}
+
+		for fd, target := range files {
+			if inode, ok := socketInode(target); ok {
+				a.checkSocket(pid, fd, inode, tcp, unix, mappings)
+				continue
+			}
+
+			if what, ok := unsupportedAnonInodes[target]; ok {
+				a.add(SeverityError, "anon-inode", pid, fmt.Sprintf("fd %d is a %s, which CRIU cannot dump", fd, what), "")
+				continue
+			}
+
+			if !strings.HasPrefix(target, "/dev/") || harmlessDevices[target] ||
+				strings.HasPrefix(target, "/dev/shm/") || strings.HasPrefix(target, "/dev/mqueue/") {
+				continue
+			}
+
+			if strings.HasPrefix(target, "/dev/pts/") || strings.HasPrefix(target, "/dev/tty") || target == "/dev/console" {
+				a.add(SeverityError, "tty", pid, fmt.Sprintf("fd %d is the terminal %s", fd, target), "--shell")
+				continue
+			}
+
+			a.add(SeverityError, "device", pid, fmt.Sprintf("fd %d is the device %s, which cannot be restored", fd, target), "")
+		}
+	}
+	return nil
+}
+
+func (a *analysis) checkSocket(pid, fd int, inode uint64, tcp map[uint64]tcpSocket, unix map[uint64]string, mappings []docker.MountMapping) {
+	if socket, ok := tcp[inode]; ok {
+		if socket.state == tcpEstablished {
+			a.add(SeverityError, "tcp", pid, fmt.Sprintf("fd %d is an established connection %s -> %s", fd, socket.local, socket.remote), "--tcp")
+		}
+		return
+	}
+
+	path, ok := unix[inode]
+	if !ok {
+		// A Unix socket missing from the container's table was created in
+		// another network namespace and handed in from outside
+		if strings.HasPrefix(socketProtocol(pid, fd), "UNIX") {
+			a.add(SeverityWarning, "unix-socket", pid,
+				fmt.Sprintf("fd %d is an external Unix socket from outside the container; its peer must exist again on restore", fd), "")
+		}
+		return
+	}
+	if path == "" || strings.HasPrefix(path, "@") {
+		return
+	}
+
+	for _, mapping := range mappings {
+		if mapping.Type == "bind" && (path == mapping.ContainerPath || strings.HasPrefix(path, strings.TrimSuffix(mapping.ContainerPath, "/")+"/")) {
+			a.add(SeverityWarning, "unix-socket", pid,
+				fmt.Sprintf("fd %d is a Unix socket on the host bind mount %s; peers outside the container are external and lose their connection on restore", fd, path), "")
+			return
+		}
+	}
+}
+
+func (a *analysis) checkLocks(pids []int) error {
+	holders, err := lockHolders()
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		if kind, ok := holders[pid]; ok {
+			a.add(SeverityError, "file-lock", pid, fmt.Sprintf("holds a %s file lock", kind), "--file-locks")
+		}
+	}
+	return nil
+}
+
+func (a *analysis) checkTracers(pids []int) {
+	for _, pid := range pids {
+		if tracer := statusField(pid, "TracerPid"); tracer != "" && tracer != "0" {
+			a.add(SeverityError, "ptrace", pid, fmt.Sprintf("is traced by PID %s; CRIU cannot seize it", tracer), "")
+		}
+	}
+}
+
+func (a *analysis) checkNamespaces(initPID int, pids []int) {
+	// Nested pid and user namespaces cannot be dumped at all
+	unsupported := map[string]bool{"pid": true, "user": true}
+
+	for _, nsType := range []string{"pid", "user", "mnt", "net", "ipc", "uts"} {
+		initInode, err := docker.NamespaceInode(initPID, nsType)
+		if err != nil {
+			continue
+		}
+
+		for _, pid := range pids[1:] {
+			inode, err := docker.NamespaceInode(pid, nsType)
+			if err != nil || inode == initInode {
+				continue
+			}
+
+			severity := SeverityWarning
+			if unsupported[nsType] {
+				severity = SeverityError
+			}
+			a.add(severity, "namespace", pid, fmt.Sprintf("runs in a nested %s namespace", nsType), "")
+		}
+	}
+}
+
+// Mounts docker sets up itself, which CRIU handles or skips
+var runtimeMounts = []string{"/proc", "/sys", "/dev"}
+
+func (a *analysis) checkMounts(initPID int, mappings []docker.MountMapping) error {
+	mounts, err := mountPoints(initPID)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{"/": true, "/etc/hostname": true, "/etc/hosts": true, "/etc/resolv.conf": true}
+	for _, mapping := range mappings {
+		known[filepath.Clean(mapping.ContainerPath)] = true
+	}
+
+mounts:
+	for _, mount := range mounts {
+		if known[mount.target] || mount.fsType == "tmpfs" || mount.fsType == "mqueue" {
+			continue
+		}
+		for _, prefix := range runtimeMounts {
+			if mount.target == prefix || strings.HasPrefix(mount.target, prefix+"/") {
+				continue mounts
+			}
+		}
+
+		a.add(SeverityWarning, "mount", 0,
+			fmt.Sprintf("%s (%s) is not in the container configuration, so it is not dumped as external", mount.target, mount.fsType), "")
+	}
+	return nil
+}
+
+// finish collects the flags the findings call for and sets the verdict.
+func (a *analysis) finish() {
+	flags := make(map[string]bool)
+	blocked := false
+	for _, finding := range a.report.Findings {
+		if finding.Flag != "" {
+			flags[finding.Flag] = true
+		} else if finding.Severity == SeverityError {
+			blocked = true
+		}
+	}
+
+	a.report.Flags = make([]string, 0, len(flags))
+	for flag := range flags {
+		a.report.Flags = append(a.report.Flags, flag)
+	}
+	sort.Strings(a.report.Flags)
+
+	switch {
+	case blocked:
+		a.report.Verdict = VerdictBlocked
+	case len(a.report.Flags) > 0:
+		a.report.Verdict = VerdictReadyWithFlags
+	default:
+		a.report.Verdict = VerdictReady
+	}
+}
+
+func checkpointCommand(container string, flags []string) string {
+	return strings.TrimSpace(fmt.Sprintf("docker-cr checkpoint %s %s", container, strings.Join(flags, " ")))
+}
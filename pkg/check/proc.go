@@ -0,0 +1,236 @@
+package check
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ProcessTree returns pid and all of its descendants, found by scanning the
+// parent PID of every process in /proc.
+func ProcessTree(pid int) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /proc: %w", err)
+	}
+
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if ppid, err := parentPID(child); err == nil {
+			children[ppid] = append(children[ppid], child)
+		}
+	}
+
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+	return tree, nil
+}
+
+func parentPID(pid int) (int, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name may contain spaces; the state and ppid follow the last ")"
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("malformed stat of PID %d", pid)
+	}
+	return strconv.Atoi(fields[1])
+}
+
+// statusField returns a field of /proc/<pid>/status, e.g. TracerPid.
+func statusField(pid int, name string) string {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), ":"); ok && key == name {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func processName(pid int) string {
+	if name := statusField(pid, "Name"); name != "" {
+		return name
+	}
+	return strconv.Itoa(pid)
+}
+
+// openFiles returns the targets of the descriptors of pid by fd number.
+func openFiles(pid int) (map[int]string, error) {
+	dir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list descriptors of PID %d: %w", pid, err)
+	}
+
+	files := make(map[int]string)
+	for _, entry := range entries {
+		fd, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if target, err := os.Readlink(filepath.Join(dir, entry.Name())); err == nil {
+			files[fd] = target
+		}
+	}
+	return files, nil
+}
+
+// socketInode extracts the inode from a "socket:[12345]" descriptor target.
+func socketInode(target string) (uint64, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	return inode, err == nil
+}
+
+// socketProtocol returns the kernel's protocol name of a socket descriptor,
+// e.g. UNIX, TCP or NETLINK.
+func socketProtocol(pid, fd int) string {
+	buf := make([]byte, 64)
+	n, err := syscall.Getxattr(fmt.Sprintf("/proc/%d/fd/%d", pid, fd), "system.sockprotoname", buf)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(buf[:n]), "\x00")
+}
+
+// tcpSocket is one row of /proc/net/tcp or tcp6.
+type tcpSocket struct {
+	local  string
+	remote string
+	state  string
+}
+
+const tcpEstablished = "01"
+
+// tcpSockets reads the TCP sockets of the network namespace of pid by inode.
+func tcpSockets(pid int) (map[uint64]tcpSocket, error) {
+	sockets := make(map[uint64]tcpSocket)
+	for _, name := range []string{"tcp", "tcp6"} {
+		file, err := os.Open(fmt.Sprintf("/proc/%d/net/%s", pid, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read TCP sockets: %w", err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 {
+				continue
+			}
+			inode, err := strconv.ParseUint(fields[9], 10, 64)
+			if err != nil || inode == 0 {
+				continue
+			}
+			sockets[inode] = tcpSocket{local: fields[1], remote: fields[2], state: fields[3]}
+		}
+		file.Close()
+	}
+	return sockets, nil
+}
+
+// unixSocketPaths reads the bound path of each Unix socket in the network
+// namespace of pid by inode. Unbound sockets are present with an empty path.
+func unixSocketPaths(pid int) (map[uint64]string, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/net/unix", pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Unix sockets: %w", err)
+	}
+	defer file.Close()
+
+	sockets := make(map[uint64]string)
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// Num RefCount Protocol Flags Type St Inode Path
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			continue
+		}
+		path := ""
+		if len(fields) > 7 {
+			path = fields[7]
+		}
+		sockets[inode] = path
+	}
+	return sockets, nil
+}
+
+// lockHolders returns the PIDs holding entries in /proc/locks.
+func lockHolders() (map[int]string, error) {
+	data, err := os.ReadFile("/proc/locks")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /proc/locks: %w", err)
+	}
+
+	holders := make(map[int]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		// 1: POSIX  ADVISORY  WRITE 1234 08:01:5678 0 EOF
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[1] == "->" {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[4]); err == nil {
+			holders[pid] = fields[1]
+		}
+	}
+	return holders, nil
+}
+
+// mountPoint is one row of /proc/<pid>/mountinfo.
+type mountPoint struct {
+	target string
+	fsType string
+}
+
+func mountPoints(pid int) ([]mountPoint, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/mountinfo", pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mounts of PID %d: %w", pid, err)
+	}
+	defer file.Close()
+
+	var mounts []mountPoint
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// id parent major:minor root target options [optional...] - fstype source super
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		fields := strings.Fields(pre)
+		if !ok || len(fields) < 5 {
+			continue
+		}
+		fsType, _, _ := strings.Cut(post, " ")
+		mounts = append(mounts, mountPoint{target: fields[4], fsType: fsType})
+	}
+	return mounts, scanner.Err()
+}
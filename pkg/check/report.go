@@ -0,0 +1,43 @@
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FormatReport renders a report as text or json.
+func FormatReport(report *Report, format string) (string, error) {
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal check report: %w", err)
+		}
+		return string(data) + "\n", nil
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("Container: %s (PID %d, %d processes)\n\n", report.Container, report.PID, report.Processes))
+
+	if len(report.Findings) == 0 {
+		output.WriteString("No problematic resources found.\n")
+	}
+	for _, finding := range report.Findings {
+		where := ""
+		if finding.PID > 0 {
+			where = fmt.Sprintf(" %s[%d]", finding.Process, finding.PID)
+		}
+		output.WriteString(fmt.Sprintf("%-8s %-12s%s: %s", strings.ToUpper(string(finding.Severity)), finding.Resource, where, finding.Detail))
+		if finding.Flag != "" {
+			output.WriteString(fmt.Sprintf(" (needs %s)", finding.Flag))
+		}
+		output.WriteString("\n")
+	}
+
+	output.WriteString(fmt.Sprintf("\nVerdict: %s\n", report.Verdict))
+	if report.Verdict != VerdictBlocked {
+		output.WriteString(fmt.Sprintf("Run: %s\n", report.Command))
+	}
+
+	return output.String(), nil
+}
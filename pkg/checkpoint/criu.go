@@ -1,11 +1,15 @@
 package checkpoint
 
 import (
+	"context"
 	"docker-cr/pkg/docker"
 	"docker-cr/pkg/utils"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 
 	criu "github.com/checkpoint-restore/go-criu/v7"
 	"github.com/checkpoint-restore/go-criu/v7/rpc"
@@ -14,52 +18,94 @@ import (
 )
 
 type CRIUManager struct {
-	criuClient *criu.Criu
-	logger     *logrus.Logger
+	criuPath string
+	logger   *logrus.Logger
 }
 
 type CheckpointOptions struct {
-	WorkDir         string   `json:"work_dir"`
-	ImagesDir       string   `json:"images_dir"`
-	LogFile         string   `json:"log_file"`
-	LogLevel        int      `json:"log_level"`
-	External        []string `json:"external"`
-	ManageCgroups   bool     `json:"manage_cgroups"`
-	TcpEstablished  bool     `json:"tcp_established"`
-	FileLocks       bool     `json:"file_locks"`
-	LeaveRunning    bool     `json:"leave_running"`
-	Shell           bool     `json:"shell"`
-	PreDump         bool     `json:"pre_dump"`
-	TrackMem        bool     `json:"track_mem"`
-}
-
-type RestoreOptions struct {
 	WorkDir        string   `json:"work_dir"`
 	ImagesDir      string   `json:"images_dir"`
 	LogFile        string   `json:"log_file"`
 	LogLevel       int      `json:"log_level"`
 	External       []string `json:"external"`
-	ExtMountMap    []string `json:"ext_mount_map"`
-	SkipMnt        []string `json:"skip_mnt"`
-	PidFile        string   `json:"pid_file"`
 	ManageCgroups  bool     `json:"manage_cgroups"`
 	TcpEstablished bool     `json:"tcp_established"`
-	RestoreSibling bool     `json:"restore_sibling"`
+	FileLocks      bool     `json:"file_locks"`
+	LeaveRunning   bool     `json:"leave_running"`
 	Shell          bool     `json:"shell"`
-	EmptyNs        uint32   `json:"empty_ns"`
+	PreDump        bool     `json:"pre_dump"`
+	TrackMem       bool     `json:"track_mem"`
+	ParentImg      string   `json:"parent_img"`    // relative to ImagesDir
+	FreezeCgroup   string   `json:"freeze_cgroup"` // freezer cgroup directory
+	Hooks          *Hooks   `json:"hooks,omitempty"`
 }
 
-func NewCRIUManager(logger *logrus.Logger) *CRIUManager {
-	criuClient := criu.MakeCriu()
-	criuClient.SetCriuPath("criu")
+type RestoreOptions struct {
+	WorkDir       string   `json:"work_dir"`
+	ImagesDir     string   `json:"images_dir"`
+	LogFile       string   `json:"log_file"`
+	LogLevel      int      `json:"log_level"`
+	External      []string `json:"external"`
+	ExtMountMap   []string `json:"ext_mount_map"`
+	SkipMnt       []string `json:"skip_mnt"`
+	PidFile       string   `json:"pid_file"`
+	ManageCgroups bool     `json:"manage_cgroups"`
+	// ManageCgroupsMode is soft (the default), full, strict or ignore
+	ManageCgroupsMode string `json:"manage_cgroups_mode,omitempty"`
+	// CgroupRoot moves the restored tasks' cgroups under this path
+	CgroupRoot        string             `json:"cgroup_root,omitempty"`
+	TcpEstablished    bool               `json:"tcp_established"`
+	TcpClose          bool               `json:"tcp_close"`
+	RestoreSibling    bool               `json:"restore_sibling"`
+	Shell             bool               `json:"shell"`
+	EmptyNs           uint32             `json:"empty_ns"`
+	InheritNamespaces []InheritNamespace `json:"inherit_namespaces,omitempty"`
+	JoinNamespaces    []JoinNamespace    `json:"join_namespaces,omitempty"`
+	Hooks             *Hooks             `json:"hooks,omitempty"`
+}
+
+// InheritNamespace hands CRIU an existing namespace to restore into in place
+// of one dumped as external under Key.
+type InheritNamespace struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+}
 
+// JoinNamespace restores the tasks into an existing namespace of Type, e.g.
+// the net namespace of the container they are restored into, instead of
+// recreating the dumped one.
+type JoinNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+// cgroupManageMode maps a --manage-cgroups mode name to its RPC value.
+func cgroupManageMode(name string) (rpc.CriuCgMode, error) {
+	switch name {
+	case "", "soft":
+		return rpc.CriuCgMode_SOFT, nil
+	case "full":
+		return rpc.CriuCgMode_FULL, nil
+	case "strict":
+		return rpc.CriuCgMode_STRICT, nil
+	case "ignore":
+		return rpc.CriuCgMode_IGNORE, nil
+	}
+	return 0, fmt.Errorf("unknown cgroup management mode %q", name)
+}
+
+// swrkMu serializes starting CRIU service workers so each request can tell
+// which child process is its own.
+var swrkMu sync.Mutex
+
+func NewCRIUManager(logger *logrus.Logger) *CRIUManager {
 	return &CRIUManager{
-		criuClient: criuClient,
-		logger:     logger,
+		criuPath: "criu",
+		logger:   logger,
 	}
 }
 
-func (cm *CRIUManager) CheckpointProcess(pid int, opts CheckpointOptions) error {
+func (cm *CRIUManager) CheckpointProcess(ctx context.Context, pid int, opts CheckpointOptions) error {
 	cm.logger.Infof("Starting CRIU checkpoint for PID %d", pid)
 
 	// Ensure directories exist
@@ -73,18 +119,18 @@ func (cm *CRIUManager) CheckpointProcess(pid int, opts CheckpointOptions) error
 
 	// Build CRIU options with proper Docker-specific settings
 	criuOpts := &rpc.CriuOpts{
-		Pid:                proto.Int32(int32(pid)),
-		LogLevel:           proto.Int32(int32(opts.LogLevel)),
-		LogFile:            proto.String(opts.LogFile),
-		ManageCgroups:      proto.Bool(opts.ManageCgroups),
-		TcpEstablished:     proto.Bool(opts.TcpEstablished),
-		FileLocks:          proto.Bool(opts.FileLocks),
-		LeaveRunning:       proto.Bool(opts.LeaveRunning),
-		ShellJob:           proto.Bool(opts.Shell),
-		External:           opts.External,
-		ExtUnixSk:          proto.Bool(true),
-		GhostLimit:         proto.Uint32(0),
-		ManageCgroupsMode:  rpc.CriuCgMode_SOFT.Enum(),
+		Pid:               proto.Int32(int32(pid)),
+		LogLevel:          proto.Int32(int32(opts.LogLevel)),
+		LogFile:           proto.String(opts.LogFile),
+		ManageCgroups:     proto.Bool(opts.ManageCgroups),
+		TcpEstablished:    proto.Bool(opts.TcpEstablished),
+		FileLocks:         proto.Bool(opts.FileLocks),
+		LeaveRunning:      proto.Bool(opts.LeaveRunning),
+		ShellJob:          proto.Bool(opts.Shell),
+		External:          opts.External,
+		ExtUnixSk:         proto.Bool(true),
+		GhostLimit:        proto.Uint32(0),
+		ManageCgroupsMode: rpc.CriuCgMode_SOFT.Enum(),
 	}
 
 	// Set working directory
@@ -105,28 +151,57 @@ func (cm *CRIUManager) CheckpointProcess(pid int, opts CheckpointOptions) error
 
 	criuOpts.ImagesDirFd = proto.Int32(int32(imagesDir.Fd()))
 
+	if opts.ParentImg != "" {
+		criuOpts.ParentImg = proto.String(opts.ParentImg)
+	}
+
+	if opts.FreezeCgroup != "" {
+		criuOpts.FreezeCgroup = proto.String(opts.FreezeCgroup)
+	}
+
 	// Pre-dump if requested
 	if opts.PreDump {
 		cm.logger.Info("Performing pre-dump...")
-		preDumpOpts := *criuOpts
+		preDumpOpts := proto.Clone(criuOpts).(*rpc.CriuOpts)
 		preDumpOpts.TrackMem = proto.Bool(opts.TrackMem)
 		preDumpOpts.TcpEstablished = proto.Bool(false)
 
-		if err := cm.criuClient.PreDump(&preDumpOpts, nil); err != nil {
+		err := cm.runCRIU(ctx, func(c *criu.Criu) error {
+			return c.PreDump(preDumpOpts, nil)
+		})
+		if err != nil {
 			return fmt.Errorf("pre-dump failed: %w", err)
 		}
 	}
 
+	// Track memory changes so this dump can serve as a parent later
+	criuOpts.TrackMem = proto.Bool(opts.TrackMem)
+
 	// Perform checkpoint
 	cm.logger.Info("Performing checkpoint...")
-	if err := cm.criuClient.Dump(criuOpts, nil); err != nil {
-		// Try to read and log CRIU error details
-		cm.logCRIUError(opts.LogFile)
+	err = cm.runCRIU(ctx, func(c *criu.Criu) error {
+		return c.Dump(criuOpts, cm.notifier(ctx, opts.Hooks))
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("checkpoint interrupted: %w", err)
+		}
+
+		criuErr := cm.diagnose("dump", opts.LogFile, err)
+
+		// A second attempt only makes sense on a task that survived the first,
+		// and for a failure that is not inherent to the container
+		if !processAlive(pid) {
+			return fmt.Errorf("CRIU dump failed and process %d is gone: %w", pid, criuErr)
+		}
+		if criuErr.Known {
+			return criuErr
+		}
 
 		// Try command-line fallback
 		cm.logger.Warnf("go-criu library failed, trying command-line fallback: %v", err)
-		if cmdErr := cm.CheckpointProcessCmd(pid, opts); cmdErr != nil {
-			return fmt.Errorf("both go-criu and command-line CRIU failed.\nLibrary error: %w\nCommand error: %v", err, cmdErr)
+		if cmdErr := cm.CheckpointProcessCmd(ctx, pid, opts); cmdErr != nil {
+			return fmt.Errorf("both go-criu and command-line CRIU failed.\nLibrary error: %w\nCommand error: %v", criuErr, cmdErr)
 		}
 
 		cm.logger.Info("CRIU checkpoint completed successfully via command-line")
@@ -137,7 +212,13 @@ func (cm *CRIUManager) CheckpointProcess(pid int, opts CheckpointOptions) error
 	return nil
 }
 
-func (cm *CRIUManager) RestoreProcess(opts RestoreOptions) error {
+func (cm *CRIUManager) RestoreProcess(ctx context.Context, opts RestoreOptions) error {
+	// go-criu cannot pass file descriptors to its CRIU worker, so inherited
+	// namespaces need the command-line path
+	if len(opts.InheritNamespaces) > 0 {
+		return cm.RestoreProcessCmd(ctx, opts)
+	}
+
 	cm.logger.Info("Starting CRIU restore")
 
 	// Ensure directories exist
@@ -155,12 +236,27 @@ func (cm *CRIUManager) RestoreProcess(opts RestoreOptions) error {
 		LogFile:        proto.String(opts.LogFile),
 		ManageCgroups:  proto.Bool(opts.ManageCgroups),
 		TcpEstablished: proto.Bool(opts.TcpEstablished),
+		TcpClose:       proto.Bool(opts.TcpClose),
 		RstSibling:     proto.Bool(opts.RestoreSibling),
 		ShellJob:       proto.Bool(opts.Shell),
 		External:       opts.External,
 		EmptyNs:        proto.Uint32(opts.EmptyNs),
 	}
 
+	if opts.ManageCgroups {
+		mode, err := cgroupManageMode(opts.ManageCgroupsMode)
+		if err != nil {
+			return err
+		}
+		criuOpts.ManageCgroupsMode = &mode
+	}
+	if opts.CgroupRoot != "" {
+		criuOpts.CgRoot = []*rpc.CgroupRoot{{Path: proto.String(opts.CgroupRoot)}}
+	}
+	for _, ns := range opts.JoinNamespaces {
+		criuOpts.JoinNs = append(criuOpts.JoinNs, &rpc.JoinNamespace{Ns: proto.String(ns.Type), NsFile: proto.String(ns.Path)})
+	}
+
 	// Set images directory
 	workDir, err := os.Open(opts.ImagesDir)
 	if err != nil {
@@ -178,10 +274,15 @@ func (cm *CRIUManager) RestoreProcess(opts RestoreOptions) error {
 
 	// Perform restore
 	cm.logger.Info("Performing restore...")
-	if err := cm.criuClient.Restore(criuOpts, nil); err != nil {
-		// Try to read and log CRIU error details
-		cm.logCRIUError(opts.LogFile)
-		return fmt.Errorf("CRIU restore failed: %w", err)
+	err = cm.runCRIU(ctx, func(c *criu.Criu) error {
+		return c.Restore(criuOpts, cm.notifier(ctx, opts.Hooks))
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("restore interrupted: %w", err)
+		}
+
+		return cm.diagnose("restore", opts.LogFile, err)
 	}
 
 	cm.logger.Info("CRIU restore completed successfully")
@@ -212,9 +313,9 @@ func (cm *CRIUManager) BuildExternalMountMappings(mappings []docker.MountMapping
 	// Add user-defined volume mounts
 	for _, mapping := range mappings {
 		if mapping.IsExternal && mapping.HostPath != "" &&
-		   !strings.HasPrefix(mapping.ContainerPath, "/proc") &&
-		   !strings.HasPrefix(mapping.ContainerPath, "/sys") &&
-		   !strings.HasPrefix(mapping.ContainerPath, "/dev") {
+			!strings.HasPrefix(mapping.ContainerPath, "/proc") &&
+			!strings.HasPrefix(mapping.ContainerPath, "/sys") &&
+			!strings.HasPrefix(mapping.ContainerPath, "/dev") {
 			// Add user volumes
 			extMount := fmt.Sprintf("mnt[%s]", mapping.ContainerPath)
 			external = append(external, extMount)
@@ -244,8 +345,9 @@ func (cm *CRIUManager) ValidateMountSources(mappings []docker.MountMapping) erro
 			if !utils.FileExists(mapping.HostPath) && !utils.DirExists(mapping.HostPath) {
 				cm.logger.Warnf("Mount source does not exist, will create placeholder: %s", mapping.HostPath)
 
-				// Create placeholder directory
-				if err := utils.EnsureDir(mapping.HostPath); err != nil {
+				// Create placeholder directory; unlike checkpoint data it is
+				// seen by the container and keeps the usual permissions
+				if err := os.MkdirAll(mapping.HostPath, 0755); err != nil {
 					return fmt.Errorf("failed to create mount source placeholder %s: %w", mapping.HostPath, err)
 				}
 			}
@@ -283,16 +385,100 @@ func (cm *CRIUManager) CreateExtMountMapFile(mappings []docker.MountMapping, fil
 	return utils.WriteFile(filePath, []byte(content))
 }
 
-func (cm *CRIUManager) logCRIUError(logFile string) {
-	if logFile == "" {
-		return
+// runCRIU runs one go-criu request on a dedicated CRIU service worker. When
+// ctx is done the worker is killed, which makes CRIU release (un-ptrace) the
+// tasks it had seized.
+func (cm *CRIUManager) runCRIU(ctx context.Context, request func(c *criu.Criu) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	client := criu.MakeCriu()
+	client.SetCriuPath(cm.criuPath)
+
+	swrkPID, err := cm.prepareSwrk(client)
+	if err != nil {
+		return fmt.Errorf("failed to start CRIU: %w", err)
+	}
+	defer client.Cleanup()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- request(client)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if swrkPID > 0 {
+			cm.logger.Warnf("Interrupting CRIU (PID %d): %v", swrkPID, ctx.Err())
+			syscall.Kill(swrkPID, syscall.SIGKILL)
+		} else {
+			cm.logger.Warnf("Cannot interrupt CRIU, waiting for it to finish: %v", ctx.Err())
+		}
+		<-done
+		return ctx.Err()
+	}
+}
+
+// prepareSwrk starts the CRIU service worker of client and returns its PID,
+// or 0 if it could not be identified.
+func (cm *CRIUManager) prepareSwrk(client *criu.Criu) (int, error) {
+	swrkMu.Lock()
+	defer swrkMu.Unlock()
+
+	before := swrkChildren()
+	if err := client.Prepare(); err != nil {
+		return 0, err
+	}
+
+	for pid := range swrkChildren() {
+		if !before[pid] {
+			return pid, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// swrkChildren lists child processes of ours running "criu swrk".
+func swrkChildren() map[int]bool {
+	children := make(map[int]bool)
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return children
 	}
 
-	if utils.FileExists(logFile) {
-		if logData, err := utils.ReadFile(logFile); err == nil {
-			cm.logger.Errorf("CRIU error log:\n%s", string(logData))
+	self := os.Getpid()
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			continue
+		}
+
+		// The command name may contain spaces; fields resume after ")"
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+		if len(fields) < 2 || fields[1] != strconv.Itoa(self) {
+			continue
+		}
+
+		cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		if err != nil {
+			continue
+		}
+		if args := strings.Split(string(cmdline), "\x00"); len(args) > 1 && args[1] == "swrk" {
+			children[pid] = true
 		}
 	}
+
+	return children
 }
 
 func (cm *CRIUManager) GetCRIUVersion() (string, error) {
@@ -308,7 +494,7 @@ func (cm *CRIUManager) CheckCRIUSupport() error {
 
 	// Check common CRIU installation paths
 	criuPaths := []string{
-		"/usr/sbin/criu",      // Common on Ubuntu/Debian
+		"/usr/sbin/criu",       // Common on Ubuntu/Debian
 		"/usr/bin/criu",        // Alternative location
 		"/usr/local/bin/criu",  // Manually installed
 		"/usr/local/sbin/criu", // Alternative manual install
@@ -323,4 +509,4 @@ func (cm *CRIUManager) CheckCRIUSupport() error {
 	}
 
 	return fmt.Errorf("CRIU binary not found in standard locations: %v", criuPaths)
-}
\ No newline at end of file
+}
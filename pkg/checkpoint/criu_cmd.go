@@ -1,28 +1,51 @@
 package checkpoint
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 )
 
 // CheckpointProcessCmd performs checkpoint using CRIU command-line tool
 // This is an alternative to the go-criu library approach
-func (cm *CRIUManager) CheckpointProcessCmd(pid int, opts CheckpointOptions) error {
+func (cm *CRIUManager) CheckpointProcessCmd(ctx context.Context, pid int, opts CheckpointOptions) error {
 	cm.logger.Infof("Starting CRIU checkpoint via command for PID %d", pid)
 
-	// Build CRIU command arguments
+	args := cm.CheckpointCmdArgs(pid, opts)
+
+	// Execute CRIU command
+	// The context kills CRIU when the operation is cancelled or times out
+	cmd := exec.CommandContext(ctx, cm.criuPath, args...)
+	cmd.Dir = opts.WorkDir
+	if !opts.Hooks.empty() {
+		cmd.Env = opts.Hooks.environ()
+	}
+
+	cm.logger.Debugf("Executing: criu %s", strings.Join(args, " "))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		cm.logger.Debugf("CRIU command output: %s", string(output))
+		return cm.diagnose("dump", opts.LogFile, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output))))
+	}
+
+	cm.logger.Info("CRIU checkpoint completed successfully")
+	return nil
+}
+
+// CheckpointCmdArgs builds the arguments of the criu dump command for pid.
+func (cm *CRIUManager) CheckpointCmdArgs(pid int, opts CheckpointOptions) []string {
 	args := []string{
 		"dump",
 		"-t", fmt.Sprintf("%d", pid),
 		"-D", opts.ImagesDir,
 		"--log-file", opts.LogFile,
-		"-v4",  // Verbose logging
-		"--tcp-established",
-		"--file-locks",
+		fmt.Sprintf("-v%d", opts.LogLevel),
+		"--ext-unix-sk",
 		"--link-remap",
 		"--force-irmap",
-		"--manage-cgroups",
 		"--enable-external-sharing",
 		"--enable-external-masters",
 		"--enable-fs", "hugetlbfs",
@@ -33,34 +56,49 @@ func (cm *CRIUManager) CheckpointProcessCmd(pid int, opts CheckpointOptions) err
 		"--skip-mnt", "/run",
 	}
 
+	// Only request what the library path would have, so the fallback does
+	// not dump (or fail on) state the caller did not ask for
+	if opts.TcpEstablished {
+		args = append(args, "--tcp-established")
+	}
+	if opts.FileLocks {
+		args = append(args, "--file-locks")
+	}
+	if opts.ManageCgroups {
+		args = append(args, "--manage-cgroups=soft")
+	}
+	if opts.Shell {
+		args = append(args, "--shell-job")
+	}
+
 	// Add leave-running if specified
 	if opts.LeaveRunning {
 		args = append(args, "--leave-running")
 	}
 
+	// Add incremental dump options
+	if opts.TrackMem {
+		args = append(args, "--track-mem")
+	}
+	if opts.ParentImg != "" {
+		args = append(args, "--prev-images-dir", opts.ParentImg)
+	}
+	if opts.FreezeCgroup != "" {
+		args = append(args, "--freeze-cgroup", opts.FreezeCgroup)
+	}
+
 	// Add external mounts
 	for _, ext := range opts.External {
 		args = append(args, "--external", ext)
 	}
 
-	// Execute CRIU command
-	cmd := exec.Command("criu", args...)
-	cmd.Dir = opts.WorkDir
-
-	cm.logger.Debugf("Executing: criu %s", strings.Join(args, " "))
-
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		cm.logger.Errorf("CRIU command failed: %s", string(output))
-		return fmt.Errorf("CRIU checkpoint failed: %w\nOutput: %s", err, string(output))
-	}
+	args = append(args, cm.actionScriptArgs(opts.Hooks)...)
 
-	cm.logger.Info("CRIU checkpoint completed successfully")
-	return nil
+	return args
 }
 
 // RestoreProcessCmd performs restore using CRIU command-line tool
-func (cm *CRIUManager) RestoreProcessCmd(opts RestoreOptions) error {
+func (cm *CRIUManager) RestoreProcessCmd(ctx context.Context, opts RestoreOptions) error {
 	cm.logger.Info("Starting CRIU restore via command")
 
 	// Build CRIU restore command arguments
@@ -68,20 +106,34 @@ func (cm *CRIUManager) RestoreProcessCmd(opts RestoreOptions) error {
 		"restore",
 		"-D", opts.ImagesDir,
 		"--log-file", opts.LogFile,
-		"-v4",
-		"--tcp-established",
-		"--file-locks",
+		fmt.Sprintf("-v%d", opts.LogLevel),
+		"--restore-detached",
+		"--ext-unix-sk",
 		"--link-remap",
-		"--manage-cgroups",
 		"--enable-external-sharing",
 		"--enable-external-masters",
 		"--enable-fs", "hugetlbfs",
 		"--enable-fs", "tracefs",
 	}
 
-	// Add restore-sibling if specified
-	if opts.RestoreSibling {
-		args = append(args, "--restore-sibling")
+	// Mirror the options the library path would set
+	if opts.TcpEstablished {
+		args = append(args, "--tcp-established")
+	}
+	if opts.ManageCgroups {
+		mode := opts.ManageCgroupsMode
+		if mode == "" {
+			mode = "soft"
+		}
+		args = append(args, "--manage-cgroups="+mode)
+	}
+	if opts.CgroupRoot != "" {
+		args = append(args, "--cgroup-root", opts.CgroupRoot)
+	}
+
+	// Connections cannot survive an address change
+	if opts.TcpClose {
+		args = append(args, "--tcp-close")
 	}
 
 	// Add shell-job if specified
@@ -103,16 +155,41 @@ func (cm *CRIUManager) RestoreProcessCmd(opts RestoreOptions) error {
 		args = append(args, "--ext-mount-map", mapping)
 	}
 
+	args = append(args, JoinNsArgs(opts.JoinNamespaces)...)
+
+	// Shared namespaces are handed to CRIU as inherited descriptors
+	var extraFiles []*os.File
+	defer func() {
+		for _, file := range extraFiles {
+			file.Close()
+		}
+	}()
+	for _, ns := range opts.InheritNamespaces {
+		file, err := os.Open(ns.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open namespace %s: %w", ns.Path, err)
+		}
+		extraFiles = append(extraFiles, file)
+	}
+	// ExtraFiles start at descriptor 3 in the child
+	args = append(args, InheritFdArgs(opts.InheritNamespaces, 3)...)
+
+	args = append(args, cm.actionScriptArgs(opts.Hooks)...)
+
 	// Execute CRIU command
-	cmd := exec.Command("criu", args...)
+	cmd := exec.CommandContext(ctx, cm.criuPath, args...)
 	cmd.Dir = opts.WorkDir
+	cmd.ExtraFiles = extraFiles
+	if !opts.Hooks.empty() {
+		cmd.Env = opts.Hooks.environ()
+	}
 
 	cm.logger.Debugf("Executing: criu %s", strings.Join(args, " "))
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		cm.logger.Errorf("CRIU restore command failed: %s", string(output))
-		return fmt.Errorf("CRIU restore failed: %w\nOutput: %s", err, string(output))
+		cm.logger.Debugf("CRIU command output: %s", string(output))
+		return cm.diagnose("restore", opts.LogFile, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output))))
 	}
 
 	cm.logger.Info("CRIU restore completed successfully")
@@ -120,9 +197,9 @@ func (cm *CRIUManager) RestoreProcessCmd(opts RestoreOptions) error {
 }
 
 // TryCommandLineFallback attempts to use command-line CRIU if go-criu fails
-func (cm *CRIUManager) TryCommandLineFallback(pid int, opts CheckpointOptions) error {
+func (cm *CRIUManager) TryCommandLineFallback(ctx context.Context, pid int, opts CheckpointOptions) error {
 	cm.logger.Warn("Falling back to command-line CRIU execution")
-	return cm.CheckpointProcessCmd(pid, opts)
+	return cm.CheckpointProcessCmd(ctx, pid, opts)
 }
 
 // BuildCRIUCommandArgs builds CRIU command arguments for debugging
@@ -0,0 +1,186 @@
+package checkpoint
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CRIULogLine is one parsed line of a CRIU dump.log or restore.log, e.g.
+// "(00.012345)      1: Error (criu/mount.c:1234): mnt: ...".
+type CRIULogLine struct {
+	Time    float64 `json:"time"`
+	PID     int     `json:"pid,omitempty"`
+	Level   string  `json:"level"` // "error", "warn" or "info"
+	Source  string  `json:"source,omitempty"`
+	Message string  `json:"message"`
+}
+
+var criuLogLineRegex = regexp.MustCompile(`^\((\d+\.\d+)\)\s+(?:(\d+):\s+)?(?:(Error|Warn)\s*\(([^)]*)\):\s*)?(.*)$`)
+
+// ParseCRIULog parses the lines of a CRIU log. Lines without CRIU's
+// timestamp prefix are skipped.
+func ParseCRIULog(data []byte) []CRIULogLine {
+	var lines []CRIULogLine
+	for _, raw := range strings.Split(string(data), "\n") {
+		match := criuLogLineRegex.FindStringSubmatch(raw)
+		if match == nil {
+			continue
+		}
+
+		line := CRIULogLine{Level: "info", Source: match[4], Message: strings.TrimSpace(match[5])}
+		line.Time, _ = strconv.ParseFloat(match[1], 64)
+		line.PID, _ = strconv.Atoi(match[2])
+		if match[3] != "" {
+			line.Level = strings.ToLower(match[3])
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// criuFailure is a known CRIU failure signature. The hint may refer to the
+// first submatch of pattern as $1.
+type criuFailure struct {
+	pattern *regexp.Regexp
+	cause   string
+	hint    string
+}
+
+var criuFailures = []criuFailure{
+	{
+		regexp.MustCompile(`Connected TCP socket`),
+		"the container has established TCP connections",
+		"add --tcp",
+	},
+	{
+		regexp.MustCompile(`file locks.*without --file-locks`),
+		"processes hold file locks",
+		"add --file-locks",
+	},
+	{
+		regexp.MustCompile(`(?i)shell-job|session leader`),
+		"a process is attached to a terminal outside the container",
+		"add --shell",
+	},
+	{
+		regexp.MustCompile(`(?i)external socket|unix socket .*without peer|Can't dump half of stream unix`),
+		"a Unix socket is connected to a peer outside the container",
+		"close the connection before checkpointing or dump its peer too (docker-cr check lists them)",
+	},
+	{
+		regexp.MustCompile(`FS mnt (\S+) .*unsupported|Unsupported fs type .* at (\S+)`),
+		"a mount uses a filesystem CRIU cannot dump",
+		"bind-mount $1 into the container from the host so it is dumped as an external mount",
+	},
+	{
+		regexp.MustCompile(`(?:Can't mount at|Can't open mount point|Unable to find mount point)\s+\.?(\S+?):?\s`),
+		"a mount point is missing on this host",
+		"create the mount source, or use --remap-mount $1=<host-path>",
+	},
+	{
+		regexp.MustCompile(`(?i)(?:pid|can't fork for) (\d+).*(?:busy|exists|do not match)`),
+		"PID $1 is already in use",
+		"stop the process holding PID $1 or restore into a fresh PID namespace",
+	},
+	{
+		regexp.MustCompile(`(?i)can't seize task|unable to interrupt task|being traced`),
+		"a task could not be seized, e.g. because a debugger is attached",
+		"detach any debugger or strace from the container",
+	},
+	{
+		regexp.MustCompile(`(?i)io_uring|bpf (?:map|prog)|perf_event`),
+		"the container uses a kernel resource CRIU cannot dump",
+		"",
+	},
+}
+
+// CRIUError is a failed dump or restore with the likely cause found in the
+// CRIU log.
+type CRIUError struct {
+	Op    string `json:"op"`
+	Cause string `json:"cause"`
+	Hint  string `json:"hint,omitempty"`
+	// Known is set when the cause matched a known failure signature
+	Known   bool          `json:"known"`
+	LogFile string        `json:"log_file,omitempty"`
+	Errors  []CRIULogLine `json:"errors,omitempty"`
+	Err     error         `json:"-"`
+}
+
+func (e *CRIUError) Error() string {
+	msg := fmt.Sprintf("CRIU %s failed: %s", e.Op, e.Cause)
+	if e.Hint != "" {
+		msg += " (hint: " + e.Hint + ")"
+	}
+	if e.LogFile != "" {
+		msg += "; see " + e.LogFile
+	}
+	return msg
+}
+
+func (e *CRIUError) Unwrap() error {
+	return e.Err
+}
+
+// AnalyzeCRIULog explains a failed CRIU run from its log. The first error
+// matching a known signature wins; otherwise the first error is the cause.
+func AnalyzeCRIULog(op string, data []byte, err error) *CRIUError {
+	criuErr := &CRIUError{Op: op, Cause: "unknown error", Err: err}
+	if err != nil {
+		criuErr.Cause = err.Error()
+	}
+
+	for _, line := range ParseCRIULog(data) {
+		if line.Level == "error" {
+			criuErr.Errors = append(criuErr.Errors, line)
+		}
+	}
+
+	for _, line := range criuErr.Errors {
+		for _, failure := range criuFailures {
+			match := failure.pattern.FindStringSubmatch(line.Message)
+			if match == nil {
+				continue
+			}
+
+			arg := ""
+			for _, group := range match[1:] {
+				if group != "" {
+					arg = group
+					break
+				}
+			}
+			criuErr.Cause = strings.ReplaceAll(failure.cause, "$1", arg)
+			criuErr.Hint = strings.ReplaceAll(failure.hint, "$1", arg)
+			criuErr.Known = true
+			return criuErr
+		}
+	}
+
+	if len(criuErr.Errors) > 0 {
+		criuErr.Cause = criuErr.Errors[0].Message
+	}
+	return criuErr
+}
+
+// diagnose turns a failed CRIU run into a *CRIUError. Only the error lines of
+// the log are shown; the full log is logged at debug level (-v).
+func (cm *CRIUManager) diagnose(op, logFile string, err error) *CRIUError {
+	data, readErr := os.ReadFile(logFile)
+	if readErr != nil {
+		cm.logger.Warnf("Cannot read CRIU log %s: %v", logFile, readErr)
+	}
+
+	criuErr := AnalyzeCRIULog(op, data, err)
+	criuErr.LogFile = logFile
+
+	cm.logger.Debugf("CRIU %s log:\n%s", op, string(data))
+	for _, line := range criuErr.Errors {
+		cm.logger.Errorf("CRIU: %s", line.Message)
+	}
+
+	return criuErr
+}
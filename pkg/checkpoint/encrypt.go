@@ -0,0 +1,538 @@
+package checkpoint
+
+import (
+	"archive/tar"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"docker-cr/pkg/utils"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// An encrypted checkpoint directory holds only these two files: the
+// description of the encryption and the encrypted tarball of everything else.
+const (
+	EncryptionFile   = "encryption.json"
+	encryptedArchive = "checkpoint.tar.enc"
+)
+
+const (
+	encryptionMagic   = "DCRENC01"
+	encryptionChunk   = 1 << 20
+	encryptionKeySize = 32
+	pbkdf2Iterations  = 600000
+
+	kdfNone   = "none"
+	kdfPBKDF2 = "pbkdf2-sha256"
+)
+
+// EncryptionKey names the secret of an encrypted checkpoint: either a file
+// with a 32-byte key (raw, hex or base64) or a file with a passphrase.
+type EncryptionKey struct {
+	KeyFile        string `json:"key_file,omitempty"`
+	PassphraseFile string `json:"passphrase_file,omitempty"`
+}
+
+// EncryptionInfo is kept in plain text next to the ciphertext, with just
+// enough about the checkpoint to list and prune it without the key.
+type EncryptionInfo struct {
+	Version          int    `json:"version"`
+	Cipher           string `json:"cipher"`
+	KDF              string `json:"kdf"`
+	Salt             []byte `json:"salt"`
+	Iterations       int    `json:"iterations,omitempty"`
+	Container        string `json:"container"`
+	CreatedAt        string `json:"created_at"`
+	ParentCheckpoint string `json:"parent_checkpoint,omitempty"`
+}
+
+// IsEncrypted reports whether checkpointDir holds an encrypted checkpoint.
+func IsEncrypted(checkpointDir string) bool {
+	return utils.FileExists(filepath.Join(checkpointDir, EncryptionFile))
+}
+
+// Validate checks that exactly one secret is given and can be read.
+func (k *EncryptionKey) Validate() error {
+	switch {
+	case k.KeyFile != "" && k.PassphraseFile != "":
+		return fmt.Errorf("a key file and a passphrase file cannot be used together")
+	case k.KeyFile != "":
+		_, err := readKeyFile(k.KeyFile)
+		return err
+	case k.PassphraseFile != "":
+		_, err := readPassphrase(k.PassphraseFile)
+		return err
+	}
+	return fmt.Errorf("a key file or a passphrase file is required")
+}
+
+// deriveKey returns the data key of a checkpoint. Every checkpoint has its
+// own random salt and so its own data key, which keeps the chunk counter
+// nonces unique even when one key file is used for all checkpoints.
+func (k *EncryptionKey) deriveKey(info *EncryptionInfo) ([]byte, error) {
+	var master []byte
+	switch info.KDF {
+	case kdfNone:
+		if k.KeyFile == "" {
+			return nil, fmt.Errorf("checkpoint is encrypted with a key file")
+		}
+		key, err := readKeyFile(k.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		master = key
+	case kdfPBKDF2:
+		if k.PassphraseFile == "" {
+			return nil, fmt.Errorf("checkpoint is encrypted with a passphrase")
+		}
+		if info.Iterations < 1 {
+			return nil, fmt.Errorf("invalid iteration count %d", info.Iterations)
+		}
+		passphrase, err := readPassphrase(k.PassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		master = pbkdf2.Key(passphrase, info.Salt, info.Iterations, encryptionKeySize, sha256.New)
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", info.KDF)
+	}
+
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(encryptionMagic))
+	mac.Write(info.Salt)
+	return mac.Sum(nil), nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == encryptionKeySize {
+		return data, nil
+	}
+
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s must hold a %d-byte key, raw or hex or base64 encoded", path, encryptionKeySize)
+}
+
+func readPassphrase(path string) ([]byte, error) {
+	data, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	passphrase := strings.TrimRight(string(data), "\r\n")
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase file %s is empty", path)
+	}
+	return []byte(passphrase), nil
+}
+
+func readEncryptionInfo(checkpointDir string) (*EncryptionInfo, error) {
+	data, err := utils.ReadFile(filepath.Join(checkpointDir, EncryptionFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var info EncryptionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", EncryptionFile, err)
+	}
+	if info.Version != 1 || info.Cipher != "AES-256-GCM" {
+		return nil, fmt.Errorf("unsupported encryption %s version %d", info.Cipher, info.Version)
+	}
+	return &info, nil
+}
+
+// EncryptCheckpoint replaces the images and metadata of a checkpoint with an
+// AES-256-GCM encrypted tarball of them.
+func (m *Manager) EncryptCheckpoint(checkpointDir string, key EncryptionKey) error {
+	if IsEncrypted(checkpointDir) {
+		return fmt.Errorf("checkpoint is already encrypted: %s", checkpointDir)
+	}
+	if err := key.Validate(); err != nil {
+		return err
+	}
+
+	metadata, err := m.GetCheckpointInfo(checkpointDir)
+	if err != nil {
+		return err
+	}
+
+	info := &EncryptionInfo{
+		Version:   1,
+		Cipher:    "AES-256-GCM",
+		KDF:       kdfNone,
+		Salt:      make([]byte, 16),
+		CreatedAt: metadata.CreatedAt,
+	}
+	if key.PassphraseFile != "" {
+		info.KDF = kdfPBKDF2
+		info.Iterations = pbkdf2Iterations
+	}
+	if metadata.ContainerState != nil {
+		info.Container = metadata.ContainerState.Name
+	}
+	if parent := resolveParentCheckpoint(checkpointDir); parent != "" {
+		if info.ParentCheckpoint, err = filepath.Abs(parent); err != nil {
+			return fmt.Errorf("failed to resolve parent checkpoint: %w", err)
+		}
+	}
+	if _, err := rand.Read(info.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	dataKey, err := key.deriveKey(info)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(checkpointDir)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint directory: %w", err)
+	}
+
+	if err := writeEncryptedArchive(checkpointDir, dataKey); err != nil {
+		return fmt.Errorf("failed to encrypt checkpoint: %w", err)
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal encryption info: %w", err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(checkpointDir, EncryptionFile), data); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(checkpointDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove unencrypted %s: %w", entry.Name(), err)
+		}
+	}
+
+	m.logger.Infof("Encrypted checkpoint %s", checkpointDir)
+	return nil
+}
+
+// writeEncryptedArchive writes the encrypted tarball next to the checkpoint
+// first, so it never ends up inside itself, and then moves it in.
+func writeEncryptedArchive(checkpointDir string, key []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filepath.Clean(checkpointDir)), "."+filepath.Base(checkpointDir)+".enc-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	cw, err := newChunkWriter(tmp, key)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(cw)
+	if err := writeTree(tw, checkpointDir, "", nil); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(checkpointDir, encryptedArchive))
+}
+
+// DecryptCheckpoint decrypts an encrypted checkpoint into a new hidden
+// directory next to it that only the current user can read. cleanup removes
+// it again.
+func (m *Manager) DecryptCheckpoint(checkpointDir string, key EncryptionKey) (string, func(), error) {
+	info, err := readEncryptionInfo(checkpointDir)
+	if err != nil {
+		return "", nil, err
+	}
+	dataKey, err := key.deriveKey(info)
+	if err != nil {
+		return "", nil, err
+	}
+
+	absDir, err := filepath.Abs(checkpointDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve checkpoint directory: %w", err)
+	}
+
+	tmpDir, err := workingCopyDir(absDir)
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			m.logger.Warnf("Failed to remove decrypted checkpoint %s: %v", tmpDir, err)
+		}
+	}
+
+	// Keep the checkpoint's name for anything that shows it
+	dir := filepath.Join(tmpDir, filepath.Base(absDir))
+	if err := decryptArchive(filepath.Join(checkpointDir, encryptedArchive), dir, absDir, dataKey); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to decrypt checkpoint: %w", err)
+	}
+
+	if parent := resolveParentCheckpoint(dir); parent != "" && (IsEncrypted(parent) || IsStored(parent)) {
+		cleanup()
+		return "", nil, fmt.Errorf("parent checkpoint %s is encrypted or in the chunk store", parent)
+	}
+
+	m.logger.Debugf("Decrypted %s into %s", checkpointDir, dir)
+	return dir, cleanup, nil
+}
+
+func decryptArchive(archivePath, dir, originalDir string, key []byte) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cr, err := newChunkReader(file, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(cr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		target := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// Relative links such as the parent of an incremental dump point
+			// next to the original checkpoint, not into the temporary copy
+			link := header.Linkname
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(originalDir, filepath.Dir(name), link)
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry %s in archive", header.Name)
+		}
+	}
+
+	// The tar reader stops at its end marker; reading on checks the final chunk
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return err
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The encrypted stream is the magic followed by chunks of a flag byte, the
+// sealed length and the sealed data. Chunk nonces count the chunks and the
+// flag marks the last one, so reordered, dropped or truncated chunks fail to
+// decrypt.
+func chunkNonce(aead cipher.AEAD, n uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], n)
+	return nonce
+}
+
+func chunkAAD(flag byte) []byte {
+	return append([]byte(encryptionMagic), flag)
+}
+
+type chunkWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	count uint64
+}
+
+func newChunkWriter(w io.Writer, key []byte) (*chunkWriter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encryptionMagic); err != nil {
+		return nil, err
+	}
+	return &chunkWriter{w: w, aead: aead, buf: make([]byte, 0, encryptionChunk)}, nil
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, so Close
+		// always has a chunk left to mark as the last one
+		if len(c.buf) == encryptionChunk {
+			if err := c.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(c.buf[len(c.buf):encryptionChunk], p)
+		c.buf = c.buf[:len(c.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (c *chunkWriter) Close() error {
+	return c.seal(true)
+}
+
+func (c *chunkWriter) seal(final bool) error {
+	var header [5]byte
+	if final {
+		header[0] = 1
+	}
+	sealed := c.aead.Seal(nil, chunkNonce(c.aead, c.count), c.buf, chunkAAD(header[0]))
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+
+	if _, err := c.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(sealed); err != nil {
+		return err
+	}
+	c.count++
+	c.buf = c.buf[:0]
+	return nil
+}
+
+type chunkReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	sealed []byte
+	buf    []byte
+	count  uint64
+	final  bool
+}
+
+func newChunkReader(r io.Reader, key []byte) (*chunkReader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != encryptionMagic {
+		return nil, fmt.Errorf("not an encrypted checkpoint archive")
+	}
+	return &chunkReader{r: r, aead: aead, sealed: make([]byte, encryptionChunk+aead.Overhead())}, nil
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.final {
+			return 0, io.EOF
+		}
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkReader) open() error {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("encrypted archive is truncated")
+		}
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if header[0] > 1 || int(size) > len(c.sealed) {
+		return fmt.Errorf("encrypted archive is corrupted")
+	}
+	sealed := c.sealed[:size]
+	if _, err := io.ReadFull(c.r, sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("encrypted archive is truncated")
+		}
+		return err
+	}
+
+	plain, err := c.aead.Open(sealed[:0], chunkNonce(c.aead, c.count), sealed, chunkAAD(header[0]))
+	if err != nil {
+		return fmt.Errorf("wrong key or corrupted data")
+	}
+	c.buf = plain
+	c.count++
+
+	if header[0] == 1 {
+		c.final = true
+		var extra [1]byte
+		if n, _ := c.r.Read(extra[:]); n > 0 {
+			return fmt.Errorf("encrypted archive has data after its end")
+		}
+	}
+	return nil
+}
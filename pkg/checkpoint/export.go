@@ -0,0 +1,218 @@
+package checkpoint
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/secrets"
+	"docker-cr/pkg/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ExportCheckpoint writes a checkpoint directory to a gzipped tarball at
+// outputPath, with the checkpoint under a top-level directory of the same
+// name. With redact, secret environment values are masked in the metadata
+// files; process memory in the images is exported as is. The manifest of a
+// redacted export is updated to match, and its signature, which no longer
+// applies, is left out. Encrypted
+// checkpoints are exported still encrypted, checkpoints in the chunk store
+// with their page images.
+func (m *Manager) ExportCheckpoint(checkpointDir, outputPath string, redact bool) error {
+	if !utils.FileExists(filepath.Join(checkpointDir, "checkpoint_metadata.json")) &&
+		!utils.DirExists(filepath.Join(checkpointDir, "images")) && !IsEncrypted(checkpointDir) {
+		return fmt.Errorf("not a checkpoint directory: %s", checkpointDir)
+	}
+
+	// The archive must stand on its own, without the chunk store
+	if IsStored(checkpointDir) {
+		dir, cleanup, err := m.MaterializeCheckpoint(checkpointDir)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		checkpointDir = dir
+	}
+
+	// The archive holds process memory, so it is private like the images
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", outputPath, err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	root := filepath.Base(filepath.Clean(checkpointDir))
+	var rewrite func(rel, path string) ([]byte, error)
+	if redact {
+		redacted, err := redactCheckpointFiles(checkpointDir)
+		if err != nil {
+			return err
+		}
+		rewrite = func(rel, path string) ([]byte, error) {
+			if rel == SignatureFile {
+				return nil, errSkipFile
+			}
+			return redacted[rel], nil
+		}
+	}
+	if err := writeTree(tw, checkpointDir, root, rewrite); err != nil {
+		return fmt.Errorf("failed to export checkpoint: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to export checkpoint: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to export checkpoint: %w", err)
+	}
+
+	m.logger.Infof("Exported %s to %s", checkpointDir, outputPath)
+	return nil
+}
+
+// errSkipFile makes writeTree leave a file out.
+var errSkipFile = errors.New("skip file")
+
+// writeTree writes dir to tw with entry names below prefix, or relative to
+// dir for an empty prefix. rewrite may return replacement contents for a
+// regular file, nil to keep the file as is, or errSkipFile to leave it out.
+func writeTree(tw *tar.Writer, dir, prefix string, rewrite func(rel, path string) ([]byte, error)) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if prefix == "" && rel == "." {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			// e.g. the parent link of an incremental checkpoint
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if !info.Mode().IsRegular() {
+			return tw.WriteHeader(header)
+		}
+
+		if rewrite != nil {
+			data, err := rewrite(rel, path)
+			if err == errSkipFile {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				header.Size = int64(len(data))
+				if err := tw.WriteHeader(header); err != nil {
+					return err
+				}
+				_, err = tw.Write(data)
+				return err
+			}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+}
+
+// redactCheckpointFiles returns the redacted contents of the metadata files
+// of a checkpoint and, if it has one, of a manifest matching them.
+func redactCheckpointFiles(checkpointDir string) (map[string][]byte, error) {
+	redacted := make(map[string][]byte)
+	for _, name := range []string{"checkpoint_metadata.json", "container_metadata.json"} {
+		path := filepath.Join(checkpointDir, name)
+		if !utils.FileExists(path) {
+			continue
+		}
+		data, err := redactMetadataFile(path, name)
+		if err != nil {
+			return nil, err
+		}
+		redacted[name] = data
+	}
+
+	if !utils.FileExists(filepath.Join(checkpointDir, ManifestFile)) {
+		return redacted, nil
+	}
+	manifest, err := loadManifest(checkpointDir)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range manifest.Files {
+		if data, ok := redacted[entry.Path]; ok {
+			sum := sha256.Sum256(data)
+			manifest.Files[i].Size = int64(len(data))
+			manifest.Files[i].SHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	redacted[ManifestFile] = data
+
+	return redacted, nil
+}
+
+// redactMetadataFile returns a metadata file with secret environment values
+// masked.
+func redactMetadataFile(path, name string) ([]byte, error) {
+	data, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var redacted interface{}
+	switch name {
+	case "checkpoint_metadata.json":
+		var metadata CheckpointMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		secrets.RedactContainerState(metadata.ContainerState)
+		redacted = metadata
+	default:
+		var state docker.ContainerState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		secrets.RedactContainerState(&state)
+		redacted = state
+	}
+
+	return json.MarshalIndent(redacted, "", "  ")
+}
@@ -0,0 +1,260 @@
+package checkpoint
+
+import (
+	"context"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/utils"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Labels set by docker compose on every service container
+const (
+	LabelComposeProject   = "com.docker.compose.project"
+	LabelComposeService   = "com.docker.compose.service"
+	LabelComposeDependsOn = "com.docker.compose.depends_on"
+)
+
+const groupsDirName = "groups"
+
+// GroupManifest links the per-container checkpoints of one group checkpoint.
+type GroupManifest struct {
+	Group          string        `json:"group"`
+	CheckpointName string        `json:"checkpoint_name"`
+	CreatedAt      string        `json:"created_at"`
+	Members        []GroupMember `json:"members"`
+}
+
+type GroupMember struct {
+	Container     string   `json:"container"`
+	Service       string   `json:"service,omitempty"`
+	DependsOn     []string `json:"depends_on,omitempty"`
+	CheckpointDir string   `json:"checkpoint_dir"`
+}
+
+// GroupManifestPath returns where the manifest of a group checkpoint lives.
+func GroupManifestPath(outputDir, group, checkpointName string) string {
+	return filepath.Join(outputDir, groupsDirName, group, checkpointName+".json")
+}
+
+// ResolveGroupContainers returns the running and paused containers of a
+// compose project.
+func (m *Manager) ResolveGroupContainers(ctx context.Context, project string) ([]string, error) {
+	containers, err := m.dockerManager.ListContainers(ctx, LabelComposeProject+"="+project, "running", "paused")
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no running containers in compose project %s", project)
+	}
+
+	var names []string
+	for _, name := range containers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CheckpointGroup checkpoints several containers as of the same instant: all
+// of them are paused first, dumped one by one through their frozen cgroups,
+// and only then resumed. If any dump fails while the members are left
+// running, the checkpoints already taken are discarded since the group would
+// be inconsistent. Otherwise those members are already gone, so their
+// checkpoints are kept and named in the error.
+func (m *Manager) CheckpointGroup(ctx context.Context, group string, containers []string, config CheckpointConfig) (*GroupManifest, error) {
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("group %s has no containers", group)
+	}
+
+	states := make([]*docker.ContainerState, 0, len(containers))
+	for _, name := range containers {
+		state, err := m.dockerManager.GetContainerState(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get state of %s: %w", name, err)
+		}
+		states = append(states, state)
+	}
+
+	// Resume on a fresh context so a cancelled checkpoint still thaws.
+	// Members dumped without --leave-running have exited by then.
+	paused := make([]*docker.ContainerState, 0, len(states))
+	defer func() {
+		resumed := 0
+		for _, state := range paused {
+			status, err := m.dockerManager.GetContainerStatus(context.Background(), state.ID)
+			if err == nil && !status.Paused {
+				continue
+			}
+			if err := m.dockerManager.UnpauseContainer(context.Background(), state.ID); err != nil {
+				m.logger.Errorf("Failed to resume %s: %v", state.Name, err)
+				continue
+			}
+			resumed++
+		}
+		if resumed > 0 {
+			m.logger.Infof("Resumed %d group member(s)", resumed)
+		}
+	}()
+
+	for _, state := range states {
+		status, err := m.dockerManager.GetContainerStatus(ctx, state.ID)
+		if err != nil {
+			return nil, err
+		}
+		if status.Paused {
+			m.logger.Infof("%s is already paused", state.Name)
+			continue
+		}
+
+		if err := m.dockerManager.PauseContainer(ctx, state.ID); err != nil {
+			return nil, fmt.Errorf("failed to freeze %s: %w", state.Name, err)
+		}
+		paused = append(paused, state)
+	}
+	m.logger.Infof("Froze %d container(s) of group %s", len(states), group)
+
+	manifest := &GroupManifest{
+		Group:          group,
+		CheckpointName: config.CheckpointName,
+		CreatedAt:      utils.GetCurrentTimestamp(),
+	}
+
+	memberConfig := config
+	memberConfig.FreezeCgroup = true
+	// Pre-dumps would let members run again between dumps
+	memberConfig.PreDump = false
+
+	for _, state := range states {
+		if err := m.Checkpoint(ctx, state.ID, memberConfig); err != nil {
+			return nil, m.abortGroup(manifest.Members, config.LeaveRunning, fmt.Errorf("checkpoint of group member %s failed: %w", state.Name, err))
+		}
+
+		manifest.Members = append(manifest.Members, GroupMember{
+			Container:     state.Name,
+			Service:       state.Labels[LabelComposeService],
+			DependsOn:     parseDependsOn(state.Labels[LabelComposeDependsOn]),
+			CheckpointDir: filepath.Join(config.OutputDir, state.Name, config.CheckpointName),
+		})
+	}
+
+	if err := m.saveGroupManifest(manifest, GroupManifestPath(config.OutputDir, group, config.CheckpointName)); err != nil {
+		return nil, m.abortGroup(manifest.Members, config.LeaveRunning, err)
+	}
+
+	return manifest, nil
+}
+
+// abortGroup cleans up after a failed group checkpoint. Checkpoints of
+// members left running are discarded; those of members the dump stopped
+// are all that is left of them, so they are kept and listed in the error.
+func (m *Manager) abortGroup(dumped []GroupMember, leaveRunning bool, err error) error {
+	if leaveRunning || len(dumped) == 0 {
+		m.discardGroupMembers(dumped)
+		return err
+	}
+
+	kept := make([]string, len(dumped))
+	for i, member := range dumped {
+		kept[i] = fmt.Sprintf("%s (%s)", member.Container, member.CheckpointDir)
+		m.logger.Warnf("Keeping checkpoint of stopped group member %s: %s", member.Container, member.CheckpointDir)
+	}
+	return fmt.Errorf("%w; already dumped and stopped: %s", err, strings.Join(kept, ", "))
+}
+
+func (m *Manager) discardGroupMembers(members []GroupMember) {
+	for _, member := range members {
+		if err := m.DeleteCheckpoint(member.CheckpointDir); err != nil {
+			m.logger.Warnf("Failed to discard checkpoint of %s: %v", member.Container, err)
+		}
+	}
+}
+
+func (m *Manager) saveGroupManifest(manifest *GroupManifest, filePath string) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal group manifest: %w", err)
+	}
+
+	return utils.WriteFileAtomic(filePath, data)
+}
+
+func LoadGroupManifest(filePath string) (*GroupManifest, error) {
+	data, err := utils.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest GroupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse group manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// parseDependsOn extracts the service names from a compose depends_on label,
+// "service:condition:restart" entries separated by commas.
+func parseDependsOn(label string) []string {
+	var services []string
+	for _, entry := range strings.Split(label, ",") {
+		service, _, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if service != "" {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// RestoreOrder sorts the members so that every service comes after the
+// services it depends on. Members are otherwise kept in manifest order.
+func (g *GroupManifest) RestoreOrder() ([]GroupMember, error) {
+	byService := make(map[string]int)
+	for i, member := range g.Members {
+		if member.Service != "" {
+			byService[member.Service] = i
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(g.Members))
+	var order []GroupMember
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle involving %s", g.Members[i].Container)
+		}
+
+		state[i] = visiting
+		for _, dependency := range g.Members[i].DependsOn {
+			// Dependencies outside the group are assumed to be running
+			if j, ok := byService[dependency]; ok {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		state[i] = done
+		order = append(order, g.Members[i])
+		return nil
+	}
+
+	for i := range g.Members {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
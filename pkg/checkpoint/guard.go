@@ -0,0 +1,127 @@
+package checkpoint
+
+import (
+	"context"
+	"docker-cr/pkg/docker"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// guardTimeout bounds the checks after a failed dump. They run on their own
+// context so they still happen when the checkpoint was cancelled.
+const guardTimeout = 30 * time.Second
+
+// sourceGuard remembers how the source container looked before the dump, so
+// a failed checkpoint can be undone without resuming anything that was
+// already paused or stopped on purpose.
+type sourceGuard struct {
+	containerID string
+	pid         int
+	paused      bool
+	frozen      bool
+	stopped     bool
+}
+
+func (m *Manager) guardSource(ctx context.Context, state *docker.ContainerState) *sourceGuard {
+	guard := &sourceGuard{
+		containerID: state.ID,
+		pid:         state.ProcessPID,
+		stopped:     processStopped(state.ProcessPID),
+	}
+
+	if status, err := m.dockerManager.GetContainerStatus(ctx, state.ID); err == nil {
+		guard.paused = status.Paused
+	}
+	guard.frozen, _ = docker.IsCgroupFrozen(state.ProcessPID)
+
+	return guard
+}
+
+// ensureRunning verifies after a failed dump that the container still runs
+// the same init process, and resumes it if CRIU left it paused, frozen or
+// stopped.
+func (m *Manager) ensureRunning(guard *sourceGuard) error {
+	ctx, cancel := context.WithTimeout(context.Background(), guardTimeout)
+	defer cancel()
+
+	status, err := m.dockerManager.GetContainerStatus(ctx, guard.containerID)
+	if err != nil {
+		return err
+	}
+
+	if !status.Running || status.Pid != guard.pid || !processAlive(guard.pid) {
+		return fmt.Errorf("container %s is no longer running (status: %s)", guard.containerID[:12], status.Status)
+	}
+
+	if status.Paused && !guard.paused {
+		m.logger.Warn("Unpausing container left paused by failed checkpoint")
+		if err := m.dockerManager.UnpauseContainer(ctx, guard.containerID); err != nil {
+			return err
+		}
+	}
+
+	if frozen, err := docker.IsCgroupFrozen(guard.pid); err == nil && frozen && !guard.frozen {
+		m.logger.Warn("Thawing container left frozen by failed checkpoint")
+		if err := docker.ThawCgroup(guard.pid); err != nil {
+			return err
+		}
+	}
+
+	if processStopped(guard.pid) && !guard.stopped {
+		m.logger.Warn("Resuming container process left stopped by failed checkpoint")
+		if err := syscall.Kill(guard.pid, syscall.SIGCONT); err != nil {
+			return fmt.Errorf("failed to resume PID %d: %w", guard.pid, err)
+		}
+	}
+
+	m.logger.Infof("Source container %s is still running", guard.containerID[:12])
+	return nil
+}
+
+// discardPartialCheckpoint removes everything a failed checkpoint wrote except
+// the CRIU log, so the directory is never mistaken for a usable checkpoint.
+func (m *Manager) discardPartialCheckpoint(checkpointDir string) {
+	for _, name := range []string{"checkpoint_metadata.json", "container_metadata.json", "mount_mappings.json", "images"} {
+		path := filepath.Join(checkpointDir, name)
+		if err := os.RemoveAll(path); err != nil {
+			m.logger.Warnf("Failed to remove partial checkpoint data %s: %v", path, err)
+		}
+	}
+
+	m.logger.Infof("Removed partial checkpoint data from %s", checkpointDir)
+}
+
+// HasCheckpointData reports whether checkpointDir holds anything besides the
+// CRIU logs a discarded attempt leaves behind.
+func HasCheckpointData(checkpointDir string) bool {
+	entries, err := os.ReadDir(checkpointDir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			return true
+		}
+	}
+	return false
+}
+
+func processAlive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// processStopped reports whether pid is in the stopped (T) state.
+func processStopped(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+
+	// The command name may contain spaces; the state follows the last ")"
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "T"
+}
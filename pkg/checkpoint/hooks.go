@@ -0,0 +1,160 @@
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	criu "github.com/checkpoint-restore/go-criu/v7"
+)
+
+// Hook events, named as CRIU passes them to action scripts in
+// CRTOOLS_SCRIPT_ACTION
+const (
+	HookPreDump             = "pre-dump"
+	HookPostDump            = "post-dump"
+	HookNetworkLock         = "network-lock"
+	HookNetworkUnlock       = "network-unlock"
+	HookPreRestore          = "pre-restore"
+	HookSetupNamespaces     = "setup-namespaces"
+	HookPostSetupNamespaces = "post-setup-namespaces"
+	HookPostRestore         = "post-restore"
+	HookPostResume          = "post-resume"
+)
+
+// HookFunc is called at a CRIU event. pid is the restored init task for
+// setup-namespaces and post-restore, and 0 otherwise. An error aborts the
+// dump or restore.
+type HookFunc func(ctx context.Context, event string, pid int) error
+
+// Hooks run user code at CRIU's notification points, e.g. to flush caches
+// before a dump or to deregister from a load balancer while the network is
+// locked.
+type Hooks struct {
+	// Scripts are run for every event, like criu --action-script: the event
+	// is in CRTOOLS_SCRIPT_ACTION and the task in CRTOOLS_INIT_PID
+	Scripts []string `json:"scripts,omitempty"`
+	// Funcs are callbacks per event for library use
+	Funcs map[string][]HookFunc `json:"-"`
+	// Env is added to the environment of the scripts
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// On registers fn for event.
+func (h *Hooks) On(event string, fn HookFunc) {
+	if h.Funcs == nil {
+		h.Funcs = make(map[string][]HookFunc)
+	}
+	h.Funcs[event] = append(h.Funcs[event], fn)
+}
+
+func (h *Hooks) empty() bool {
+	return h == nil || (len(h.Scripts) == 0 && len(h.Funcs) == 0)
+}
+
+// WithEnv returns a copy of h whose scripts also see env.
+func (h *Hooks) WithEnv(env map[string]string) *Hooks {
+	if h.empty() {
+		return nil
+	}
+
+	hooks := *h
+	hooks.Env = make(map[string]string)
+	for k, v := range h.Env {
+		hooks.Env[k] = v
+	}
+	for k, v := range env {
+		hooks.Env[k] = v
+	}
+	return &hooks
+}
+
+// environ is the environment scripts run with, without the per-event
+// variables CRIU itself sets for action scripts.
+func (h *Hooks) environ() []string {
+	env := os.Environ()
+	for k, v := range h.Env {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// Run runs the scripts and then the callbacks registered for event, stopping
+// at the first failure.
+func (h *Hooks) Run(ctx context.Context, event string, pid int) error {
+	if h == nil {
+		return nil
+	}
+
+	env := append(h.environ(), "CRTOOLS_SCRIPT_ACTION="+event)
+	if pid > 0 {
+		env = append(env, "CRTOOLS_INIT_PID="+strconv.Itoa(pid))
+	}
+
+	for _, script := range h.Scripts {
+		cmd := exec.CommandContext(ctx, script)
+		cmd.Env = env
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s hook %s failed: %w: %s", event, script, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	for _, fn := range h.Funcs[event] {
+		if err := fn(ctx, event, pid); err != nil {
+			return fmt.Errorf("%s hook failed: %w", event, err)
+		}
+	}
+
+	return nil
+}
+
+// notifier returns the go-criu callbacks for hooks, or nil so CRIU is not
+// asked to send notifications at all.
+func (cm *CRIUManager) notifier(ctx context.Context, hooks *Hooks) criu.Notify {
+	if hooks.empty() {
+		return nil
+	}
+	return &hookNotifier{ctx: ctx, hooks: hooks, cm: cm}
+}
+
+// hookNotifier adapts Hooks to go-criu's notification callbacks.
+type hookNotifier struct {
+	ctx   context.Context
+	hooks *Hooks
+	cm    *CRIUManager
+}
+
+func (n *hookNotifier) run(event string, pid int32) error {
+	n.cm.logger.Debugf("CRIU event %s", event)
+	return n.hooks.Run(n.ctx, event, int(pid))
+}
+
+func (n *hookNotifier) PreDump() error                  { return n.run(HookPreDump, 0) }
+func (n *hookNotifier) PostDump() error                 { return n.run(HookPostDump, 0) }
+func (n *hookNotifier) NetworkLock() error              { return n.run(HookNetworkLock, 0) }
+func (n *hookNotifier) NetworkUnlock() error            { return n.run(HookNetworkUnlock, 0) }
+func (n *hookNotifier) PreRestore() error               { return n.run(HookPreRestore, 0) }
+func (n *hookNotifier) SetupNamespaces(pid int32) error { return n.run(HookSetupNamespaces, pid) }
+func (n *hookNotifier) PostSetupNamespaces() error      { return n.run(HookPostSetupNamespaces, 0) }
+func (n *hookNotifier) PostRestore(pid int32) error     { return n.run(HookPostRestore, pid) }
+func (n *hookNotifier) PostResume() error               { return n.run(HookPostResume, 0) }
+
+// actionScriptArgs passes the hook scripts to command-line CRIU, which runs
+// them itself. Callbacks cannot be reached from there.
+func (cm *CRIUManager) actionScriptArgs(hooks *Hooks) []string {
+	if hooks.empty() {
+		return nil
+	}
+	if len(hooks.Funcs) > 0 {
+		cm.logger.Warn("Hook callbacks are not run by command-line CRIU, only hook scripts")
+	}
+
+	var args []string
+	for _, script := range hooks.Scripts {
+		args = append(args, "--action-script", script)
+	}
+	return args
+}
@@ -0,0 +1,269 @@
+package checkpoint
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"docker-cr/pkg/utils"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestFile lists every file of a checkpoint with its size and SHA-256.
+// SignatureFile holds an optional ed25519 signature of the manifest.
+const (
+	ManifestFile  = "manifest.json"
+	SignatureFile = "manifest.sig"
+)
+
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Version   int             `json:"version"`
+	CreatedAt string          `json:"created_at"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+// WriteManifest records every regular file of a checkpoint in its manifest.
+// Symlinks, such as the parent of an incremental dump, are left out; the
+// parent has a manifest of its own.
+func (m *Manager) WriteManifest(checkpointDir string) (*Manifest, error) {
+	manifest := &Manifest{Version: 1, CreatedAt: utils.GetCurrentTimestamp()}
+
+	err := filepath.Walk(checkpointDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(checkpointDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == ManifestFile || rel == SignatureFile {
+			return nil
+		}
+
+		sum, size, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestEntry{Path: filepath.ToSlash(rel), Size: size, SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash checkpoint files: %w", err)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(checkpointDir, ManifestFile), data); err != nil {
+		return nil, err
+	}
+
+	m.logger.Debugf("Wrote manifest of %d files for %s", len(manifest.Files), checkpointDir)
+	return manifest, nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+func loadManifest(checkpointDir string) (*Manifest, error) {
+	data, err := utils.ReadFile(filepath.Join(checkpointDir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// VerifyManifest checks the size and SHA-256 of every file in the manifest
+// of a checkpoint. Files a restore adds later, such as its log, are ignored,
+// except in images/ where CRIU could pick them up.
+func (m *Manager) VerifyManifest(checkpointDir string) error {
+	manifest, err := loadManifest(checkpointDir)
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		name := filepath.Clean(filepath.FromSlash(entry.Path))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in manifest: %s", entry.Path)
+		}
+		listed[filepath.ToSlash(name)] = true
+
+		sum, size, err := hashFile(filepath.Join(checkpointDir, name))
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		if size != entry.Size {
+			return fmt.Errorf("%s is %d bytes, expected %d", entry.Path, size, entry.Size)
+		}
+		if sum != entry.SHA256 {
+			return fmt.Errorf("%s does not match its checksum", entry.Path)
+		}
+	}
+
+	images, err := os.ReadDir(filepath.Join(checkpointDir, "images"))
+	if err != nil {
+		return fmt.Errorf("failed to list images directory: %w", err)
+	}
+	for _, image := range images {
+		// CRIU writes its restore statistics next to the images
+		if !image.Type().IsRegular() || image.Name() == "stats-restore" {
+			continue
+		}
+		if !listed["images/"+image.Name()] {
+			return fmt.Errorf("images/%s is not in the manifest", image.Name())
+		}
+	}
+
+	m.logger.Debugf("Verified %d files of %s", len(manifest.Files), checkpointDir)
+	return nil
+}
+
+// SignManifest signs the manifest of a checkpoint with the ed25519 private
+// key in keyPath, a PKCS#8 PEM file as written by
+// openssl genpkey -algorithm ed25519.
+func (m *Manager) SignManifest(checkpointDir, keyPath string) error {
+	key, err := loadSigningKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := utils.ReadFile(filepath.Join(checkpointDir, ManifestFile))
+	if err != nil {
+		return err
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	if err := utils.WriteFileAtomic(filepath.Join(checkpointDir, SignatureFile), []byte(signature+"\n")); err != nil {
+		return err
+	}
+
+	m.logger.Infof("Signed manifest of %s", checkpointDir)
+	return nil
+}
+
+// VerifySignature checks that the manifests of a checkpoint and of its
+// parents are signed by one of the trusted ed25519 public keys (PKIX PEM
+// files).
+func (m *Manager) VerifySignature(checkpointDir string, trustedKeys []string) error {
+	keys := make([]ed25519.PublicKey, len(trustedKeys))
+	for i, path := range trustedKeys {
+		key, err := loadTrustedKey(path)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+
+	for dir, seen := checkpointDir, map[string]bool{}; dir != "" && !seen[dir]; dir = resolveParentCheckpoint(dir) {
+		seen[dir] = true
+		signer, err := verifySignature(dir, keys)
+		if err != nil {
+			return fmt.Errorf("signature check of %s failed: %w", dir, err)
+		}
+		m.logger.Infof("Manifest of %s is signed with %s", dir, trustedKeys[signer])
+	}
+	return nil
+}
+
+// verifySignature returns the index of the key that signed the manifest.
+func verifySignature(checkpointDir string, keys []ed25519.PublicKey) (int, error) {
+	data, err := utils.ReadFile(filepath.Join(checkpointDir, ManifestFile))
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint has no manifest to verify: %w", err)
+	}
+	text, err := utils.ReadFile(filepath.Join(checkpointDir, SignatureFile))
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint is not signed: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(text)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode manifest signature: %w", err)
+	}
+
+	for i, key := range keys {
+		if ed25519.Verify(key, data, signature) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("manifest is not signed by a trusted key")
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := utils.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s is not a PEM %s", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return ed, nil
+}
+
+func loadTrustedKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	return ed, nil
+}
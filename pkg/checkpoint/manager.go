@@ -1,6 +1,7 @@
 package checkpoint
 
 import (
+	"context"
 	"docker-cr/pkg/docker"
 	"docker-cr/pkg/utils"
 	"encoding/json"
@@ -17,23 +18,37 @@ type Manager struct {
 }
 
 type CheckpointConfig struct {
-	OutputDir       string `json:"output_dir"`
-	CheckpointName  string `json:"checkpoint_name"`
-	LeaveRunning    bool   `json:"leave_running"`
-	TcpEstablished  bool   `json:"tcp_established"`
-	FileLocks       bool   `json:"file_locks"`
-	PreDump         bool   `json:"pre_dump"`
-	LogLevel        int    `json:"log_level"`
-	ManageCgroups   bool   `json:"manage_cgroups"`
-	Shell           bool   `json:"shell"`
+	OutputDir        string `json:"output_dir"`
+	CheckpointName   string `json:"checkpoint_name"`
+	LeaveRunning     bool   `json:"leave_running"`
+	TcpEstablished   bool   `json:"tcp_established"`
+	FileLocks        bool   `json:"file_locks"`
+	PreDump          bool   `json:"pre_dump"`
+	LogLevel         int    `json:"log_level"`
+	ManageCgroups    bool   `json:"manage_cgroups"`
+	Shell            bool   `json:"shell"`
+	ParentCheckpoint string `json:"parent_checkpoint"` // Incremental dump on top of this checkpoint dir
+	TrackMem         bool   `json:"track_mem"`         // Needed for a checkpoint to become a parent
+	FreezeCgroup     bool   `json:"freeze_cgroup"`     // Freeze via the container's freezer cgroup instead of ptrace
+	// Hook scripts and key files are host paths run or read as root, so
+	// they are never taken from JSON such as API requests
+	Hooks      *Hooks         `json:"-"`
+	Encryption *EncryptionKey `json:"-"`               // Encrypt images and metadata once dumped
+	SigningKey string         `json:"-"`               // ed25519 private key to sign the manifest with
+	Store      bool           `json:"store,omitempty"` // Keep page images in the deduplicating chunk store of OutputDir
 }
 
 type CheckpointMetadata struct {
-	ContainerState *docker.ContainerState `json:"container_state"`
-	MountMappings  []docker.MountMapping  `json:"mount_mappings"`
-	CheckpointPath string                 `json:"checkpoint_path"`
-	CreatedAt      string                 `json:"created_at"`
-	Version        string                 `json:"version"`
+	ContainerState   *docker.ContainerState `json:"container_state"`
+	MountMappings    []docker.MountMapping  `json:"mount_mappings"`
+	CheckpointPath   string                 `json:"checkpoint_path"`
+	CreatedAt        string                 `json:"created_at"`
+	Version          string                 `json:"version"`
+	ParentCheckpoint string                 `json:"parent_checkpoint,omitempty"`
+	Networks         []docker.NetworkSpec   `json:"networks,omitempty"`
+
+	// Namespaces left out of the dump, to be rejoined on restore
+	ExternalNamespaces []docker.SharedNamespace `json:"external_namespaces,omitempty"`
 }
 
 func NewManager(dockerManager *docker.Manager, logger *logrus.Logger) *Manager {
@@ -44,25 +59,59 @@ func NewManager(dockerManager *docker.Manager, logger *logrus.Logger) *Manager {
 	}
 }
 
-func (m *Manager) Checkpoint(containerName string, config CheckpointConfig) error {
+// Checkpoint dumps a running container. On failure the source container is
+// verified to still be running (and resumed if the dump left it frozen), and
+// partial checkpoint data is removed.
+func (m *Manager) Checkpoint(ctx context.Context, containerName string, config CheckpointConfig) (err error) {
 	m.logger.Infof("Starting checkpoint of container: %s", containerName)
 
 	// 1. Get container state from Docker
-	state, err := m.dockerManager.GetContainerState(containerName)
+	state, err := m.dockerManager.GetContainerState(ctx, containerName)
 	if err != nil {
 		return fmt.Errorf("failed to get container state: %w", err)
 	}
 
 	m.logger.Infof("Container info - ID: %s, PID: %d", state.ID[:12], state.ProcessPID)
 
+	// A bad key must not be found only after the container was dumped
+	if config.Encryption != nil {
+		if err := config.Encryption.Validate(); err != nil {
+			return fmt.Errorf("invalid encryption key: %w", err)
+		}
+	}
+	if config.SigningKey != "" {
+		if _, err := loadSigningKey(config.SigningKey); err != nil {
+			return fmt.Errorf("invalid signing key: %w", err)
+		}
+	}
+	// Encrypted images are unique to each checkpoint and never deduplicate
+	if config.Store && config.Encryption != nil {
+		return fmt.Errorf("the chunk store cannot be used for encrypted checkpoints")
+	}
+
 	// 2. Prepare checkpoint directory
 	checkpointDir := filepath.Join(config.OutputDir, state.Name, config.CheckpointName)
 	imagesDir := filepath.Join(checkpointDir, "images")
 
+	// A failure below discards what was written, which must never be an
+	// earlier checkpoint of the same name
+	if HasCheckpointData(checkpointDir) {
+		return fmt.Errorf("checkpoint %s already exists, choose another name or remove it first", checkpointDir)
+	}
+
 	if err := utils.EnsureDir(imagesDir); err != nil {
 		return fmt.Errorf("failed to create checkpoint directory: %w", err)
 	}
 
+	// Once CRIU has killed the container the images are all that is left,
+	// so they are only discarded while the source is still running
+	dumped := false
+	defer func() {
+		if err != nil && (!dumped || config.LeaveRunning) {
+			m.discardPartialCheckpoint(checkpointDir)
+		}
+	}()
+
 	m.logger.Infof("Checkpoint directory: %s", checkpointDir)
 
 	// 3. Get mount mappings
@@ -78,46 +127,109 @@ func (m *Manager) Checkpoint(containerName string, config CheckpointConfig) erro
 
 	externalMounts := m.criuManager.BuildExternalMountMappings(mountMappings)
 
-	// 5. Save mount mappings for restore
+	// Namespaces joined from another container are not ours to dump
+	sharedNamespaces, err := externalNamespaces(state)
+	if err != nil {
+		return err
+	}
+	for _, ns := range sharedNamespaces {
+		m.logger.Infof("The %s namespace is shared with %s, dumping it as external", ns.Type, ns.Owner)
+	}
+	externalMounts = append(externalMounts, ExternalNamespaceArgs(sharedNamespaces)...)
+
+	// Incremental dumps reference the parent images relative to our images dir
+	parentImg := ""
+	if config.ParentCheckpoint != "" {
+		if IsEncrypted(config.ParentCheckpoint) {
+			return fmt.Errorf("parent checkpoint %s is encrypted", config.ParentCheckpoint)
+		}
+		parentImagesDir := filepath.Join(config.ParentCheckpoint, "images")
+		if !utils.DirExists(parentImagesDir) {
+			return fmt.Errorf("parent checkpoint images not found: %s", parentImagesDir)
+		}
+
+		absParent, err := filepath.Abs(parentImagesDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve parent checkpoint: %w", err)
+		}
+		absImages, err := filepath.Abs(imagesDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve images directory: %w", err)
+		}
+		parentImg, err = filepath.Rel(absImages, absParent)
+		if err != nil {
+			return fmt.Errorf("failed to compute parent images path: %w", err)
+		}
+
+		m.logger.Infof("Incremental checkpoint on top of: %s", config.ParentCheckpoint)
+	}
+
+	// 5. Configure CRIU checkpoint options
+	criuOpts := CheckpointOptions{
+		WorkDir:        checkpointDir,
+		ImagesDir:      imagesDir,
+		LogFile:        filepath.Join(checkpointDir, "dump.log"), // Use dump.log like working version
+		LogLevel:       config.LogLevel,
+		External:       externalMounts,
+		ManageCgroups:  config.ManageCgroups,
+		TcpEstablished: config.TcpEstablished,
+		FileLocks:      config.FileLocks,
+		LeaveRunning:   config.LeaveRunning,
+		Shell:          config.Shell,
+		PreDump:        config.PreDump,
+		TrackMem:       config.PreDump || config.TrackMem, // Enable memory tracking for pre-dump and incremental dumps
+		ParentImg:      parentImg,
+		Hooks: config.Hooks.WithEnv(map[string]string{
+			"DOCKER_CR_CONTAINER":      state.Name,
+			"DOCKER_CR_CHECKPOINT_DIR": checkpointDir,
+		}),
+	}
+
+	if config.FreezeCgroup {
+		criuOpts.FreezeCgroup, err = m.freezeContainer(state)
+		if err != nil {
+			return err
+		}
+	}
+
+	// User-defined networks are recorded so restore can recreate them
+	networks, err := m.dockerManager.GetNetworkSpecs(ctx, state)
+	if err != nil {
+		return fmt.Errorf("failed to capture container networks: %w", err)
+	}
+
+	// 6. Perform CRIU checkpoint
+	guard := m.guardSource(ctx, state)
+	if err := m.criuManager.CheckpointProcess(ctx, state.ProcessPID, criuOpts); err != nil {
+		if guardErr := m.ensureRunning(guard); guardErr != nil {
+			return fmt.Errorf("checkpoint of %s failed: %w (source container not recovered: %v)", state.Name, err, guardErr)
+		}
+		return fmt.Errorf("checkpoint of %s failed: %w", state.Name, err)
+	}
+	dumped = true
+
+	// 7. Save mount mappings for restore
 	mountMappingsFile := filepath.Join(checkpointDir, "mount_mappings.json")
 	if err := m.SaveMountMappings(mountMappings, mountMappingsFile); err != nil {
 		return fmt.Errorf("failed to save mount mappings: %w", err)
 	}
 
-	// 6. Save container metadata
+	// 8. Save container metadata
 	metadataFile := filepath.Join(checkpointDir, "container_metadata.json")
 	if err := m.dockerManager.SaveContainerMetadata(state, metadataFile); err != nil {
 		return fmt.Errorf("failed to save container metadata: %w", err)
 	}
 
-	// 7. Configure CRIU checkpoint options
-	criuOpts := CheckpointOptions{
-		WorkDir:         checkpointDir,
-		ImagesDir:       imagesDir,
-		LogFile:         filepath.Join(checkpointDir, "dump.log"),  // Use dump.log like working version
-		LogLevel:        config.LogLevel,
-		External:        externalMounts,
-		ManageCgroups:   config.ManageCgroups,
-		TcpEstablished:  config.TcpEstablished,
-		FileLocks:       config.FileLocks,
-		LeaveRunning:    config.LeaveRunning,
-		Shell:           config.Shell,
-		PreDump:         config.PreDump,
-		TrackMem:        config.PreDump, // Enable memory tracking for pre-dump
-	}
-
-	// 8. Perform CRIU checkpoint
-	if err := m.criuManager.CheckpointProcess(state.ProcessPID, criuOpts); err != nil {
-		return fmt.Errorf("CRIU checkpoint failed: %w", err)
-	}
-
-	// 9. Save checkpoint metadata
+	// 9. Save checkpoint metadata last; its presence marks a complete checkpoint
 	metadata := CheckpointMetadata{
-		ContainerState: state,
-		MountMappings:  mountMappings,
-		CheckpointPath: checkpointDir,
-		CreatedAt:      utils.GetCurrentTimestamp(),
-		Version:        "1.0",
+		ContainerState:     state,
+		MountMappings:      mountMappings,
+		CheckpointPath:     checkpointDir,
+		CreatedAt:          utils.GetCurrentTimestamp(),
+		Version:            "1.0",
+		ParentCheckpoint:   config.ParentCheckpoint,
+		Networks:           networks,
+		ExternalNamespaces: sharedNamespaces,
 	}
 
 	metadataPath := filepath.Join(checkpointDir, "checkpoint_metadata.json")
@@ -125,10 +237,52 @@ func (m *Manager) Checkpoint(containerName string, config CheckpointConfig) erro
 		return fmt.Errorf("failed to save checkpoint metadata: %w", err)
 	}
 
+	// 10. Record checksums of everything CRIU and we wrote
+	if _, err := m.WriteManifest(checkpointDir); err != nil {
+		return err
+	}
+	if config.SigningKey != "" {
+		if err := m.SignManifest(checkpointDir, config.SigningKey); err != nil {
+			return fmt.Errorf("failed to sign manifest: %w", err)
+		}
+	}
+
+	// 11. Move the page images into the shared chunk store
+	if config.Store {
+		if err := m.StoreCheckpoint(checkpointDir, StoreDir(config.OutputDir)); err != nil {
+			return err
+		}
+	}
+
+	// 12. Encrypt it all, manifest included
+	if config.Encryption != nil {
+		if err := m.EncryptCheckpoint(checkpointDir, *config.Encryption); err != nil {
+			return err
+		}
+	}
+
 	m.logger.Infof("Checkpoint completed successfully: %s", checkpointDir)
 	return nil
 }
 
+// freezeContainer resolves the freezer cgroup CRIU should freeze the
+// container through. An explicit request to freeze through the cgroup fails
+// when it cannot be resolved rather than silently falling back to ptrace;
+// docker pause is no substitute, as CRIU cannot seize tasks frozen behind
+// its back.
+func (m *Manager) freezeContainer(state *docker.ContainerState) (string, error) {
+	freezer, err := docker.FreezerCgroupPath(state.ProcessPID)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the freezer cgroup of %s: %w", state.Name, err)
+	}
+	if !utils.DirExists(freezer) {
+		return "", fmt.Errorf("freezer cgroup %s of %s does not exist", freezer, state.Name)
+	}
+
+	m.logger.Infof("Freezing through cgroup %s", freezer)
+	return freezer, nil
+}
+
 func (m *Manager) ListCheckpointFiles(checkpointDir string) ([]string, error) {
 	imagesDir := filepath.Join(checkpointDir, "images")
 	if !utils.DirExists(imagesDir) {
@@ -154,6 +308,13 @@ func (m *Manager) ValidateCheckpoint(checkpointDir string) error {
 		return fmt.Errorf("checkpoint directory does not exist: %s", checkpointDir)
 	}
 
+	if IsEncrypted(checkpointDir) {
+		return fmt.Errorf("checkpoint is encrypted, a key file or passphrase file is required: %s", checkpointDir)
+	}
+	if IsStored(checkpointDir) {
+		return fmt.Errorf("checkpoint keeps its images in the chunk store and must be materialized: %s", checkpointDir)
+	}
+
 	// Check for required files
 	requiredFiles := []string{
 		"container_metadata.json",
@@ -186,11 +347,74 @@ func (m *Manager) ValidateCheckpoint(checkpointDir string) error {
 		return fmt.Errorf("checkpoint images directory is empty")
 	}
 
+	// The images of an incremental checkpoint include its parents'
+	for dir, seen := checkpointDir, map[string]bool{}; dir != "" && !seen[dir]; dir = resolveParentCheckpoint(dir) {
+		seen[dir] = true
+		if !utils.FileExists(filepath.Join(dir, ManifestFile)) {
+			m.logger.Warnf("Checkpoint %s has no manifest, skipping its integrity check", dir)
+			continue
+		}
+		if err := m.VerifyManifest(dir); err != nil {
+			return fmt.Errorf("integrity check of %s failed: %w", dir, err)
+		}
+	}
+
 	m.logger.Infof("Checkpoint validation successful: %d image files found", len(files))
 	return nil
 }
 
+// OpenCheckpoint returns a directory the checkpoint can be restored or
+// inspected from: checkpointDir itself, or a private temporary copy of an
+// encrypted checkpoint or one in the chunk store. cleanup removes the copy.
+func (m *Manager) OpenCheckpoint(checkpointDir string, key *EncryptionKey) (string, func(), error) {
+	switch {
+	case IsEncrypted(checkpointDir):
+		if key == nil {
+			return "", nil, fmt.Errorf("checkpoint %s is encrypted, a key file or passphrase file is required", checkpointDir)
+		}
+		return m.DecryptCheckpoint(checkpointDir, *key)
+	case IsStored(checkpointDir):
+		return m.MaterializeCheckpoint(checkpointDir)
+	}
+	return checkpointDir, func() {}, nil
+}
+
+// DeleteCheckpoint removes a checkpoint directory after making sure it really
+// is one, so a mistyped path cannot wipe unrelated data.
+func (m *Manager) DeleteCheckpoint(checkpointDir string) error {
+	if !utils.DirExists(checkpointDir) {
+		return fmt.Errorf("checkpoint directory does not exist: %s", checkpointDir)
+	}
+
+	if !utils.FileExists(filepath.Join(checkpointDir, "checkpoint_metadata.json")) &&
+		!utils.DirExists(filepath.Join(checkpointDir, "images")) && !IsEncrypted(checkpointDir) {
+		return fmt.Errorf("not a checkpoint directory: %s", checkpointDir)
+	}
+
+	if err := utils.RemoveDir(checkpointDir); err != nil {
+		return fmt.Errorf("failed to delete checkpoint: %w", err)
+	}
+
+	m.logger.Infof("Deleted checkpoint: %s", checkpointDir)
+	return nil
+}
+
+// GetCheckpointInfo loads the metadata of a checkpoint. For an encrypted
+// checkpoint only the container name, creation time and parent are known.
 func (m *Manager) GetCheckpointInfo(checkpointDir string) (*CheckpointMetadata, error) {
+	if IsEncrypted(checkpointDir) {
+		info, err := readEncryptionInfo(checkpointDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint metadata: %w", err)
+		}
+		return &CheckpointMetadata{
+			ContainerState:   &docker.ContainerState{Name: info.Container},
+			CheckpointPath:   checkpointDir,
+			CreatedAt:        info.CreatedAt,
+			ParentCheckpoint: info.ParentCheckpoint,
+		}, nil
+	}
+
 	metadataPath := filepath.Join(checkpointDir, "checkpoint_metadata.json")
 	if !utils.FileExists(metadataPath) {
 		return nil, fmt.Errorf("checkpoint metadata file not found: %s", metadataPath)
@@ -215,7 +439,7 @@ func (m *Manager) SaveMountMappings(mappings []docker.MountMapping, filePath str
 		return fmt.Errorf("failed to marshal mount mappings: %w", err)
 	}
 
-	return utils.WriteFile(filePath, data)
+	return utils.WriteFileAtomic(filePath, data)
 }
 
 func (m *Manager) LoadMountMappings(filePath string) ([]docker.MountMapping, error) {
@@ -242,9 +466,9 @@ func (m *Manager) saveCheckpointMetadata(metadata CheckpointMetadata, filePath s
 		return fmt.Errorf("failed to marshal checkpoint metadata: %w", err)
 	}
 
-	return utils.WriteFile(filePath, data)
+	return utils.WriteFileAtomic(filePath, data)
 }
 
 func (m *Manager) CheckCRIUSupport() error {
 	return m.criuManager.CheckCRIUSupport()
-}
\ No newline at end of file
+}
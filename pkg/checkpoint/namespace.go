@@ -0,0 +1,57 @@
+package checkpoint
+
+import (
+	"docker-cr/pkg/docker"
+	"fmt"
+	"os"
+)
+
+// externalNamespaces picks the shared namespaces of a container that CRIU
+// must treat as external. Namespaces shared with CRIU itself (usually the
+// host's) need nothing special; of the others CRIU can only leave net and pid
+// namespaces out of the dump.
+func externalNamespaces(state *docker.ContainerState) ([]docker.SharedNamespace, error) {
+	var external []docker.SharedNamespace
+	for _, ns := range state.SharedNamespaces {
+		if selfInode, err := docker.NamespaceInode(os.Getpid(), ns.Type); err == nil && selfInode == ns.Inode {
+			continue
+		}
+
+		switch ns.Type {
+		case "net", "pid":
+			external = append(external, ns)
+		default:
+			return nil, fmt.Errorf("the %s namespace is shared with %s, which CRIU cannot checkpoint", ns.Type, ns.Owner)
+		}
+	}
+
+	return external, nil
+}
+
+// ExternalNamespaceArgs formats namespaces for CRIU's --external option.
+func ExternalNamespaceArgs(namespaces []docker.SharedNamespace) []string {
+	var args []string
+	for _, ns := range namespaces {
+		args = append(args, fmt.Sprintf("%s[%d]:%s", ns.Type, ns.Inode, ns.Key))
+	}
+	return args
+}
+
+// InheritFdArgs returns the --inherit-fd options handing CRIU the namespaces,
+// opened in order as descriptors from firstFd on.
+func InheritFdArgs(namespaces []InheritNamespace, firstFd int) []string {
+	var args []string
+	for i, ns := range namespaces {
+		args = append(args, "--inherit-fd", fmt.Sprintf("fd[%d]:%s", firstFd+i, ns.Key))
+	}
+	return args
+}
+
+// JoinNsArgs returns the --join-ns options restoring into the namespaces.
+func JoinNsArgs(namespaces []JoinNamespace) []string {
+	var args []string
+	for _, ns := range namespaces {
+		args = append(args, "--join-ns", ns.Type+":"+ns.Path)
+	}
+	return args
+}
@@ -0,0 +1,337 @@
+package checkpoint
+
+import (
+	"docker-cr/pkg/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Container labels that override the retention policy for a single container.
+const (
+	LabelRetentionKeepLast     = "docker-cr.retention.keep-last"
+	LabelRetentionMaxAge       = "docker-cr.retention.max-age"
+	LabelRetentionMaxTotalSize = "docker-cr.retention.max-total-size"
+)
+
+// CheckpointEntry describes a completed checkpoint found under an output directory.
+type CheckpointEntry struct {
+	Container string            `json:"container"`
+	Name      string            `json:"name"`
+	Path      string            `json:"path"`
+	CreatedAt time.Time         `json:"created_at"`
+	Size      int64             `json:"size"`
+	Parent    string            `json:"parent,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type RetentionPolicy struct {
+	KeepLast     int           `json:"keep_last"`
+	MaxAge       time.Duration `json:"max_age"`
+	MaxTotalSize int64         `json:"max_total_size"`
+}
+
+type PruneConfig struct {
+	OutputDir  string          `json:"output_dir"`
+	Container  string          `json:"container"`
+	NamePrefix string          `json:"name_prefix"`
+	Policy     RetentionPolicy `json:"policy"`
+	UseLabels  bool            `json:"use_labels"`
+	DryRun     bool            `json:"dry_run"`
+}
+
+type PruneDecision struct {
+	Checkpoint CheckpointEntry `json:"checkpoint"`
+	Delete     bool            `json:"delete"`
+	Reason     string          `json:"reason"`
+}
+
+type PruneResult struct {
+	Decisions  []PruneDecision `json:"decisions"`
+	Deleted    int             `json:"deleted"`
+	FreedBytes int64           `json:"freed_bytes"`
+	DryRun     bool            `json:"dry_run"`
+}
+
+// ListCheckpoints walks the <output>/<container>/<name> layout created by
+// Checkpoint and returns every checkpoint that has its metadata written,
+// newest first.
+func (m *Manager) ListCheckpoints(outputDir string) ([]CheckpointEntry, error) {
+	if !utils.DirExists(outputDir) {
+		return nil, fmt.Errorf("checkpoint directory does not exist: %s", outputDir)
+	}
+
+	// Absolute paths keep entries comparable with resolved parent links
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve checkpoint directory: %w", err)
+	}
+
+	containerDirs, err := os.ReadDir(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint directory: %w", err)
+	}
+
+	var entries []CheckpointEntry
+	for _, containerDir := range containerDirs {
+		// e.g. the chunk store
+		if !containerDir.IsDir() || strings.HasPrefix(containerDir.Name(), ".") {
+			continue
+		}
+
+		checkpointDirs, err := os.ReadDir(filepath.Join(outputDir, containerDir.Name()))
+		if err != nil {
+			m.logger.Warnf("Skipping %s: %v", containerDir.Name(), err)
+			continue
+		}
+
+		for _, checkpointDir := range checkpointDirs {
+			// e.g. working copies of encrypted or stored checkpoints
+			if !checkpointDir.IsDir() || strings.HasPrefix(checkpointDir.Name(), ".") {
+				continue
+			}
+
+			path := filepath.Join(outputDir, containerDir.Name(), checkpointDir.Name())
+			entry, err := m.loadCheckpointEntry(containerDir.Name(), path)
+			if err != nil {
+				m.logger.Debugf("Skipping %s: %v", path, err)
+				continue
+			}
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+
+	return entries, nil
+}
+
+// LatestCheckpoint returns the newest checkpoint of a container below outputDir.
+func (m *Manager) LatestCheckpoint(outputDir, container string) (*CheckpointEntry, error) {
+	entries, err := m.ListCheckpoints(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.Container == container {
+			return &entry, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no checkpoints found for container %s in %s", container, outputDir)
+}
+
+func (m *Manager) loadCheckpointEntry(container, checkpointDir string) (*CheckpointEntry, error) {
+	metadata, err := m.GetCheckpointInfo(checkpointDir)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := utils.ParseTimestamp(metadata.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid creation time %q: %w", metadata.CreatedAt, err)
+	}
+
+	size, err := utils.DirSize(checkpointDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CheckpointEntry{
+		Container: container,
+		Name:      filepath.Base(checkpointDir),
+		Path:      checkpointDir,
+		CreatedAt: createdAt,
+		Size:      size,
+		Parent:    resolveParentCheckpoint(checkpointDir),
+	}
+
+	if metadata.ContainerState != nil {
+		entry.Labels = metadata.ContainerState.Labels
+	}
+
+	return entry, nil
+}
+
+// resolveParentCheckpoint follows the "parent" symlink CRIU leaves in the
+// images directory of an incremental dump and returns the checkpoint
+// directory it points into, or "" for a full dump.
+func resolveParentCheckpoint(checkpointDir string) string {
+	if IsEncrypted(checkpointDir) {
+		info, err := readEncryptionInfo(checkpointDir)
+		if err != nil {
+			return ""
+		}
+		return info.ParentCheckpoint
+	}
+
+	imagesDir := filepath.Join(checkpointDir, "images")
+	link, err := os.Readlink(filepath.Join(imagesDir, "parent"))
+	if err != nil {
+		return ""
+	}
+
+	if !filepath.IsAbs(link) {
+		link = filepath.Join(imagesDir, link)
+	}
+
+	return filepath.Dir(filepath.Clean(link))
+}
+
+// Prune applies the retention policy to every container below the output
+// directory. Checkpoints that a kept incremental checkpoint depends on are
+// never deleted.
+func (m *Manager) Prune(config PruneConfig) (*PruneResult, error) {
+	entries, err := m.ListCheckpoints(config.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	byContainer := make(map[string][]CheckpointEntry)
+	var containers []string
+	for _, entry := range entries {
+		if config.Container != "" && entry.Container != config.Container {
+			continue
+		}
+		if !strings.HasPrefix(entry.Name, config.NamePrefix) {
+			continue
+		}
+		if _, ok := byContainer[entry.Container]; !ok {
+			containers = append(containers, entry.Container)
+		}
+		byContainer[entry.Container] = append(byContainer[entry.Container], entry)
+	}
+	sort.Strings(containers)
+
+	result := &PruneResult{DryRun: config.DryRun}
+	now := time.Now()
+
+	for _, container := range containers {
+		checkpoints := byContainer[container]
+
+		policy := config.Policy
+		if config.UseLabels {
+			// Entries are newest first, so the latest labels win
+			policy = m.policyFromLabels(policy, checkpoints[0].Labels)
+		}
+
+		result.Decisions = append(result.Decisions, planPrune(checkpoints, policy, now)...)
+	}
+
+	stores := make(map[string]bool)
+	for _, decision := range result.Decisions {
+		if !decision.Delete {
+			continue
+		}
+
+		if config.DryRun {
+			m.logger.Infof("Would delete checkpoint %s (%s)", decision.Checkpoint.Path, decision.Reason)
+		} else {
+			m.logger.Infof("Pruning checkpoint %s (%s)", decision.Checkpoint.Path, decision.Reason)
+			if store := StoreOf(decision.Checkpoint.Path); store != "" {
+				stores[store] = true
+			}
+			if err := m.DeleteCheckpoint(decision.Checkpoint.Path); err != nil {
+				return result, err
+			}
+		}
+
+		result.Deleted++
+		result.FreedBytes += decision.Checkpoint.Size
+	}
+
+	// Chunks only the pruned checkpoints used can go now
+	for store := range stores {
+		freed, err := m.CollectGarbage(store)
+		if err != nil {
+			return result, err
+		}
+		result.FreedBytes += freed
+	}
+
+	return result, nil
+}
+
+// planPrune decides the fate of one container's checkpoints (newest first).
+func planPrune(checkpoints []CheckpointEntry, policy RetentionPolicy, now time.Time) []PruneDecision {
+	decisions := make([]PruneDecision, len(checkpoints))
+
+	var keptSize int64
+	for i, entry := range checkpoints {
+		decisions[i] = PruneDecision{Checkpoint: entry, Reason: "within retention policy"}
+
+		switch {
+		case policy.KeepLast > 0 && i >= policy.KeepLast:
+			decisions[i].Delete = true
+			decisions[i].Reason = fmt.Sprintf("exceeds keep-last %d", policy.KeepLast)
+		case policy.MaxAge > 0 && now.Sub(entry.CreatedAt) > policy.MaxAge:
+			decisions[i].Delete = true
+			decisions[i].Reason = fmt.Sprintf("older than %s", policy.MaxAge)
+		case policy.MaxTotalSize > 0 && keptSize+entry.Size > policy.MaxTotalSize:
+			decisions[i].Delete = true
+			decisions[i].Reason = fmt.Sprintf("exceeds max-total-size %s", utils.FormatSize(policy.MaxTotalSize))
+		default:
+			keptSize += entry.Size
+		}
+	}
+
+	// Keep parents of surviving incremental checkpoints. Rescuing a parent can
+	// in turn require its own parent, so repeat until nothing changes.
+	index := make(map[string]int, len(decisions))
+	for i, decision := range decisions {
+		index[decision.Checkpoint.Path] = i
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, decision := range decisions {
+			if decision.Delete || decision.Checkpoint.Parent == "" {
+				continue
+			}
+			parent, ok := index[decision.Checkpoint.Parent]
+			if !ok || !decisions[parent].Delete {
+				continue
+			}
+			decisions[parent].Delete = false
+			decisions[parent].Reason = fmt.Sprintf("parent of incremental checkpoint %s", decision.Checkpoint.Name)
+			changed = true
+		}
+	}
+
+	return decisions
+}
+
+func (m *Manager) policyFromLabels(policy RetentionPolicy, labels map[string]string) RetentionPolicy {
+	if value, ok := labels[LabelRetentionKeepLast]; ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			policy.KeepLast = n
+		} else {
+			m.logger.Warnf("Ignoring invalid %s label: %q", LabelRetentionKeepLast, value)
+		}
+	}
+
+	if value, ok := labels[LabelRetentionMaxAge]; ok {
+		if d, err := utils.ParseDuration(value); err == nil {
+			policy.MaxAge = d
+		} else {
+			m.logger.Warnf("Ignoring invalid %s label: %q", LabelRetentionMaxAge, value)
+		}
+	}
+
+	if value, ok := labels[LabelRetentionMaxTotalSize]; ok {
+		if size, err := utils.ParseSize(value); err == nil {
+			policy.MaxTotalSize = size
+		} else {
+			m.logger.Warnf("Ignoring invalid %s label: %q", LabelRetentionMaxTotalSize, value)
+		}
+	}
+
+	return policy
+}
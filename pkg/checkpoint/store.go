@@ -0,0 +1,509 @@
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"docker-cr/pkg/utils"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// A checkpoint in the chunk store keeps its page images as chunks under the
+// store shared by all checkpoints of an output directory, and lists them in
+// StoreIndexFile.
+const (
+	StoreDirName   = ".store"
+	StoreIndexFile = "store.json"
+)
+
+// Page images are split at content-defined boundaries, so data shifted by a
+// new mapping still yields the same chunks. Boundaries are where the top
+// bits of a gear hash are zero, giving chunks of about 64 KiB.
+const (
+	minChunkSize = 16 << 10
+	maxChunkSize = 256 << 10
+	chunkMask    = uint64(1<<16-1) << 48
+)
+
+const maxParentDepth = 64
+
+var gearTable = func() (table [256]uint64) {
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:])
+	}
+	return table
+}()
+
+type StoreChunk struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type StoreFile struct {
+	Path   string       `json:"path"`
+	Size   int64        `json:"size"`
+	Chunks []StoreChunk `json:"chunks"`
+}
+
+type StoreIndex struct {
+	Version int         `json:"version"`
+	Store   string      `json:"store"`
+	Files   []StoreFile `json:"files"`
+}
+
+// StoreDir returns the chunk store of an output directory.
+func StoreDir(outputDir string) string {
+	return filepath.Join(outputDir, StoreDirName)
+}
+
+// IsStored reports whether checkpointDir keeps its page images in a chunk store.
+func IsStored(checkpointDir string) bool {
+	return utils.FileExists(filepath.Join(checkpointDir, StoreIndexFile))
+}
+
+// StoreOf returns the chunk store a checkpoint refers to, or "".
+func StoreOf(checkpointDir string) string {
+	index, err := readStoreIndex(checkpointDir)
+	if err != nil {
+		return ""
+	}
+	return index.Store
+}
+
+func readStoreIndex(checkpointDir string) (*StoreIndex, error) {
+	data, err := utils.ReadFile(filepath.Join(checkpointDir, StoreIndexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var index StoreIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", StoreIndexFile, err)
+	}
+	return &index, nil
+}
+
+func blobPath(storeDir, id string) string {
+	return filepath.Join(storeDir, "blobs", id[:2], id)
+}
+
+// lockStore serializes garbage collection against checkpoints adding
+// chunks: writers share the lock, collection holds it exclusively.
+func lockStore(storeDir string, how int) (func(), error) {
+	if err := utils.EnsureDir(storeDir); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(storeDir, "lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock store: %w", err)
+	}
+	return func() { file.Close() }, nil
+}
+
+// chunkBoundary returns the length of the next chunk at the start of data.
+func chunkBoundary(data []byte) int {
+	if len(data) <= minChunkSize {
+		return len(data)
+	}
+	n := min(len(data), maxChunkSize)
+	var hash uint64
+	for i := minChunkSize; i < n; i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// StoreCheckpoint moves the page images of a checkpoint into the chunk
+// store at storeDir and leaves an index of their chunks in their place.
+func (m *Manager) StoreCheckpoint(checkpointDir, storeDir string) error {
+	storeDir, err := filepath.Abs(storeDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve chunk store: %w", err)
+	}
+
+	unlock, err := lockStore(storeDir, syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	pages, err := filepath.Glob(filepath.Join(checkpointDir, "images", "pages-*.img"))
+	if err != nil {
+		return err
+	}
+
+	index := StoreIndex{Version: 1, Store: storeDir}
+	var total, added int64
+	written := make(map[string]bool)
+	for _, path := range pages {
+		file, newBytes, err := storeFile(storeDir, path, written)
+		if err != nil {
+			return fmt.Errorf("failed to store %s: %w", filepath.Base(path), err)
+		}
+		file.Path = "images/" + filepath.Base(path)
+		index.Files = append(index.Files, file)
+		total += file.Size
+		added += newBytes
+	}
+
+	// The page images are the only other copy, so the chunks and the index
+	// must be on disk before they go
+	for dir := range written {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal store index: %w", err)
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(checkpointDir, StoreIndexFile), data); err != nil {
+		return err
+	}
+	if err := syncDir(checkpointDir); err != nil {
+		return err
+	}
+
+	for _, path := range pages {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stored image: %w", err)
+		}
+	}
+
+	m.logger.Infof("Stored %d page images (%s) in %s, %s of it new",
+		len(pages), utils.FormatSize(total), storeDir, utils.FormatSize(added))
+	return nil
+}
+
+// storeFile splits a file into chunks and adds the missing ones to the
+// store. It returns the file's chunks and how many bytes were new, and adds
+// the directories of new chunks to written.
+func storeFile(storeDir, path string, written map[string]bool) (StoreFile, int64, error) {
+	var stored StoreFile
+	var added int64
+
+	file, err := os.Open(path)
+	if err != nil {
+		return stored, 0, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, maxChunkSize)
+	filled := 0
+	eof := false
+	for {
+		if !eof {
+			n, err := io.ReadFull(file, buf[filled:])
+			filled += n
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return stored, 0, err
+			}
+		}
+		if filled == 0 {
+			break
+		}
+
+		n := chunkBoundary(buf[:filled])
+		sum := sha256.Sum256(buf[:n])
+		id := hex.EncodeToString(sum[:])
+		isNew, err := writeBlob(storeDir, id, buf[:n])
+		if err != nil {
+			return stored, 0, err
+		}
+		if isNew {
+			added += int64(n)
+			written[filepath.Dir(blobPath(storeDir, id))] = true
+		}
+
+		stored.Chunks = append(stored.Chunks, StoreChunk{SHA256: id, Size: int64(n)})
+		stored.Size += int64(n)
+		filled = copy(buf, buf[n:filled])
+	}
+
+	return stored, added, nil
+}
+
+// writeBlob adds a chunk to the store unless it is there already. A blob of
+// the wrong size, left by a crash, is replaced.
+func writeBlob(storeDir, id string, data []byte) (bool, error) {
+	path := blobPath(storeDir, id)
+	if info, err := os.Stat(path); err == nil && info.Size() == int64(len(data)) {
+		return false, nil
+	}
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+id+".tmp-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), path)
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dir, err)
+	}
+	return nil
+}
+
+// MaterializeCheckpoint rebuilds a checkpoint kept in the chunk store as a
+// plain checkpoint directory CRIU can restore from, in a new directory only
+// the current user can read. cleanup removes it again.
+func (m *Manager) MaterializeCheckpoint(checkpointDir string) (string, func(), error) {
+	absDir, err := filepath.Abs(checkpointDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve checkpoint directory: %w", err)
+	}
+
+	tmpDir, err := workingCopyDir(absDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			m.logger.Warnf("Failed to remove materialized checkpoint %s: %v", tmpDir, err)
+		}
+	}
+
+	dir := filepath.Join(tmpDir, filepath.Base(absDir))
+	if err := materialize(absDir, dir, tmpDir, 0); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to materialize checkpoint: %w", err)
+	}
+
+	m.logger.Debugf("Materialized %s into %s", checkpointDir, dir)
+	return dir, cleanup, nil
+}
+
+// workingCopyDir creates a hidden directory next to a checkpoint for a
+// plain copy of it. A copy holds a whole memory image, so it belongs with
+// the checkpoints rather than in a temporary directory that may be in RAM.
+func workingCopyDir(checkpointDir string) (string, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(checkpointDir), "."+filepath.Base(checkpointDir)+".open-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create working copy directory: %w", err)
+	}
+	return dir, nil
+}
+
+// materialize copies src to dst, reassembling stored page images. Parents
+// of an incremental checkpoint that are in the store are materialized next
+// to it.
+func materialize(src, dst, tmpDir string, depth int) error {
+	if depth > maxParentDepth {
+		return fmt.Errorf("more than %d parent checkpoints", maxParentDepth)
+	}
+	index, err := readStoreIndex(src)
+	if err != nil {
+		return err
+	}
+
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(path), link)
+			}
+			if rel == filepath.Join("images", "parent") && IsStored(filepath.Dir(link)) {
+				parent := filepath.Dir(link)
+				parentDst := filepath.Join(tmpDir, fmt.Sprintf("parent-%d", depth+1), filepath.Base(parent))
+				if err := materialize(parent, parentDst, tmpDir, depth+1); err != nil {
+					return fmt.Errorf("parent %s: %w", parent, err)
+				}
+				link = filepath.Join(parentDst, "images")
+			}
+			return os.Symlink(link, target)
+		case rel == StoreIndexFile || !info.Mode().IsRegular():
+			return nil
+		}
+
+		data, err := utils.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0600)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, file := range index.Files {
+		name := filepath.Clean(filepath.FromSlash(file.Path))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in store index: %s", file.Path)
+		}
+		if err := assembleFile(index.Store, file, filepath.Join(dst, name)); err != nil {
+			return fmt.Errorf("%s: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// assembleFile writes a stored file from its chunks, checking each chunk
+// against its hash.
+func assembleFile(storeDir string, file StoreFile, target string) error {
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var size int64
+	for _, chunk := range file.Chunks {
+		if len(chunk.SHA256) != sha256.Size*2 {
+			return fmt.Errorf("invalid chunk id %q", chunk.SHA256)
+		}
+		data, err := os.ReadFile(blobPath(storeDir, chunk.SHA256))
+		if err != nil {
+			return fmt.Errorf("missing chunk %s: %w", chunk.SHA256, err)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != chunk.SHA256 {
+			return fmt.Errorf("chunk %s is corrupted", chunk.SHA256)
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		size += int64(len(data))
+	}
+	if size != file.Size {
+		return fmt.Errorf("reassembled %d bytes, expected %d", size, file.Size)
+	}
+
+	return out.Close()
+}
+
+// CollectGarbage deletes the chunks of a store that no checkpoint below its
+// output directory refers to, and returns how many bytes were freed.
+func (m *Manager) CollectGarbage(storeDir string) (int64, error) {
+	storeDir, err := filepath.Abs(storeDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve chunk store: %w", err)
+	}
+	if !utils.DirExists(storeDir) {
+		return 0, nil
+	}
+
+	unlock, err := lockStore(storeDir, syscall.LOCK_EX)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// Checkpoints may be anywhere below the output directory
+	var indexes []string
+	err = filepath.Walk(filepath.Dir(storeDir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == storeDir {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && info.Name() == StoreIndexFile {
+			indexes = append(indexes, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to find checkpoints of %s, not collecting garbage: %w", storeDir, err)
+	}
+
+	// An unreadable index could hide references, so nothing is deleted then
+	referenced := make(map[string]bool)
+	for _, path := range indexes {
+		index, err := readStoreIndex(filepath.Dir(path))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s, not collecting garbage: %w", path, err)
+		}
+		if index.Store != storeDir {
+			continue
+		}
+		for _, file := range index.Files {
+			for _, chunk := range file.Chunks {
+				referenced[chunk.SHA256] = true
+			}
+		}
+	}
+
+	var freed int64
+	removed := 0
+	err = filepath.Walk(filepath.Join(storeDir, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Leftover temporary files of interrupted writers go as well
+		if !info.Mode().IsRegular() || referenced[info.Name()] {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		freed += info.Size()
+		removed++
+		return nil
+	})
+	if err != nil {
+		return freed, fmt.Errorf("failed to collect garbage: %w", err)
+	}
+
+	if removed > 0 {
+		m.logger.Infof("Removed %d unused chunks (%s) from %s", removed, utils.FormatSize(freed), storeDir)
+	}
+	return freed, nil
+}
@@ -0,0 +1,258 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+// ProcessCgroups parses /proc/<pid>/cgroup into a controller -> path map.
+// The cgroup v2 unified hierarchy is reported under the empty controller name.
+func ProcessCgroups(pid int) (map[string]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroups of PID %d: %w", pid, err)
+	}
+
+	cgroups := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// Format: hierarchy-ID:controller-list:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		if parts[1] == "" {
+			cgroups[""] = parts[2]
+			continue
+		}
+
+		for _, controller := range strings.Split(parts[1], ",") {
+			cgroups[strings.TrimPrefix(controller, "name=")] = parts[2]
+		}
+	}
+
+	return cgroups, nil
+}
+
+// freezerFile returns the file controlling the freezer for the cgroup of pid,
+// and whether it is the cgroup v2 cgroup.freeze interface.
+func freezerFile(pid int) (string, bool, error) {
+	cgroups, err := ProcessCgroups(pid)
+	if err != nil {
+		return "", false, err
+	}
+
+	if path, ok := cgroups["freezer"]; ok {
+		return filepath.Join(cgroupMountPoint, "freezer", path, "freezer.state"), false, nil
+	}
+
+	if path, ok := cgroups[""]; ok {
+		return filepath.Join(cgroupMountPoint, path, "cgroup.freeze"), true, nil
+	}
+
+	return "", false, fmt.Errorf("no freezer cgroup found for PID %d", pid)
+}
+
+// FreezerCgroupPath returns the freezer cgroup directory of pid, as CRIU's
+// --freeze-cgroup expects it.
+func FreezerCgroupPath(pid int) (string, error) {
+	file, _, err := freezerFile(pid)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(file), nil
+}
+
+// IsCgroupFrozen reports whether the freezer cgroup of pid is frozen.
+func IsCgroupFrozen(pid int) (bool, error) {
+	file, v2, err := freezerFile(pid)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read freezer state: %w", err)
+	}
+
+	state := strings.TrimSpace(string(data))
+	if v2 {
+		return state == "1", nil
+	}
+	return state != "THAWED", nil
+}
+
+// ThawCgroup thaws the freezer cgroup of pid, e.g. after an interrupted dump.
+func ThawCgroup(pid int) error {
+	file, v2, err := freezerFile(pid)
+	if err != nil {
+		return err
+	}
+
+	value := "THAWED"
+	if v2 {
+		value = "0"
+	}
+
+	if err := os.WriteFile(file, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to thaw cgroup: %w", err)
+	}
+
+	return nil
+}
+
+// Cgroup hierarchy layouts of the host
+const (
+	CgroupModeV1     = "v1"
+	CgroupModeV2     = "v2"
+	CgroupModeHybrid = "hybrid"
+)
+
+const cgroup2SuperMagic = 0x63677270
+
+// CgroupInfo describes where a container's task lives in the cgroup tree and
+// the limits its cgroups enforce.
+type CgroupInfo struct {
+	Mode string `json:"mode"`
+	// Path is the unified (v2) path, or the memory controller's path on v1
+	Path string `json:"path"`
+	// Controllers maps each v1 controller to its path; v2 is under ""
+	Controllers map[string]string `json:"controllers"`
+	// Limits holds the interface files that were read, e.g. "memory.max"
+	Limits map[string]string `json:"limits,omitempty"`
+}
+
+// Limit files read per mode. On v1 they are relative to the controller mount.
+var (
+	cgroupV2LimitFiles = []string{
+		"memory.max", "memory.high", "memory.swap.max",
+		"cpu.max", "cpu.weight", "cpuset.cpus", "cpuset.mems",
+		"pids.max", "io.max",
+	}
+	cgroupV1LimitFiles = []string{
+		"memory/memory.limit_in_bytes", "memory/memory.soft_limit_in_bytes", "memory/memory.memsw.limit_in_bytes",
+		"cpu/cpu.cfs_quota_us", "cpu/cpu.cfs_period_us", "cpu/cpu.shares",
+		"cpuset/cpuset.cpus", "cpuset/cpuset.mems", "pids/pids.max",
+	}
+)
+
+// DetectCgroupMode tells whether the host runs cgroup v1, the v2 unified
+// hierarchy, or v1 controllers alongside a unified mount (hybrid).
+func DetectCgroupMode() (string, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(cgroupMountPoint, &fs); err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", cgroupMountPoint, err)
+	}
+	if fs.Type == cgroup2SuperMagic {
+		return CgroupModeV2, nil
+	}
+
+	if err := syscall.Statfs(filepath.Join(cgroupMountPoint, "unified"), &fs); err == nil && fs.Type == cgroup2SuperMagic {
+		return CgroupModeHybrid, nil
+	}
+	return CgroupModeV1, nil
+}
+
+// GetCgroupInfo reads the cgroups pid belongs to and their current limits.
+func GetCgroupInfo(pid int) (*CgroupInfo, error) {
+	mode, err := DetectCgroupMode()
+	if err != nil {
+		return nil, err
+	}
+
+	controllers, err := ProcessCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &CgroupInfo{
+		Mode:        mode,
+		Controllers: controllers,
+		Limits:      make(map[string]string),
+	}
+
+	limitFiles := cgroupV1LimitFiles
+	if mode == CgroupModeV2 {
+		info.Path = controllers[""]
+		limitFiles = cgroupV2LimitFiles
+	} else {
+		info.Path = controllers["memory"]
+	}
+	if info.Path == "" {
+		return nil, fmt.Errorf("no %s cgroup found for PID %d", mode, pid)
+	}
+
+	for _, file := range limitFiles {
+		var path string
+		if mode == CgroupModeV2 {
+			path = filepath.Join(cgroupMountPoint, info.Path, file)
+		} else {
+			controller, name, _ := strings.Cut(file, "/")
+			path = filepath.Join(cgroupMountPoint, controller, controllers[controller], name)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		info.Limits[file] = strings.TrimSpace(string(data))
+	}
+
+	return info, nil
+}
+
+// RelocateCgroupPath returns the cgroup path a restored copy of a container
+// should use: the path of the original with its container ID replaced, e.g.
+// system.slice/docker-<new>.scope. It fails if the path does not name the
+// original container, as with a custom --cgroup-parent layout.
+func RelocateCgroupPath(path, originalID, newID string) (string, error) {
+	if !strings.Contains(path, originalID) {
+		return "", fmt.Errorf("cgroup path %s does not contain container ID %s", path, originalID)
+	}
+	return strings.ReplaceAll(path, originalID, newID), nil
+}
+
+// ApplyCgroupLimits writes the limits recorded in info to the cgroups of a
+// restored copy of the container, found by relocating the recorded paths
+// from originalID to newID. Files that cannot be written are reported
+// together; the others are still applied.
+func ApplyCgroupLimits(info *CgroupInfo, originalID, newID string) error {
+	limitFiles := cgroupV1LimitFiles
+	if info.Mode == CgroupModeV2 {
+		limitFiles = cgroupV2LimitFiles
+	}
+
+	var failed []string
+	for _, file := range limitFiles {
+		limit, ok := info.Limits[file]
+		if !ok {
+			continue
+		}
+
+		var path string
+		if info.Mode == CgroupModeV2 {
+			path = filepath.Join(cgroupMountPoint, info.Path, file)
+		} else {
+			controller, name, _ := strings.Cut(file, "/")
+			path = filepath.Join(cgroupMountPoint, controller, info.Controllers[controller], name)
+		}
+
+		relocated, err := RelocateCgroupPath(path, originalID, newID)
+		if err == nil {
+			err = os.WriteFile(relocated, []byte(limit), 0644)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", file, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to apply cgroup limits: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
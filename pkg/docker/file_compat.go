@@ -6,7 +6,7 @@ import (
 
 // Compatibility functions for the docker package
 func writeFile(filePath string, data []byte) error {
-	return utils.WriteFile(filePath, data)
+	return utils.WriteFileAtomic(filePath, data)
 }
 
 func readFile(filePath string) ([]byte, error) {
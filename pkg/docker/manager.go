@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
@@ -20,22 +23,25 @@ type Manager struct {
 }
 
 type ContainerState struct {
-	ID            string                          `json:"id"`
-	Name          string                          `json:"name"`
-	Image         string                          `json:"image"`
-	Config        *container.Config               `json:"config"`
-	HostConfig    *container.HostConfig           `json:"host_config"`
-	NetworkConfig map[string]*network.EndpointSettings `json:"network_config"`
-	Mounts        []types.MountPoint              `json:"mounts"`
-	ProcessPID    int                             `json:"process_pid"`
-	Created       time.Time                       `json:"created"`
-	RootFS        string                          `json:"rootfs"`
-	Runtime       string                          `json:"runtime"`
-	BundlePath    string                          `json:"bundle_path"`
-	CgroupPath    string                          `json:"cgroup_path"`
-	Namespaces    map[string]string               `json:"namespaces"`
-	Environment   map[string]string               `json:"environment"`
-	Labels        map[string]string               `json:"labels"`
+	ID               string                               `json:"id"`
+	Name             string                               `json:"name"`
+	Image            string                               `json:"image"`
+	Config           *container.Config                    `json:"config"`
+	HostConfig       *container.HostConfig                `json:"host_config"`
+	NetworkConfig    map[string]*network.EndpointSettings `json:"network_config"`
+	Mounts           []types.MountPoint                   `json:"mounts"`
+	ProcessPID       int                                  `json:"process_pid"`
+	Created          time.Time                            `json:"created"`
+	RootFS           string                               `json:"rootfs"`
+	Runtime          string                               `json:"runtime"`
+	BundlePath       string                               `json:"bundle_path"`
+	CgroupPath       string                               `json:"cgroup_path"`
+	Cgroup           *CgroupInfo                          `json:"cgroup,omitempty"`
+	Namespaces       map[string]string                    `json:"namespaces"`
+	NamespaceInodes  map[string]uint64                    `json:"namespace_inodes,omitempty"`
+	SharedNamespaces []SharedNamespace                    `json:"shared_namespaces,omitempty"`
+	Environment      map[string]string                    `json:"environment"`
+	Labels           map[string]string                    `json:"labels"`
 }
 
 type MountMapping struct {
@@ -45,6 +51,26 @@ type MountMapping struct {
 	Options       string `json:"options"`
 	IsExternal    bool   `json:"is_external"`
 	ReadOnly      bool   `json:"read_only"`
+	SourceKind    string `json:"source_kind,omitempty"`
+}
+
+// Kinds of bind mount source recorded in MountMapping.SourceKind
+const (
+	MountSourceFile = "file"
+	MountSourceDir  = "dir"
+)
+
+// MountSourceKind returns whether path is a file or a directory, or "" if it
+// does not exist.
+func MountSourceKind(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if info.IsDir() {
+		return MountSourceDir
+	}
+	return MountSourceFile
 }
 
 func NewManager(logger *logrus.Logger) (*Manager, error) {
@@ -59,9 +85,7 @@ func NewManager(logger *logrus.Logger) (*Manager, error) {
 	}, nil
 }
 
-func (m *Manager) GetContainerState(nameOrID string) (*ContainerState, error) {
-	ctx := context.Background()
-
+func (m *Manager) GetContainerState(ctx context.Context, nameOrID string) (*ContainerState, error) {
 	containerJSON, err := m.client.ContainerInspect(ctx, nameOrID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to inspect container: %w", err)
@@ -117,9 +141,33 @@ func (m *Manager) GetContainerState(nameOrID string) (*ContainerState, error) {
 	}
 
 	// Get namespace information
+	state.NamespaceInodes = make(map[string]uint64)
 	nsTypes := []string{"ipc", "mnt", "net", "pid", "user", "uts", "cgroup"}
 	for _, ns := range nsTypes {
 		state.Namespaces[ns] = fmt.Sprintf("/proc/%d/ns/%s", state.ProcessPID, ns)
+		if inode, err := NamespaceInode(state.ProcessPID, ns); err == nil {
+			state.NamespaceInodes[ns] = inode
+		}
+	}
+
+	// Only these can be joined from another container
+	sharable := make(map[string]uint64)
+	for _, ns := range []string{"net", "pid", "ipc", "uts"} {
+		if inode, ok := state.NamespaceInodes[ns]; ok {
+			sharable[ns] = inode
+		}
+	}
+	state.SharedNamespaces = m.detectSharedNamespaces(ctx, containerJSON, sharable)
+
+	// CgroupParent is usually empty; the task's own cgroup is authoritative.
+	// Stopped containers have no cgroup.
+	if state.ProcessPID != 0 {
+		if cgroup, err := GetCgroupInfo(state.ProcessPID); err != nil {
+			m.logger.Warnf("Cannot read cgroups of %s: %v", state.Name, err)
+		} else {
+			state.Cgroup = cgroup
+			state.CgroupPath = cgroup.Path
+		}
 	}
 
 	return state, nil
@@ -136,6 +184,7 @@ func (m *Manager) GetMountMappings(state *ContainerState) ([]MountMapping, error
 			Options:       mount.Mode,
 			IsExternal:    true,
 			ReadOnly:      !mount.RW,
+			SourceKind:    MountSourceKind(mount.Source),
 		}
 
 		mappings = append(mappings, mapping)
@@ -157,49 +206,82 @@ func (m *Manager) GetMountMappings(state *ContainerState) ([]MountMapping, error
 	return mappings, nil
 }
 
-func (m *Manager) CreateRestoreContainer(originalState *ContainerState, newName string) (string, error) {
-	ctx := context.Background()
+// holdCommand keeps a restore container running without its application.
+var holdCommand = []string{"sleep", "infinity"}
+
+func (m *Manager) CreateRestoreContainer(ctx context.Context, originalState *ContainerState, opts RestoreContainerOptions) (string, error) {
+	networking, err := restoreNetworking(originalState, opts)
+	if err != nil {
+		return "", err
+	}
 
 	// Create container config based on original but simplified
 	config := &container.Config{
 		Image:        originalState.Image,
+		Hostname:     opts.Hostname,
 		Cmd:          originalState.Config.Cmd,
 		Entrypoint:   originalState.Config.Entrypoint,
 		Env:          originalState.Config.Env,
 		WorkingDir:   originalState.Config.WorkingDir,
 		User:         originalState.Config.User,
-		ExposedPorts: originalState.Config.ExposedPorts,
+		ExposedPorts: networking.exposedPorts,
 		Labels:       originalState.Config.Labels,
 		Tty:          originalState.Config.Tty,
 		OpenStdin:    originalState.Config.OpenStdin,
 		StdinOnce:    originalState.Config.StdinOnce,
 	}
 
+	// The placeholder must not start the application, which would then run
+	// twice and hold the ports the restored processes listen on
+	if opts.Hold {
+		config.Entrypoint = holdCommand
+		config.Cmd = nil
+	}
+
 	// Simplified host config for restore
 	hostConfig := &container.HostConfig{
-		Privileged:  true,
-		PidMode:     "host",
-		IpcMode:     "host",
-		NetworkMode: "host",
-		SecurityOpt: []string{"seccomp=unconfined"},
-		CapAdd:      []string{"SYS_PTRACE", "SYS_ADMIN"},
+		Privileged:   true,
+		PidMode:      "host",
+		IpcMode:      "host",
+		NetworkMode:  networking.mode,
+		PortBindings: networking.portBindings,
+		SecurityOpt:  []string{"seccomp=unconfined"},
+		CapAdd:       []string{"SYS_PTRACE", "SYS_ADMIN"},
 		// Copy important settings from original
-		Resources: originalState.HostConfig.Resources,
+		Resources:     originalState.HostConfig.Resources,
 		RestartPolicy: originalState.HostConfig.RestartPolicy,
 	}
 
-	resp, err := m.client.ContainerCreate(ctx, config, hostConfig, nil, nil, newName)
+	// Only one endpoint can be given at create time; the rest are connected
+	// afterwards
+	var networkingConfig *network.NetworkingConfig
+	if len(networking.networks) > 0 {
+		primary := networking.networks[0]
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{primary: networking.endpoints[primary]},
+		}
+	}
+
+	resp, err := m.client.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, opts.Name)
 	if err != nil {
 		return "", fmt.Errorf("failed to create restore container: %w", err)
 	}
 
-	m.logger.Infof("Created restore container: %s", resp.ID[:12])
+	for i, name := range networking.networks {
+		if i == 0 {
+			continue
+		}
+		if err := m.client.NetworkConnect(ctx, name, resp.ID, networking.endpoints[name]); err != nil {
+			m.client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
+			return "", fmt.Errorf("failed to connect restore container to network %s: %w", name, err)
+		}
+	}
+
+	m.logger.Infof("Created restore container: %s (network: %s)", resp.ID[:12], networking.mode)
 	return resp.ID, nil
 }
 
-func (m *Manager) GetContainerPID(containerID string) (int, error) {
-	ctx := context.Background()
-
+func (m *Manager) GetContainerPID(ctx context.Context, containerID string) (int, error) {
 	containerJSON, err := m.client.ContainerInspect(ctx, containerID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to inspect container: %w", err)
@@ -212,9 +294,7 @@ func (m *Manager) GetContainerPID(containerID string) (int, error) {
 	return containerJSON.State.Pid, nil
 }
 
-func (m *Manager) StartContainer(containerID string) error {
-	ctx := context.Background()
-
+func (m *Manager) StartContainer(ctx context.Context, containerID string) error {
 	if err := m.client.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
 		return fmt.Errorf("failed to start container: %w", err)
 	}
@@ -222,9 +302,7 @@ func (m *Manager) StartContainer(containerID string) error {
 	return nil
 }
 
-func (m *Manager) StopContainer(containerID string, timeout *int) error {
-	ctx := context.Background()
-
+func (m *Manager) StopContainer(ctx context.Context, containerID string, timeout *int) error {
 	stopOptions := container.StopOptions{}
 	if timeout != nil {
 		stopOptions.Timeout = timeout
@@ -237,9 +315,34 @@ func (m *Manager) StopContainer(containerID string, timeout *int) error {
 	return nil
 }
 
-func (m *Manager) RemoveContainer(containerID string) error {
-	ctx := context.Background()
+// GetContainerStatus returns the runtime status of a container, whether or
+// not it is running.
+func (m *Manager) GetContainerStatus(ctx context.Context, nameOrID string) (*types.ContainerState, error) {
+	containerJSON, err := m.client.ContainerInspect(ctx, nameOrID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	return containerJSON.State, nil
+}
 
+func (m *Manager) PauseContainer(ctx context.Context, containerID string) error {
+	if err := m.client.ContainerPause(ctx, containerID); err != nil {
+		return fmt.Errorf("failed to pause container: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) UnpauseContainer(ctx context.Context, containerID string) error {
+	if err := m.client.ContainerUnpause(ctx, containerID); err != nil {
+		return fmt.Errorf("failed to unpause container: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) RemoveContainer(ctx context.Context, containerID string) error {
 	if err := m.client.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{Force: true}); err != nil {
 		return fmt.Errorf("failed to remove container: %w", err)
 	}
@@ -274,9 +377,7 @@ func (m *Manager) LoadContainerMetadata(filePath string) (*ContainerState, error
 	return &state, nil
 }
 
-func (m *Manager) GetContainerLogs(containerID string, tail string) (string, error) {
-	ctx := context.Background()
-
+func (m *Manager) GetContainerLogs(ctx context.Context, containerID string, tail string) (string, error) {
 	options := types.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
@@ -294,6 +395,62 @@ func (m *Manager) GetContainerLogs(containerID string, tail string) (string, err
 	return string(buf[:n]), nil
 }
 
+// ListRunningContainers returns the IDs and names of running containers
+// carrying the given label ("key" or "key=value").
+func (m *Manager) ListRunningContainers(ctx context.Context, label string) (map[string]string, error) {
+	return m.ListContainers(ctx, label, "running")
+}
+
+// ListContainers returns the IDs and names of the containers in any of the
+// given states, optionally filtered by label.
+func (m *Manager) ListContainers(ctx context.Context, label string, statuses ...string) (map[string]string, error) {
+	args := filters.NewArgs()
+	for _, status := range statuses {
+		args.Add("status", status)
+	}
+	if label != "" {
+		args.Add("label", label)
+	}
+
+	containers, err := m.client.ContainerList(ctx, types.ContainerListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	result := make(map[string]string, len(containers))
+	for _, c := range containers {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		result[c.ID] = name
+	}
+
+	return result, nil
+}
+
+// WatchContainerEvents streams container events with the given label until
+// ctx is cancelled or the connection to the daemon is lost.
+func (m *Manager) WatchContainerEvents(ctx context.Context, label string, actions ...string) (<-chan events.Message, <-chan error) {
+	args := filters.NewArgs(filters.Arg("type", string(events.ContainerEventType)))
+	if label != "" {
+		args.Add("label", label)
+	}
+	for _, action := range actions {
+		args.Add("event", action)
+	}
+
+	return m.client.Events(ctx, types.EventsOptions{Filters: args})
+}
+
+// Ping checks that the Docker daemon is reachable.
+func (m *Manager) Ping(ctx context.Context) error {
+	if _, err := m.client.Ping(ctx); err != nil {
+		return fmt.Errorf("docker daemon not reachable: %w", err)
+	}
+	return nil
+}
+
 func (m *Manager) Close() error {
 	return m.client.Close()
-}
\ No newline at end of file
+}
@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+)
+
+// NamespaceOwnerHost marks a namespace shared with the host.
+const NamespaceOwnerHost = "host"
+
+// SharedNamespace is a namespace the container joined rather than owns: it
+// belongs to the host or to another container, so it must not be dumped and
+// recreated but rejoined on restore.
+type SharedNamespace struct {
+	Type  string `json:"type"`
+	Inode uint64 `json:"inode"`
+	// Owner is NamespaceOwnerHost or the name of the owning container
+	Owner string `json:"owner"`
+	// Key names the namespace in CRIU's --external and --inherit-fd options
+	Key string `json:"key"`
+}
+
+// NamespaceInode returns the inode identifying namespace nsType of pid.
+func NamespaceInode(pid int, nsType string) (uint64, error) {
+	return namespaceInode(fmt.Sprintf("/proc/%d/ns/%s", pid, nsType))
+}
+
+func namespaceInode(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat namespace %s: %w", path, err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("cannot read inode of %s", path)
+	}
+	return stat.Ino, nil
+}
+
+// detectSharedNamespaces compares the namespace inodes of the container with
+// those of the host and, for namespaces Docker says were joined from another
+// container, with that container's.
+func (m *Manager) detectSharedNamespaces(ctx context.Context, containerJSON types.ContainerJSON, inodes map[string]uint64) []SharedNamespace {
+	joined := map[string]string{
+		"net": containerJSON.HostConfig.NetworkMode.ConnectedContainer(),
+		"pid": containerJSON.HostConfig.PidMode.Container(),
+		"ipc": containerJSON.HostConfig.IpcMode.Container(),
+	}
+
+	hostInodes := make(map[string]uint64)
+	for nsType := range inodes {
+		if inode, err := NamespaceInode(1, nsType); err == nil {
+			hostInodes[nsType] = inode
+		}
+	}
+
+	return SharedNamespaces(inodes, hostInodes, joined, func(owner, nsType string) (string, uint64, error) {
+		ownerJSON, err := m.client.ContainerInspect(ctx, owner)
+		if err != nil {
+			m.logger.Warnf("Cannot inspect %s, which owns the %s namespace: %v", owner, nsType, err)
+			return "", 0, err
+		}
+		inode, err := NamespaceInode(ownerJSON.State.Pid, nsType)
+		if err != nil || inode != inodes[nsType] {
+			m.logger.Warnf("The %s namespace is no longer shared with %s", nsType, owner)
+		}
+		return strings.TrimPrefix(ownerJSON.Name, "/"), inode, err
+	})
+}
+
+// SharedNamespaces picks the namespaces in inodes, by type, that are the
+// host's (hostInodes) or belong to the container joined names for their
+// type. ownerNamespace returns the name of such a container and the inode of
+// its namespace of a type.
+func SharedNamespaces(inodes, hostInodes map[string]uint64, joined map[string]string, ownerNamespace func(owner, nsType string) (string, uint64, error)) []SharedNamespace {
+	var shared []SharedNamespace
+	for nsType, inode := range inodes {
+		if hostInode, ok := hostInodes[nsType]; ok && hostInode == inode {
+			shared = append(shared, SharedNamespace{Type: nsType, Inode: inode, Owner: NamespaceOwnerHost})
+			continue
+		}
+
+		owner := joined[nsType]
+		if owner == "" {
+			continue
+		}
+
+		name, ownerInode, err := ownerNamespace(owner, nsType)
+		if err != nil || ownerInode != inode {
+			continue
+		}
+
+		shared = append(shared, SharedNamespace{Type: nsType, Inode: inode, Owner: name})
+	}
+
+	sort.Slice(shared, func(i, j int) bool {
+		return shared[i].Type < shared[j].Type
+	})
+	for i := range shared {
+		shared[i].Key = fmt.Sprintf("%s-ns-%s", shared[i].Type, shared[i].Owner)
+	}
+
+	return shared
+}
+
+// NamespacePath returns the namespace file to rejoin a shared namespace on
+// this host: the host's own, or that of the running owner container.
+func (m *Manager) NamespacePath(ctx context.Context, ns SharedNamespace) (string, error) {
+	if ns.Owner == NamespaceOwnerHost {
+		return fmt.Sprintf("/proc/1/ns/%s", ns.Type), nil
+	}
+
+	pid, err := m.GetContainerPID(ctx, ns.Owner)
+	if err != nil {
+		return "", fmt.Errorf("container %s owning the %s namespace must be running: %w", ns.Owner, ns.Type, err)
+	}
+	return fmt.Sprintf("/proc/%d/ns/%s", pid, ns.Type), nil
+}
@@ -0,0 +1,256 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+)
+
+// NetworkSpec is enough of a user-defined network to recreate it on a host
+// where it does not exist.
+type NetworkSpec struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Internal   bool              `json:"internal"`
+	Attachable bool              `json:"attachable"`
+	EnableIPv6 bool              `json:"enable_ipv6"`
+	IPAM       network.IPAM      `json:"ipam"`
+	Options    map[string]string `json:"options,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// RestoreContainerOptions controls how CreateRestoreContainer attaches the
+// new container to the network.
+type RestoreContainerOptions struct {
+	Name     string
+	Hostname string
+	// Networks to attach to; the original networks when empty
+	Networks []string
+	// IPAddress for the first network; assigned by Docker when empty
+	IPAddress string
+	// Publish are extra "[ip:]host:container[/proto]" port bindings
+	Publish []string
+	// KeepHostPorts binds the original host ports; otherwise the original
+	// bindings get host ports assigned by Docker so they cannot collide
+	// with the still running original
+	KeepHostPorts bool
+	// Hold runs a placeholder instead of the original command, so the
+	// container keeps its namespaces up for the restored processes to join
+	Hold bool
+}
+
+// isBuiltinNetwork reports whether name is one of Docker's default networks,
+// which always exist and cannot be created.
+func isBuiltinNetwork(name string) bool {
+	switch name {
+	case "bridge", "host", "none", "default":
+		return true
+	}
+	return false
+}
+
+// GetNetworkSpecs returns the specs of the user-defined networks the
+// container is attached to.
+func (m *Manager) GetNetworkSpecs(ctx context.Context, state *ContainerState) ([]NetworkSpec, error) {
+	var specs []NetworkSpec
+	for name := range state.NetworkConfig {
+		if isBuiltinNetwork(name) {
+			continue
+		}
+
+		resource, err := m.client.NetworkInspect(ctx, name, types.NetworkInspectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect network %s: %w", name, err)
+		}
+
+		specs = append(specs, NetworkSpec{
+			Name:       resource.Name,
+			Driver:     resource.Driver,
+			Internal:   resource.Internal,
+			Attachable: resource.Attachable,
+			EnableIPv6: resource.EnableIPv6,
+			IPAM:       resource.IPAM,
+			Options:    resource.Options,
+			Labels:     resource.Labels,
+		})
+	}
+
+	return specs, nil
+}
+
+// EnsureNetworks creates the networks among specs that do not exist and
+// returns the IDs of those it created.
+func (m *Manager) EnsureNetworks(ctx context.Context, specs []NetworkSpec) ([]string, error) {
+	var created []string
+	for _, spec := range specs {
+		_, err := m.client.NetworkInspect(ctx, spec.Name, types.NetworkInspectOptions{})
+		if err == nil {
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return created, fmt.Errorf("failed to inspect network %s: %w", spec.Name, err)
+		}
+
+		ipam := spec.IPAM
+		resp, err := m.client.NetworkCreate(ctx, spec.Name, types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         spec.Driver,
+			Internal:       spec.Internal,
+			Attachable:     spec.Attachable,
+			EnableIPv6:     spec.EnableIPv6,
+			IPAM:           &ipam,
+			Options:        spec.Options,
+			Labels:         spec.Labels,
+		})
+		if err != nil {
+			return created, fmt.Errorf("failed to recreate network %s: %w", spec.Name, err)
+		}
+
+		m.logger.Infof("Recreated network %s", spec.Name)
+		created = append(created, resp.ID)
+	}
+
+	return created, nil
+}
+
+func (m *Manager) RemoveNetwork(ctx context.Context, networkID string) error {
+	if err := m.client.NetworkRemove(ctx, networkID); err != nil {
+		return fmt.Errorf("failed to remove network: %w", err)
+	}
+
+	return nil
+}
+
+// GetContainerIPs returns the IP address of a container on each network.
+func (m *Manager) GetContainerIPs(ctx context.Context, containerID string) (map[string]string, error) {
+	containerJSON, err := m.client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	ips := make(map[string]string)
+	if containerJSON.NetworkSettings != nil {
+		for name, endpoint := range containerJSON.NetworkSettings.Networks {
+			ips[name] = endpoint.IPAddress
+		}
+	}
+
+	return ips, nil
+}
+
+// OriginalNetworks returns the networks the container was attached to, its
+// primary network first, or none for host, none and container networking.
+func OriginalNetworks(originalState *ContainerState) []string {
+	mode := originalState.HostConfig.NetworkMode
+	if mode.IsHost() || mode.IsNone() || mode.IsContainer() {
+		return nil
+	}
+
+	var networks []string
+	if _, ok := originalState.NetworkConfig[string(mode)]; ok {
+		networks = append(networks, string(mode))
+	}
+	for name := range originalState.NetworkConfig {
+		if name != string(mode) {
+			networks = append(networks, name)
+		}
+	}
+	return networks
+}
+
+// restoreNetwork is the networking of a container being restored.
+type restoreNetwork struct {
+	mode         container.NetworkMode
+	networks     []string
+	endpoints    map[string]*network.EndpointSettings
+	exposedPorts nat.PortSet
+	portBindings nat.PortMap
+}
+
+// restoreNetworking works out the network mode, endpoints and port bindings
+// of a restored container from the original state and the overrides in opts.
+func restoreNetworking(originalState *ContainerState, opts RestoreContainerOptions) (*restoreNetwork, error) {
+	result := &restoreNetwork{
+		mode:         originalState.HostConfig.NetworkMode,
+		networks:     opts.Networks,
+		endpoints:    make(map[string]*network.EndpointSettings),
+		exposedPorts: nat.PortSet{},
+		portBindings: nat.PortMap{},
+	}
+
+	if len(result.networks) == 0 {
+		result.networks = OriginalNetworks(originalState)
+	}
+
+	if len(result.networks) > 0 {
+		result.mode = container.NetworkMode(result.networks[0])
+	} else if opts.IPAddress != "" || len(opts.Publish) > 0 {
+		return nil, fmt.Errorf("--ip and --publish need a network; the original container used %q networking", result.mode)
+	}
+
+	for i, name := range result.networks {
+		endpoint := &network.EndpointSettings{}
+		if original, ok := originalState.NetworkConfig[name]; ok && !isBuiltinNetwork(name) {
+			endpoint.Aliases = original.Aliases
+		}
+
+		// The original address is not pinned since Docker only accepts
+		// static addresses on user-configured subnets
+		if i == 0 && opts.IPAddress != "" {
+			ip := net.ParseIP(opts.IPAddress)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", opts.IPAddress)
+			}
+			if ip.To4() != nil {
+				endpoint.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: opts.IPAddress}
+			} else {
+				endpoint.IPAMConfig = &network.EndpointIPAMConfig{IPv6Address: opts.IPAddress}
+			}
+		}
+
+		result.endpoints[name] = endpoint
+	}
+
+	for port := range originalState.Config.ExposedPorts {
+		result.exposedPorts[port] = struct{}{}
+	}
+	for port, bindings := range originalState.HostConfig.PortBindings {
+		if !opts.KeepHostPorts {
+			ephemeral := make([]nat.PortBinding, len(bindings))
+			for i, binding := range bindings {
+				ephemeral[i] = nat.PortBinding{HostIP: binding.HostIP}
+			}
+			bindings = ephemeral
+		}
+		result.portBindings[port] = bindings
+	}
+
+	if len(opts.Publish) > 0 {
+		exposed, bindings, err := nat.ParsePortSpecs(opts.Publish)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --publish: %w", err)
+		}
+		for port := range exposed {
+			result.exposedPorts[port] = struct{}{}
+		}
+		for port, portBindings := range bindings {
+			result.portBindings[port] = portBindings
+		}
+	}
+
+	return result, nil
+}
+
+// IsPortConflict reports whether a container failed to start because a host
+// port it publishes is taken.
+func IsPortConflict(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "port is already allocated") ||
+		strings.Contains(err.Error(), "address already in use"))
+}
@@ -74,6 +74,9 @@ type CheckpointAnalysis struct {
 	NetworkInfo   *NetworkInfo                   `json:"network_info"`
 	ResourceUsage *ResourceUsage                 `json:"resource_usage"`
 	CRIUInfo      *CRIUInfo                      `json:"criu_info"`
+	// FromImages is set when the process tree was read from the CRIU images
+	// rather than reconstructed from the container metadata
+	FromImages    bool                           `json:"from_images"`
 }
 
 type NetworkInfo struct {
@@ -112,6 +115,8 @@ type ResourceUsage struct {
 	Processes   int                `json:"processes"`
 	Threads     int                `json:"threads"`
 	Cgroups     map[string]string  `json:"cgroups"`
+	CgroupMode  string             `json:"cgroup_mode,omitempty"`
+	CgroupLimits map[string]string `json:"cgroup_limits,omitempty"`
 }
 
 type CRIUInfo struct {
@@ -166,14 +171,36 @@ func (a *Analyzer) AnalyzeCheckpoint(checkpointDir string) (*CheckpointAnalysis,
 		}
 	}
 
-	// 4. Build process tree (simplified)
-	if analysis.Metadata != nil {
+	// 4. Build process tree, from the images when they can be read
+	var imageTree *ProcessInfo
+	if utils.DirExists(imagesDir) {
+		tree, err := a.loadImageProcessTree(imagesDir)
+		if err != nil {
+			a.logger.Warnf("Failed to read process tree from images: %v", err)
+		} else {
+			imageTree = tree
+		}
+	}
+
+	if imageTree != nil {
+		if analysis.Metadata != nil && analysis.Metadata.ContainerState != nil {
+			// The images do not carry the environment without reading memory
+			summary := a.buildProcessTree(analysis.Metadata.ContainerState)
+			imageTree.Environment = summary.Environment
+			imageTree.Args = summary.Args
+		}
+		analysis.ProcessTree = imageTree
+		analysis.FromImages = true
+	} else if analysis.Metadata != nil {
 		processTree := a.buildProcessTree(analysis.Metadata.ContainerState)
 		analysis.ProcessTree = processTree
 	}
 
 	// 5. Analyze resource usage
 	resourceUsage := a.analyzeResourceUsage(checkpointDir, analysis.Metadata)
+	if imageTree != nil {
+		resourceUsage.Processes, resourceUsage.OpenFiles = countProcesses(imageTree)
+	}
 	analysis.ResourceUsage = resourceUsage
 
 	return analysis, nil
@@ -396,9 +423,19 @@ func (a *Analyzer) analyzeResourceUsage(checkpointDir string, metadata *checkpoi
 		usage.Processes = 1 // At least the main process
 		usage.OpenFiles = len(a.buildMockFileDescriptors())
 
-		// Mock cgroup info
-		usage.Cgroups["memory"] = fmt.Sprintf("/docker/%s", state.ID[:12])
-		usage.Cgroups["cpu"] = fmt.Sprintf("/docker/%s", state.ID[:12])
+		// Checkpoints predating cgroup detection only know the parent
+		if state.Cgroup != nil {
+			usage.CgroupMode = state.Cgroup.Mode
+			usage.CgroupLimits = state.Cgroup.Limits
+			for controller, path := range state.Cgroup.Controllers {
+				if controller == "" {
+					controller = "unified"
+				}
+				usage.Cgroups[controller] = path
+			}
+		} else if state.CgroupPath != "" {
+			usage.Cgroups["parent"] = state.CgroupPath
+		}
 	}
 
 	return usage
@@ -0,0 +1,497 @@
+package inspect
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/checkpoint-restore/go-criu/v7/crit"
+	core_x86 "github.com/checkpoint-restore/go-criu/v7/crit/images/core-x86"
+	criu_core "github.com/checkpoint-restore/go-criu/v7/crit/images/criu-core"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/fdinfo"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/mm"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/pstree"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/vma"
+	"google.golang.org/protobuf/proto"
+)
+
+// Note types debug/elf does not define
+const (
+	ntAuxv = 6
+	ntFile = 0x46494c45
+)
+
+// VMA status bits, from CRIU's vma.h
+const (
+	vmaFilePrivate = 1 << 6
+	vmaFileShared  = 1 << 7
+)
+
+// Memory is copied into the core in chunks of this size
+const coreChunkSize = 1 << 20
+
+type CoreDump struct {
+	PID      int    `json:"pid"`
+	Command  string `json:"command"`
+	Path     string `json:"path"`
+	Threads  int    `json:"threads"`
+	Segments int    `json:"segments"`
+	Size     int64  `json:"size"`
+}
+
+// struct elf_prstatus on x86_64
+type elfPrstatus struct {
+	SigInfo [3]int32
+	CurSig  int16
+	_       [2]byte
+	SigPend uint64
+	SigHold uint64
+	PID     int32
+	PPID    int32
+	PGrp    int32
+	SID     int32
+	Times   [8]uint64
+	Regs    [27]uint64
+	FPValid int32
+	_       [4]byte
+}
+
+// struct elf_prpsinfo on x86_64
+type elfPrpsinfo struct {
+	State  int8
+	Sname  uint8
+	Zomb   int8
+	Nice   int8
+	_      [4]byte
+	Flag   uint64
+	UID    uint32
+	GID    uint32
+	PID    int32
+	PPID   int32
+	PGrp   int32
+	SID    int32
+	Fname  [16]byte
+	Psargs [80]byte
+}
+
+// struct user_fpregs_struct on x86_64
+type elfFpregs struct {
+	Cwd       uint16
+	Swd       uint16
+	Twd       uint16
+	Fop       uint16
+	Rip       uint64
+	Rdp       uint64
+	Mxcsr     uint32
+	MxcsrMask uint32
+	StSpace   [32]uint32
+	XmmSpace  [64]uint32
+	Padding   [24]uint32
+}
+
+type threadCore struct {
+	tid  uint32
+	core *criu_core.CoreEntry
+}
+
+func decodeImage(path string, entryType proto.Message) (*crit.CriuImage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := crit.New(file, nil, "", false, false).Decode(entryType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filepath.Base(path), err)
+	}
+	if len(img.Entries) == 0 {
+		return nil, fmt.Errorf("%s has no entries", filepath.Base(path))
+	}
+	return img, nil
+}
+
+// GenerateCoreDumps writes an ELF core file core.<pid> for pid, or for every
+// process when pid is 0, like criu-coredump. Cores are written to outputDir,
+// or to the checkpoint directory when it is empty. Only x86_64 images are
+// supported.
+func (a *Analyzer) GenerateCoreDumps(checkpointDir string, pid int, outputDir string) ([]CoreDump, error) {
+	imagesDir := filepath.Join(checkpointDir, "images")
+	if outputDir == "" {
+		outputDir = checkpointDir
+	}
+	// Cores hold the process memory, secrets included
+	if err := os.MkdirAll(outputDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	psTree, err := decodeImage(filepath.Join(imagesDir, "pstree.img"), &pstree.PstreeEntry{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process tree: %w", err)
+	}
+
+	files, err := regularFiles(imagesDir)
+	if err != nil {
+		a.logger.Warnf("Cannot read file paths, mapped files are left out of the cores: %v", err)
+	}
+
+	var dumps []CoreDump
+	for _, entry := range psTree.Entries {
+		process := entry.Message.(*pstree.PstreeEntry)
+		if pid != 0 && int(process.GetPid()) != pid {
+			continue
+		}
+
+		outputPath := filepath.Join(outputDir, fmt.Sprintf("core.%d", process.GetPid()))
+		dump, err := a.writeCoreDump(imagesDir, process, files, outputPath)
+		if err != nil {
+			if pid != 0 {
+				return nil, err
+			}
+			a.logger.Warnf("Skipping PID %d: %v", process.GetPid(), err)
+			continue
+		}
+		dumps = append(dumps, *dump)
+	}
+
+	if pid != 0 && len(dumps) == 0 {
+		return nil, fmt.Errorf("PID %d is not in the checkpoint", pid)
+	}
+	return dumps, nil
+}
+
+// regularFiles maps the IDs of regular files to their paths.
+func regularFiles(imagesDir string) (map[uint32]string, error) {
+	img, err := decodeImage(filepath.Join(imagesDir, "files.img"), &fdinfo.FileEntry{})
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[uint32]string)
+	for _, entry := range img.Entries {
+		file := entry.Message.(*fdinfo.FileEntry)
+		if file.GetReg() != nil {
+			files[file.GetId()] = file.GetReg().GetName()
+		}
+	}
+	return files, nil
+}
+
+func (a *Analyzer) writeCoreDump(imagesDir string, process *pstree.PstreeEntry, files map[uint32]string, outputPath string) (*CoreDump, error) {
+	pid := process.GetPid()
+
+	threads := process.GetThreads()
+	if len(threads) == 0 {
+		threads = []uint32{pid}
+	}
+	cores := make([]threadCore, 0, len(threads))
+	for _, tid := range threads {
+		img, err := decodeImage(filepath.Join(imagesDir, fmt.Sprintf("core-%d.img", tid)), &criu_core.CoreEntry{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read core of thread %d: %w", tid, err)
+		}
+		core := img.Entries[0].Message.(*criu_core.CoreEntry)
+		if core.GetMtype() != criu_core.CoreEntry_X86_64 {
+			return nil, fmt.Errorf("architecture %s is not supported", core.GetMtype())
+		}
+		if core.GetThreadInfo().GetGpregs().GetMode() != core_x86.UserX86RegsMode_NATIVE {
+			return nil, fmt.Errorf("32-bit tasks are not supported")
+		}
+		// The main thread comes first, as in kernel cores
+		if tid == pid {
+			cores = append([]threadCore{{tid, core}}, cores...)
+		} else {
+			cores = append(cores, threadCore{tid, core})
+		}
+	}
+
+	mmImg, err := decodeImage(filepath.Join(imagesDir, fmt.Sprintf("mm-%d.img", pid)), &mm.MmEntry{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory layout: %w", err)
+	}
+	mmEntry := mmImg.Entries[0].Message.(*mm.MmEntry)
+
+	command := cores[0].core.GetTc().GetComm()
+	mem, err := a.openProcessMemory(imagesDir, &ProcessInfo{PID: int(pid), Command: command})
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	notes, err := coreNotes(process, cores, mmEntry, mem, files)
+	if err != nil {
+		return nil, err
+	}
+
+	vmas := mmEntry.GetVmas()
+	var segments []coreSegment
+	for _, v := range vmas {
+		segments = append(segments, vmaSegments(v, mem)...)
+	}
+
+	// Segments start page aligned after the headers and notes
+	pageSize := uint64(os.Getpagesize())
+	headersSize := uint64(64 + 56*(len(segments)+1))
+	dataStart := (headersSize + uint64(len(notes)) + pageSize - 1) / pageSize * pageSize
+	offset := dataStart
+
+	phdrs := []elf.Prog64{{
+		Type:   uint32(elf.PT_NOTE),
+		Off:    headersSize,
+		Filesz: uint64(len(notes)),
+	}}
+	for _, segment := range segments {
+		size := segment.end - segment.start
+		phdr := elf.Prog64{
+			Type:  uint32(elf.PT_LOAD),
+			Flags: segmentFlags(segment.vma),
+			Off:   offset,
+			Vaddr: segment.start,
+			Memsz: size,
+			Align: pageSize,
+		}
+		// Without dumped pages, e.g. unmodified file mappings, gdb reads the
+		// contents from the mapped file instead
+		if segment.dumped {
+			phdr.Filesz = size
+			offset += size
+		}
+		phdrs = append(phdrs, phdr)
+	}
+
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create core file: %w", err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     64,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     uint16(len(phdrs)),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	header.Ident[elf.EI_OSABI] = byte(elf.ELFOSABI_NONE)
+
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("failed to write core file: %w", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, phdrs); err != nil {
+		return nil, fmt.Errorf("failed to write core file: %w", err)
+	}
+	w.Write(notes)
+	w.Write(make([]byte, dataStart-headersSize-uint64(len(notes))))
+
+	for i, phdr := range phdrs[1:] {
+		for start := phdr.Vaddr; start < phdr.Vaddr+phdr.Filesz; start += coreChunkSize {
+			data, err := mem.read(start, min(start+coreChunkSize, phdr.Vaddr+phdr.Filesz))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(data); err != nil {
+				return nil, fmt.Errorf("failed to write segment %d: %w", i, err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write core file: %w", err)
+	}
+
+	a.logger.Debugf("Wrote core of PID %d to %s", pid, outputPath)
+	return &CoreDump{
+		PID:      int(pid),
+		Command:  command,
+		Path:     outputPath,
+		Threads:  len(cores),
+		Segments: len(segments),
+		Size:     int64(offset),
+	}, nil
+}
+
+// coreSegment is a range of a VMA written as one PT_LOAD segment.
+type coreSegment struct {
+	vma        *vma.VmaEntry
+	start, end uint64
+	dumped     bool
+}
+
+// vmaSegments splits a VMA into the segments of a core. Anonymous memory
+// that was not dumped is zero, so a VMA with any dumped pages is written
+// whole. A file mapping is split so that only its dumped pages are written
+// and gdb reads the rest from the mapped file.
+func vmaSegments(v *vma.VmaEntry, mem *processMemory) []coreSegment {
+	ranges := mem.dumpedRanges(v.GetStart(), v.GetEnd())
+	if len(ranges) == 0 {
+		return []coreSegment{{vma: v, start: v.GetStart(), end: v.GetEnd()}}
+	}
+	if v.GetStatus()&(vmaFilePrivate|vmaFileShared) == 0 {
+		return []coreSegment{{vma: v, start: v.GetStart(), end: v.GetEnd(), dumped: true}}
+	}
+
+	var segments []coreSegment
+	addr := v.GetStart()
+	for _, r := range ranges {
+		if r[0] > addr {
+			segments = append(segments, coreSegment{vma: v, start: addr, end: r[0]})
+		}
+		segments = append(segments, coreSegment{vma: v, start: r[0], end: r[1], dumped: true})
+		addr = r[1]
+	}
+	if addr < v.GetEnd() {
+		segments = append(segments, coreSegment{vma: v, start: addr, end: v.GetEnd()})
+	}
+	return segments
+}
+
+func segmentFlags(v *vma.VmaEntry) uint32 {
+	var flags elf.ProgFlag
+	prot := v.GetProt()
+	if prot&0x1 != 0 {
+		flags |= elf.PF_R
+	}
+	if prot&0x2 != 0 {
+		flags |= elf.PF_W
+	}
+	if prot&0x4 != 0 {
+		flags |= elf.PF_X
+	}
+	return uint32(flags)
+}
+
+// coreNotes builds the PT_NOTE segment in the order the kernel writes it:
+// the main thread's status, process info, auxv, mapped files and FP
+// registers, then the status and FP registers of every other thread.
+func coreNotes(process *pstree.PstreeEntry, cores []threadCore, mmEntry *mm.MmEntry, mem *processMemory, files map[uint32]string) ([]byte, error) {
+	var notes bytes.Buffer
+
+	for i, thread := range cores {
+		writeNote(&notes, uint32(elf.NT_PRSTATUS), prstatus(process, thread))
+
+		if i == 0 {
+			info, err := prpsinfo(process, thread.core, mmEntry, mem)
+			if err != nil {
+				return nil, err
+			}
+			writeNote(&notes, uint32(elf.NT_PRPSINFO), info)
+			writeNote(&notes, ntAuxv, mmEntry.GetMmSavedAuxv())
+			writeNote(&notes, ntFile, fileNote(mmEntry.GetVmas(), files))
+		}
+
+		writeNote(&notes, uint32(elf.NT_FPREGSET), fpregs(thread.core))
+	}
+
+	return notes.Bytes(), nil
+}
+
+func writeNote(notes *bytes.Buffer, noteType uint32, desc interface{}) {
+	var data bytes.Buffer
+	if raw, ok := desc.([]byte); ok {
+		data.Write(raw)
+	} else {
+		binary.Write(&data, binary.LittleEndian, desc)
+	}
+
+	name := "CORE\x00"
+	binary.Write(notes, binary.LittleEndian, [3]uint32{uint32(len(name)), uint32(data.Len()), noteType})
+	notes.WriteString(name)
+	notes.Write(make([]byte, (4-len(name)%4)%4))
+	notes.Write(data.Bytes())
+	notes.Write(make([]byte, (4-data.Len()%4)%4))
+}
+
+func prstatus(process *pstree.PstreeEntry, thread threadCore) elfPrstatus {
+	regs := thread.core.GetThreadInfo().GetGpregs()
+	return elfPrstatus{
+		SigHold: thread.core.GetTc().GetBlkSigset(),
+		PID:     int32(thread.tid),
+		PPID:    int32(process.GetPpid()),
+		PGrp:    int32(process.GetPgid()),
+		SID:     int32(process.GetSid()),
+		FPValid: 1,
+		// The order of struct user_regs_struct
+		Regs: [27]uint64{
+			regs.GetR15(), regs.GetR14(), regs.GetR13(), regs.GetR12(), regs.GetBp(), regs.GetBx(),
+			regs.GetR11(), regs.GetR10(), regs.GetR9(), regs.GetR8(), regs.GetAx(), regs.GetCx(),
+			regs.GetDx(), regs.GetSi(), regs.GetDi(), regs.GetOrigAx(), regs.GetIp(), regs.GetCs(),
+			regs.GetFlags(), regs.GetSp(), regs.GetSs(), regs.GetFsBase(), regs.GetGsBase(),
+			regs.GetDs(), regs.GetEs(), regs.GetFs(), regs.GetGs(),
+		},
+	}
+}
+
+func prpsinfo(process *pstree.PstreeEntry, core *criu_core.CoreEntry, mmEntry *mm.MmEntry, mem *processMemory) (elfPrpsinfo, error) {
+	info := elfPrpsinfo{
+		Sname: 'R',
+		PID:   int32(process.GetPid()),
+		PPID:  int32(process.GetPpid()),
+		PGrp:  int32(process.GetPgid()),
+		SID:   int32(process.GetSid()),
+	}
+	copy(info.Fname[:len(info.Fname)-1], core.GetTc().GetComm())
+
+	if mmEntry.GetMmArgEnd() > mmEntry.GetMmArgStart() {
+		args, err := mem.read(mmEntry.GetMmArgStart(), mmEntry.GetMmArgEnd())
+		if err != nil {
+			return info, err
+		}
+		psargs := strings.TrimSpace(strings.ReplaceAll(string(args), "\x00", " "))
+		copy(info.Psargs[:len(info.Psargs)-1], psargs)
+	}
+	return info, nil
+}
+
+func fpregs(core *criu_core.CoreEntry) elfFpregs {
+	fp := core.GetThreadInfo().GetFpregs()
+	regs := elfFpregs{
+		Cwd:       uint16(fp.GetCwd()),
+		Swd:       uint16(fp.GetSwd()),
+		Twd:       uint16(fp.GetTwd()),
+		Fop:       uint16(fp.GetFop()),
+		Rip:       fp.GetRip(),
+		Rdp:       fp.GetRdp(),
+		Mxcsr:     fp.GetMxcsr(),
+		MxcsrMask: fp.GetMxcsrMask(),
+	}
+	copy(regs.StSpace[:], fp.GetStSpace())
+	copy(regs.XmmSpace[:], fp.GetXmmSpace())
+	return regs
+}
+
+// fileNote builds the NT_FILE note listing the file backed mappings, which
+// gdb uses to find shared libraries.
+func fileNote(vmas []*vma.VmaEntry, files map[uint32]string) []byte {
+	pageSize := uint64(os.Getpagesize())
+
+	var ranges []uint64
+	var names bytes.Buffer
+	for _, v := range vmas {
+		if v.GetStatus()&(vmaFilePrivate|vmaFileShared) == 0 {
+			continue
+		}
+		name, ok := files[uint32(v.GetShmid())]
+		if !ok {
+			continue
+		}
+		ranges = append(ranges, v.GetStart(), v.GetEnd(), v.GetPgoff()/pageSize)
+		names.WriteString(name + "\x00")
+	}
+
+	var note bytes.Buffer
+	binary.Write(&note, binary.LittleEndian, []uint64{uint64(len(ranges) / 3), pageSize})
+	binary.Write(&note, binary.LittleEndian, ranges)
+	note.Write(names.Bytes())
+	return note.Bytes()
+}
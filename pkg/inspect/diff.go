@@ -0,0 +1,363 @@
+package inspect
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Kinds of change in a checkpoint diff
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+type ProcessChange struct {
+	Change  string `json:"change"`
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+type FileChange struct {
+	Change  string `json:"change"`
+	PID     int    `json:"pid"`
+	FD      int    `json:"fd"`
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+}
+
+type SocketChange struct {
+	Change   string `json:"change"`
+	PID      int    `json:"pid"`
+	FD       int    `json:"fd"`
+	Socket   string `json:"socket"`
+	OldState string `json:"old_state,omitempty"`
+	State    string `json:"state,omitempty"`
+}
+
+type MemoryChange struct {
+	Change  string `json:"change"`
+	PID     int    `json:"pid"`
+	Start   string `json:"start"`
+	Path    string `json:"path,omitempty"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+	Delta   int64  `json:"delta"`
+}
+
+type ValueChange struct {
+	Change string `json:"change"`
+	Key    string `json:"key"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+type ImageChange struct {
+	Change  string `json:"change"`
+	File    string `json:"file"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+	Delta   int64  `json:"delta"`
+}
+
+// CheckpointDiff lists what changed between two checkpoints of a container.
+type CheckpointDiff struct {
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	Processes   []ProcessChange `json:"processes"`
+	Files       []FileChange    `json:"files"`
+	Sockets     []SocketChange  `json:"sockets"`
+	Memory      []MemoryChange  `json:"memory"`
+	MemoryDelta int64           `json:"memory_delta"`
+	Environment []ValueChange   `json:"environment"`
+	Mounts      []ValueChange   `json:"mounts"`
+	Images      []ImageChange   `json:"images"`
+	ImagesDelta int64           `json:"images_delta"`
+}
+
+// DiffCheckpoints compares two checkpoints, normally of the same container
+// taken at different times, to track e.g. leaked descriptors or memory.
+func (a *Analyzer) DiffCheckpoints(fromDir, toDir string) (*CheckpointDiff, error) {
+	from, err := a.AnalyzeCheckpoint(fromDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze %s: %w", fromDir, err)
+	}
+	to, err := a.AnalyzeCheckpoint(toDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze %s: %w", toDir, err)
+	}
+
+	if from.Metadata != nil && to.Metadata != nil && from.Metadata.ContainerState != nil && to.Metadata.ContainerState != nil &&
+		from.Metadata.ContainerState.ID != to.Metadata.ContainerState.ID {
+		a.logger.Warnf("Comparing checkpoints of different containers: %s and %s",
+			from.Metadata.ContainerState.Name, to.Metadata.ContainerState.Name)
+	}
+
+	diff := &CheckpointDiff{From: fromDir, To: toDir}
+
+	fromProcs := flattenProcesses(from.ProcessTree)
+	toProcs := flattenProcesses(to.ProcessTree)
+	diff.diffProcesses(fromProcs, toProcs)
+
+	if from.ProcessTree != nil && to.ProcessTree != nil {
+		diff.Environment = diffValues(from.ProcessTree.Environment, to.ProcessTree.Environment)
+	}
+
+	fromMounts := make(map[string]string)
+	for _, m := range from.MountMappings {
+		fromMounts[m.ContainerPath] = mountSummary(m.HostPath, m.Type, m.ReadOnly)
+	}
+	toMounts := make(map[string]string)
+	for _, m := range to.MountMappings {
+		toMounts[m.ContainerPath] = mountSummary(m.HostPath, m.Type, m.ReadOnly)
+	}
+	diff.Mounts = diffValues(fromMounts, toMounts)
+
+	fromImages, err := imageSizes(filepath.Join(fromDir, "images"))
+	if err != nil {
+		return nil, err
+	}
+	toImages, err := imageSizes(filepath.Join(toDir, "images"))
+	if err != nil {
+		return nil, err
+	}
+	diff.diffImages(fromImages, toImages)
+
+	return diff, nil
+}
+
+func mountSummary(hostPath, mountType string, readOnly bool) string {
+	mode := "rw"
+	if readOnly {
+		mode = "ro"
+	}
+	return fmt.Sprintf("%s (%s, %s)", hostPath, mountType, mode)
+}
+
+func flattenProcesses(process *ProcessInfo) map[int]*ProcessInfo {
+	procs := make(map[int]*ProcessInfo)
+	var walk func(p *ProcessInfo)
+	walk = func(p *ProcessInfo) {
+		procs[p.PID] = p
+		for i := range p.Children {
+			walk(&p.Children[i])
+		}
+	}
+	if process != nil {
+		walk(process)
+	}
+	return procs
+}
+
+func sortedPIDs(procs ...map[int]*ProcessInfo) []int {
+	seen := make(map[int]bool)
+	var pids []int
+	for _, m := range procs {
+		for pid := range m {
+			if !seen[pid] {
+				seen[pid] = true
+				pids = append(pids, pid)
+			}
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// diffProcesses compares processes by PID, which CRIU keeps stable across
+// restores, and for processes in both checkpoints their files, sockets and
+// memory mappings.
+func (d *CheckpointDiff) diffProcesses(from, to map[int]*ProcessInfo) {
+	for _, pid := range sortedPIDs(from, to) {
+		before, after := from[pid], to[pid]
+		switch {
+		case before == nil:
+			d.Processes = append(d.Processes, ProcessChange{Change: ChangeAdded, PID: pid, Command: after.Command})
+		case after == nil:
+			d.Processes = append(d.Processes, ProcessChange{Change: ChangeRemoved, PID: pid, Command: before.Command})
+		default:
+			if before.Command != after.Command {
+				d.Processes = append(d.Processes, ProcessChange{Change: ChangeChanged, PID: pid, Command: after.Command, Detail: "was " + before.Command})
+			}
+			d.diffFiles(pid, before.FileDescriptors, after.FileDescriptors)
+			d.diffSockets(pid, before.Sockets, after.Sockets)
+			d.diffMemory(pid, before.MemoryMaps, after.MemoryMaps)
+		}
+	}
+}
+
+func (d *CheckpointDiff) diffFiles(pid int, from, to []FileDescriptor) {
+	old := make(map[int]FileDescriptor)
+	for _, fd := range from {
+		old[fd.FD] = fd
+	}
+	seen := make(map[int]bool)
+
+	for _, fd := range to {
+		seen[fd.FD] = true
+		prev, ok := old[fd.FD]
+		switch {
+		case !ok:
+			d.Files = append(d.Files, FileChange{Change: ChangeAdded, PID: pid, FD: fd.FD, Path: fd.Path})
+		case prev.Path != fd.Path:
+			d.Files = append(d.Files, FileChange{Change: ChangeChanged, PID: pid, FD: fd.FD, Path: fd.Path, OldPath: prev.Path})
+		}
+	}
+	for _, fd := range from {
+		if !seen[fd.FD] {
+			d.Files = append(d.Files, FileChange{Change: ChangeRemoved, PID: pid, FD: fd.FD, Path: fd.Path})
+		}
+	}
+}
+
+func socketSummary(s SocketInfo) string {
+	if s.Type == "UNIX" {
+		return fmt.Sprintf("UNIX %s", s.LocalAddr)
+	}
+	return fmt.Sprintf("%s %s:%d -> %s:%d", s.Type, s.LocalAddr, s.LocalPort, s.RemoteAddr, s.RemotePort)
+}
+
+func (d *CheckpointDiff) diffSockets(pid int, from, to []SocketInfo) {
+	old := make(map[int]SocketInfo)
+	for _, s := range from {
+		old[s.FD] = s
+	}
+	seen := make(map[int]bool)
+
+	for _, s := range to {
+		seen[s.FD] = true
+		prev, ok := old[s.FD]
+		switch {
+		case !ok:
+			d.Sockets = append(d.Sockets, SocketChange{Change: ChangeAdded, PID: pid, FD: s.FD, Socket: socketSummary(s), State: s.State})
+		case socketSummary(prev) != socketSummary(s) || prev.State != s.State:
+			d.Sockets = append(d.Sockets, SocketChange{Change: ChangeChanged, PID: pid, FD: s.FD, Socket: socketSummary(s), OldState: prev.State, State: s.State})
+		}
+	}
+	for _, s := range from {
+		if !seen[s.FD] {
+			d.Sockets = append(d.Sockets, SocketChange{Change: ChangeRemoved, PID: pid, FD: s.FD, Socket: socketSummary(s), OldState: s.State})
+		}
+	}
+}
+
+// diffMemory matches mappings by start address, so a heap or stack that grew
+// in place shows up as one changed mapping.
+func (d *CheckpointDiff) diffMemory(pid int, from, to []MemoryMap) {
+	old := make(map[string]MemoryMap)
+	for _, m := range from {
+		old[m.StartAddr] = m
+	}
+	seen := make(map[string]bool)
+
+	for _, m := range to {
+		seen[m.StartAddr] = true
+		prev, ok := old[m.StartAddr]
+		switch {
+		case !ok:
+			d.addMemoryChange(MemoryChange{Change: ChangeAdded, PID: pid, Start: m.StartAddr, Path: m.Path, NewSize: m.Size})
+		case prev.Size != m.Size:
+			d.addMemoryChange(MemoryChange{Change: ChangeChanged, PID: pid, Start: m.StartAddr, Path: m.Path, OldSize: prev.Size, NewSize: m.Size})
+		}
+	}
+	for _, m := range from {
+		if !seen[m.StartAddr] {
+			d.addMemoryChange(MemoryChange{Change: ChangeRemoved, PID: pid, Start: m.StartAddr, Path: m.Path, OldSize: m.Size})
+		}
+	}
+}
+
+func (d *CheckpointDiff) addMemoryChange(change MemoryChange) {
+	change.Delta = change.NewSize - change.OldSize
+	d.MemoryDelta += change.Delta
+	d.Memory = append(d.Memory, change)
+}
+
+func diffValues(from, to map[string]string) []ValueChange {
+	keys := make(map[string]bool)
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []ValueChange
+	for _, k := range sorted {
+		before, hadBefore := from[k]
+		after, hasAfter := to[k]
+		switch {
+		case !hadBefore:
+			changes = append(changes, ValueChange{Change: ChangeAdded, Key: k, New: after})
+		case !hasAfter:
+			changes = append(changes, ValueChange{Change: ChangeRemoved, Key: k, Old: before})
+		case before != after:
+			changes = append(changes, ValueChange{Change: ChangeChanged, Key: k, Old: before, New: after})
+		}
+	}
+	return changes
+}
+
+// imageSizes returns the size of every file in a checkpoint's images
+// directory. The parent link of incremental checkpoints is not followed.
+func imageSizes(imagesDir string) (map[string]int64, error) {
+	entries, err := os.ReadDir(imagesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]int64{}, nil
+		}
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	sizes := make(map[string]int64)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		sizes[entry.Name()] = info.Size()
+	}
+	return sizes, nil
+}
+
+func (d *CheckpointDiff) diffImages(from, to map[string]int64) {
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		before, hadBefore := from[name]
+		after, hasAfter := to[name]
+		change := ImageChange{File: name, OldSize: before, NewSize: after, Delta: after - before}
+		switch {
+		case !hadBefore:
+			change.Change = ChangeAdded
+		case !hasAfter:
+			change.Change = ChangeRemoved
+		case before != after:
+			change.Change = ChangeChanged
+		default:
+			continue
+		}
+		d.ImagesDelta += change.Delta
+		d.Images = append(d.Images, change)
+	}
+}
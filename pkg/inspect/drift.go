@@ -0,0 +1,198 @@
+package inspect
+
+import (
+	"context"
+	"docker-cr/pkg/docker"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DriftReport lists how a running container differs from a checkpoint of it.
+type DriftReport struct {
+	Checkpoint  string          `json:"checkpoint"`
+	Container   string          `json:"container"`
+	Config      []ValueChange   `json:"config"`
+	Environment []ValueChange   `json:"environment"`
+	Mounts      []ValueChange   `json:"mounts"`
+	Processes   []ProcessChange `json:"processes"`
+	Ports       []ValueChange   `json:"ports"`
+	// Skipped lists the comparisons that could not be made
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+func (r *DriftReport) HasDrift() bool {
+	return len(r.Config)+len(r.Environment)+len(r.Mounts)+len(r.Processes)+len(r.Ports) > 0
+}
+
+// DetectDrift compares a checkpoint with the container as it runs now. Added
+// entries exist only in the live container, removed ones only in the
+// checkpoint.
+func (a *Analyzer) DetectDrift(ctx context.Context, checkpointDir string, dockerManager *docker.Manager, container string) (*DriftReport, error) {
+	analysis, err := a.AnalyzeCheckpoint(checkpointDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze checkpoint: %w", err)
+	}
+	if analysis.Metadata == nil || analysis.Metadata.ContainerState == nil {
+		return nil, fmt.Errorf("checkpoint %s has no container metadata", checkpointDir)
+	}
+	saved := analysis.Metadata.ContainerState
+
+	live, err := dockerManager.GetContainerState(ctx, container)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container state: %w", err)
+	}
+	if live.ID != saved.ID {
+		a.logger.Warnf("Container %s is not the one checkpointed (%s)", live.Name, saved.Name)
+	}
+
+	current := LiveState{State: live}
+	current.Mounts, err = dockerManager.GetMountMappings(live)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mount mappings: %w", err)
+	}
+
+	if analysis.FromImages && live.ProcessPID != 0 {
+		var pids []int
+		pids, current.Commands, err = liveProcesses(live.ProcessPID)
+		if err != nil {
+			return nil, err
+		}
+		current.Ports, err = liveListeningPorts(pids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return CompareDrift(checkpointDir, analysis, current), nil
+}
+
+// LiveState is what DetectDrift reads of a running container. Commands and
+// Ports are only read when the checkpoint images can be compared to them.
+type LiveState struct {
+	State    *docker.ContainerState
+	Mounts   []docker.MountMapping
+	Commands []string
+	Ports    map[string]bool
+}
+
+// CompareDrift compares an analyzed checkpoint with the live state of its
+// container.
+func CompareDrift(checkpointDir string, analysis *CheckpointAnalysis, live LiveState) *DriftReport {
+	saved := analysis.Metadata.ContainerState
+	report := &DriftReport{Checkpoint: checkpointDir, Container: live.State.Name}
+
+	report.Config = diffValues(containerConfig(saved), containerConfig(live.State))
+	report.Environment = diffValues(saved.Environment, live.State.Environment)
+
+	savedMounts := make(map[string]string)
+	for _, m := range analysis.MountMappings {
+		savedMounts[m.ContainerPath] = mountSummary(m.HostPath, m.Type, m.ReadOnly)
+	}
+	liveMounts := make(map[string]string)
+	for _, m := range live.Mounts {
+		liveMounts[m.ContainerPath] = mountSummary(m.HostPath, m.Type, m.ReadOnly)
+	}
+	report.Mounts = diffValues(savedMounts, liveMounts)
+
+	// Without the images the process tree is only a guess from the metadata
+	if !analysis.FromImages {
+		report.Skipped = append(report.Skipped, "processes and ports: the checkpoint images cannot be read")
+		return report
+	}
+	if live.State.ProcessPID == 0 {
+		report.Skipped = append(report.Skipped, "processes and ports: the container is not running")
+		return report
+	}
+
+	var savedCommands []string
+	for _, p := range flattenProcesses(analysis.ProcessTree) {
+		savedCommands = append(savedCommands, p.Command)
+	}
+	report.Processes = diffCommands(savedCommands, live.Commands)
+	report.Ports = diffValues(portSet(checkpointPorts(analysis.ProcessTree)), portSet(live.Ports))
+
+	return report
+}
+
+func containerConfig(state *docker.ContainerState) map[string]string {
+	config := map[string]string{"image": state.Image}
+	if state.Config != nil {
+		config["cmd"] = strings.Join(state.Config.Cmd, " ")
+		config["entrypoint"] = strings.Join(state.Config.Entrypoint, " ")
+		config["working_dir"] = state.Config.WorkingDir
+		config["user"] = state.Config.User
+	}
+	for key, value := range config {
+		if value == "" {
+			delete(config, key)
+		}
+	}
+	return config
+}
+
+// diffCommands compares process lists by command name, since PIDs of a
+// container that was restarted or restored are not comparable.
+func diffCommands(from, to []string) []ProcessChange {
+	counts := make(map[string][2]int)
+	for _, command := range from {
+		c := counts[command]
+		c[0]++
+		counts[command] = c
+	}
+	for _, command := range to {
+		c := counts[command]
+		c[1]++
+		counts[command] = c
+	}
+
+	commands := make([]string, 0, len(counts))
+	for command := range counts {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	var changes []ProcessChange
+	for _, command := range commands {
+		c := counts[command]
+		change := ProcessChange{Command: command, Detail: fmt.Sprintf("%d in checkpoint, %d running", c[0], c[1])}
+		switch {
+		case c[0] == 0:
+			change.Change = ChangeAdded
+		case c[1] == 0:
+			change.Change = ChangeRemoved
+		case c[0] != c[1]:
+			change.Change = ChangeChanged
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// checkpointPorts returns the listening TCP and bound UDP ports of a process
+// tree read from the images, as "tcp/80" or "udp/53".
+func checkpointPorts(process *ProcessInfo) map[string]bool {
+	ports := make(map[string]bool)
+	for _, p := range flattenProcesses(process) {
+		for _, s := range p.Sockets {
+			switch {
+			case s.Protocol == "tcp" && s.State == "LISTEN":
+			case s.Protocol == "udp" && s.RemotePort == 0:
+			default:
+				continue
+			}
+			ports[fmt.Sprintf("%s/%d", s.Protocol, s.LocalPort)] = true
+		}
+	}
+	return ports
+}
+
+func portSet(ports map[string]bool) map[string]string {
+	set := make(map[string]string)
+	for port := range ports {
+		set[port] = "listening"
+	}
+	return set
+}
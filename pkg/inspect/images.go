@@ -0,0 +1,147 @@
+package inspect
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/checkpoint-restore/go-criu/v7/crit"
+)
+
+// loadImageProcessTree reads the process tree with its files, sockets and
+// memory mappings from the CRIU images. Only pstree.img is required; the
+// other details are left out when their images cannot be read.
+func (a *Analyzer) loadImageProcessTree(imagesDir string) (*ProcessInfo, error) {
+	c := crit.New(nil, nil, imagesDir, false, true)
+
+	psTree, err := c.ExplorePs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process tree: %w", err)
+	}
+
+	files := make(map[uint32][]*crit.File)
+	if fds, err := c.ExploreFds(); err != nil {
+		a.logger.Warnf("Cannot read file descriptors from images: %v", err)
+	} else {
+		for _, fd := range fds {
+			files[fd.PId] = fd.Files
+		}
+	}
+
+	sockets := make(map[uint32][]*crit.Socket)
+	if sks, err := c.ExploreSk(); err != nil {
+		a.logger.Warnf("Cannot read sockets from images: %v", err)
+	} else {
+		for _, sk := range sks {
+			sockets[sk.PId] = sk.Sockets
+		}
+	}
+
+	mems := make(map[uint32][]*crit.Mem)
+	if memMaps, err := c.ExploreMems(); err != nil {
+		a.logger.Warnf("Cannot read memory mappings from images: %v", err)
+	} else {
+		for _, memMap := range memMaps {
+			mems[memMap.PId] = memMap.Mems
+		}
+	}
+
+	var convert func(node *crit.PsTree, ppid int) ProcessInfo
+	convert = func(node *crit.PsTree, ppid int) ProcessInfo {
+		process := ProcessInfo{
+			PID:         int(node.PID),
+			PPID:        ppid,
+			Command:     node.Comm,
+			Environment: make(map[string]string),
+			Children:    []ProcessInfo{},
+		}
+
+		for _, file := range files[node.PID] {
+			fd, err := strconv.Atoi(file.Fd)
+			if err != nil {
+				// cwd and root are listed alongside the descriptors
+				if file.Fd == "cwd" {
+					process.WorkingDir = file.Path
+				}
+				continue
+			}
+			fdType := strings.ToLower(file.Type)
+			process.FileDescriptors = append(process.FileDescriptors, FileDescriptor{
+				FD:       fd,
+				Type:     fdType,
+				Path:     file.Path,
+				IsPipe:   fdType == "pipe",
+				IsSocket: strings.HasSuffix(fdType, "sk"),
+			})
+		}
+
+		for _, socket := range sockets[node.PID] {
+			process.Sockets = append(process.Sockets, socketInfo(socket))
+		}
+
+		for _, mem := range mems[node.PID] {
+			process.MemoryMaps = append(process.MemoryMaps, memoryMap(mem))
+		}
+
+		for _, child := range node.Children {
+			process.Children = append(process.Children, convert(child, process.PID))
+		}
+		sort.Slice(process.Children, func(i, j int) bool {
+			return process.Children[i].PID < process.Children[j].PID
+		})
+
+		return process
+	}
+
+	root := convert(psTree, 0)
+	return &root, nil
+}
+
+func socketInfo(socket *crit.Socket) SocketInfo {
+	info := SocketInfo{
+		FD:         int(socket.Fd),
+		Type:       socket.Protocol,
+		Family:     "AF_" + socket.Family,
+		State:      socket.State,
+		LocalAddr:  socket.SrcAddr,
+		LocalPort:  int(socket.SrcPort),
+		RemoteAddr: socket.DestAddr,
+		RemotePort: int(socket.DestPort),
+		Protocol:   strings.ToLower(socket.Protocol),
+	}
+	switch socket.FdType {
+	case "UNIXSK":
+		info.Type = "UNIX"
+		info.Family = "AF_UNIX"
+	case "NETLINKSK":
+		info.Family = "AF_NETLINK"
+	case "PACKETSK":
+		info.Family = "AF_PACKET"
+	}
+	return info
+}
+
+func memoryMap(mem *crit.Mem) MemoryMap {
+	start, _ := strconv.ParseUint(mem.Start, 16, 64)
+	end, _ := strconv.ParseUint(mem.End, 16, 64)
+	return MemoryMap{
+		StartAddr:   "0x" + mem.Start,
+		EndAddr:     "0x" + mem.End,
+		Permissions: mem.Protection,
+		Path:        strings.TrimSpace(mem.Resource),
+		Size:        int64(end - start),
+	}
+}
+
+// countProcesses returns the number of processes and open descriptors in a
+// process tree.
+func countProcesses(process *ProcessInfo) (int, int) {
+	processes, files := 1, len(process.FileDescriptors)
+	for i := range process.Children {
+		p, f := countProcesses(&process.Children[i])
+		processes += p
+		files += f
+	}
+	return processes, files
+}
@@ -0,0 +1,87 @@
+package inspect
+
+import (
+	"bufio"
+	"docker-cr/pkg/check"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// liveProcesses returns the PIDs and command names of pid and its
+// descendants.
+func liveProcesses(pid int) ([]int, []string, error) {
+	pids, err := check.ProcessTree(pid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var commands []string
+	for _, p := range pids {
+		comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", p))
+		if err == nil {
+			commands = append(commands, strings.TrimSpace(string(comm)))
+		}
+	}
+	return pids, commands, nil
+}
+
+// liveListeningPorts returns the TCP ports listened on and the unconnected
+// UDP ports bound by the given processes, as "tcp/80" or "udp/53".
+func liveListeningPorts(pids []int) (map[string]bool, error) {
+	inodes := make(map[string]bool)
+	for _, pid := range pids {
+		dir := fmt.Sprintf("/proc/%d/fd", pid)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+			if err == nil && strings.HasPrefix(target, "socket:[") {
+				inodes[strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]")] = true
+			}
+		}
+	}
+
+	ports := make(map[string]bool)
+	if len(pids) == 0 {
+		return ports, nil
+	}
+
+	for _, table := range []struct {
+		file  string
+		proto string
+		state string
+	}{
+		{"tcp", "tcp", "0A"}, {"tcp6", "tcp", "0A"},
+		{"udp", "udp", "07"}, {"udp6", "udp", "07"},
+	} {
+		file, err := os.Open(fmt.Sprintf("/proc/%d/net/%s", pids[0], table.file))
+		if err != nil {
+			continue
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != table.state || !inodes[fields[9]] {
+				continue
+			}
+			// Unconnected UDP sockets have a zero remote port
+			if table.proto == "udp" && !strings.HasSuffix(fields[2], ":0000") {
+				continue
+			}
+			_, portHex, _ := strings.Cut(fields[1], ":")
+			if port, err := strconv.ParseUint(portHex, 16, 16); err == nil {
+				ports[fmt.Sprintf("%s/%d", table.proto, port)] = true
+			}
+		}
+		file.Close()
+	}
+
+	return ports, nil
+}
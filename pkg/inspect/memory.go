@@ -0,0 +1,301 @@
+package inspect
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/checkpoint-restore/go-criu/v7/crit"
+)
+
+// Flags of a pagemap entry, from CRIU's pagemap.h
+const (
+	pagemapInParent = 1 << 0
+	pagemapPresent  = 1 << 2
+)
+
+// Bytes of a match shown in search results
+const maxMatchContext = 64
+
+// Memory is searched in windows of searchWindowSize, each extended by
+// searchOverlap so that matches up to that length are found across window
+// boundaries
+const (
+	searchWindowSize = 1 << 20
+	searchOverlap    = 4096
+)
+
+type MemoryMatch struct {
+	PID     int    `json:"pid"`
+	Process string `json:"process"`
+	VMA     string `json:"vma"`
+	Path    string `json:"path,omitempty"`
+	Address string `json:"address"`
+	Offset  uint64 `json:"offset"`
+	Match   string `json:"match"`
+}
+
+// pageRun is a run of consecutive pages stored in pages-<id>.img.
+type pageRun struct {
+	vaddr  uint64
+	size   uint64
+	offset int64
+}
+
+// processMemory maps the virtual addresses of one dumped process to the page
+// data in its pages image.
+type processMemory struct {
+	pid      int
+	command  string
+	vmas     []MemoryMap
+	runs     []pageRun
+	pages    *os.File
+	inParent bool
+}
+
+func (a *Analyzer) openProcessMemory(imagesDir string, process *ProcessInfo) (*processMemory, error) {
+	reader, err := crit.NewMemoryReader(imagesDir, uint32(process.PID), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pagemap of PID %d: %w", process.PID, err)
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	mem := &processMemory{pid: process.PID, command: process.Command, vmas: process.MemoryMaps}
+
+	var offset int64
+	for _, entry := range reader.GetPagemapEntries() {
+		size := uint64(entry.GetNrPages()) * pageSize
+		flags := entry.GetFlags()
+		// Old images have no flags and mark parent pages with in_parent
+		stored := flags&pagemapPresent != 0 || (entry.Flags == nil && !entry.GetInParent())
+		if !stored {
+			if flags&pagemapInParent != 0 || entry.GetInParent() {
+				mem.inParent = true
+			}
+			continue
+		}
+		mem.runs = append(mem.runs, pageRun{vaddr: entry.GetVaddr(), size: size, offset: offset})
+		offset += int64(size)
+	}
+
+	pages, err := os.Open(filepath.Join(imagesDir, fmt.Sprintf("pages-%d.img", reader.GetPagesID())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pages of PID %d: %w", process.PID, err)
+	}
+	mem.pages = pages
+
+	if mem.inParent {
+		a.logger.Warnf("Some pages of PID %d are in the parent checkpoint and read as zeroes", process.PID)
+	}
+	return mem, nil
+}
+
+func (m *processMemory) Close() error {
+	return m.pages.Close()
+}
+
+func (m *processMemory) vmaAt(addr uint64) *MemoryMap {
+	for i := range m.vmas {
+		start, end := parseAddress(m.vmas[i].StartAddr), parseAddress(m.vmas[i].EndAddr)
+		if addr >= start && addr < end {
+			return &m.vmas[i]
+		}
+	}
+	return nil
+}
+
+// hasPages reports whether any page between start and end was dumped.
+func (m *processMemory) hasPages(start, end uint64) bool {
+	for _, run := range m.runs {
+		if run.vaddr < end && run.vaddr+run.size > start {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpedRanges returns the ranges between start and end whose pages were
+// dumped, in address order with adjacent runs merged.
+func (m *processMemory) dumpedRanges(start, end uint64) [][2]uint64 {
+	var ranges [][2]uint64
+	for _, run := range m.runs {
+		from, to := max(start, run.vaddr), min(end, run.vaddr+run.size)
+		if from >= to {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == from {
+			ranges[n-1][1] = to
+			continue
+		}
+		ranges = append(ranges, [2]uint64{from, to})
+	}
+	return ranges
+}
+
+// read returns the memory between start and end. Pages that were not dumped
+// read as zeroes.
+func (m *processMemory) read(start, end uint64) ([]byte, error) {
+	data := make([]byte, end-start)
+	for _, run := range m.runs {
+		from, to := max(start, run.vaddr), min(end, run.vaddr+run.size)
+		if from >= to {
+			continue
+		}
+		if _, err := m.pages.ReadAt(data[from-start:to-start], run.offset+int64(from-run.vaddr)); err != nil {
+			return nil, fmt.Errorf("failed to read pages of PID %d: %w", m.pid, err)
+		}
+	}
+	return data, nil
+}
+
+func parseAddress(addr string) uint64 {
+	value, _ := strconv.ParseUint(strings.TrimPrefix(addr, "0x"), 16, 64)
+	return value
+}
+
+// memoryProcesses returns the dumped processes to look at, all of them when
+// pid is 0.
+func (a *Analyzer) memoryProcesses(imagesDir string, pid int) ([]*ProcessInfo, error) {
+	tree, err := a.loadImageProcessTree(imagesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	procs := flattenProcesses(tree)
+	if pid != 0 {
+		process, ok := procs[pid]
+		if !ok {
+			return nil, fmt.Errorf("PID %d is not in the checkpoint", pid)
+		}
+		return []*ProcessInfo{process}, nil
+	}
+
+	var processes []*ProcessInfo
+	for _, p := range sortedPIDs(procs) {
+		processes = append(processes, procs[p])
+	}
+	return processes, nil
+}
+
+// SearchMemory looks for pattern in the dumped memory of pid, or of every
+// process when pid is 0, and returns at most maxMatches matches. Matches
+// spanning pages that were not dumped next to each other, or longer than
+// searchOverlap, may not be found.
+func (a *Analyzer) SearchMemory(checkpointDir string, pid int, pattern *regexp.Regexp, maxMatches int) ([]MemoryMatch, error) {
+	imagesDir := filepath.Join(checkpointDir, "images")
+	processes, err := a.memoryProcesses(imagesDir, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []MemoryMatch
+	for _, process := range processes {
+		mem, err := a.openProcessMemory(imagesDir, process)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, run := range mem.runs {
+			runEnd := run.vaddr + run.size
+			for start := run.vaddr; start < runEnd; start += searchWindowSize {
+				data, err := mem.read(start, min(start+searchWindowSize+searchOverlap, runEnd))
+				if err != nil {
+					mem.Close()
+					return nil, err
+				}
+
+				for _, loc := range pattern.FindAllIndex(data, -1) {
+					// Matches starting in the overlap belong to the next window
+					if loc[0] >= searchWindowSize {
+						break
+					}
+					if maxMatches > 0 && len(matches) >= maxMatches {
+						mem.Close()
+						a.logger.Warnf("Stopped after %d matches", maxMatches)
+						return matches, nil
+					}
+
+					addr := start + uint64(loc[0])
+					match := MemoryMatch{
+						PID:     mem.pid,
+						Process: mem.command,
+						Address: fmt.Sprintf("0x%x", addr),
+						Match:   quoteMatch(data[loc[0]:loc[1]]),
+					}
+					if vma := mem.vmaAt(addr); vma != nil {
+						match.VMA = vma.StartAddr + "-" + vma.EndAddr
+						match.Path = vma.Path
+						match.Offset = addr - parseAddress(vma.StartAddr)
+					}
+					matches = append(matches, match)
+				}
+			}
+		}
+		mem.Close()
+	}
+
+	return matches, nil
+}
+
+func quoteMatch(data []byte) string {
+	if len(data) > maxMatchContext {
+		return strconv.Quote(string(data[:maxMatchContext])) + "..."
+	}
+	return strconv.Quote(string(data))
+}
+
+// DumpVMA writes the memory of the VMA of pid containing addr to outputPath,
+// like checkpointctl memparse. pid 0 is the container's init process.
+func (a *Analyzer) DumpVMA(checkpointDir string, pid int, addr uint64, outputPath string) (*MemoryMap, error) {
+	imagesDir := filepath.Join(checkpointDir, "images")
+	var process *ProcessInfo
+	if pid == 0 {
+		// Init is the root of pstree.img, which need not have the lowest PID
+		tree, err := a.loadImageProcessTree(imagesDir)
+		if err != nil {
+			return nil, err
+		}
+		process = tree
+	} else {
+		processes, err := a.memoryProcesses(imagesDir, pid)
+		if err != nil {
+			return nil, err
+		}
+		process = processes[0]
+	}
+	pid = process.PID
+
+	mem, err := a.openProcessMemory(imagesDir, process)
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	vma := mem.vmaAt(addr)
+	if vma == nil {
+		return nil, fmt.Errorf("no VMA of PID %d contains 0x%x", pid, addr)
+	}
+
+	data, err := mem.read(parseAddress(vma.StartAddr), parseAddress(vma.EndAddr))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(outputPath, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", outputPath, err)
+	}
+
+	a.logger.Infof("Wrote %d bytes of VMA %s-%s of PID %d to %s", len(data), vma.StartAddr, vma.EndAddr, pid, outputPath)
+	return vma, nil
+}
+
+// ParseMemoryAddress parses a hexadecimal address, with or without 0x.
+func ParseMemoryAddress(addr string) (uint64, error) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(addr), "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,91 @@
+package inspect
+
+import (
+	"docker-cr/pkg/secrets"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Memory matches reported per rule, to keep e.g. a key repeated in many heap
+// buffers from flooding the report
+const maxMemorySecrets = 20
+
+// ScanSecrets looks for likely credentials in the saved environment and the
+// metadata files of a checkpoint, and with scanMemory in the dumped memory.
+func (a *Analyzer) ScanSecrets(checkpointDir string, scanMemory bool) ([]secrets.Finding, error) {
+	analysis, err := a.AnalyzeCheckpoint(checkpointDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze checkpoint: %w", err)
+	}
+
+	var findings []secrets.Finding
+	seen := make(map[string]bool)
+	add := func(found []secrets.Finding) {
+		for _, f := range found {
+			key := f.Rule + "\x00" + f.Fingerprint
+			// The environment is repeated in both metadata files
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			findings = append(findings, f)
+		}
+	}
+
+	if analysis.Metadata != nil && analysis.Metadata.ContainerState != nil {
+		add(secrets.ScanEnvironment(analysis.Metadata.ContainerState.Environment))
+	}
+
+	for _, name := range []string{"checkpoint_metadata.json", "container_metadata.json", "mount_mappings.json"} {
+		data, err := os.ReadFile(filepath.Join(checkpointDir, name))
+		if err != nil {
+			continue
+		}
+		add(secrets.ScanText("metadata", name, data))
+	}
+
+	if !scanMemory {
+		return findings, nil
+	}
+	if !analysis.FromImages {
+		return nil, fmt.Errorf("cannot read the process images of %s", checkpointDir)
+	}
+
+	for _, rule := range secrets.Rules {
+		matches, err := a.SearchMemory(checkpointDir, 0, rule.Pattern, maxMemorySecrets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan memory: %w", err)
+		}
+		for _, m := range matches {
+			match, err := strconv.Unquote(strings.TrimSuffix(m.Match, "..."))
+			if err != nil {
+				match = m.Match
+			}
+			add([]secrets.Finding{secrets.NewFinding("memory", fmt.Sprintf("PID %d (%s) %s", m.PID, m.Process, m.Address), rule.Name, match)})
+		}
+	}
+
+	return findings, nil
+}
+
+// redactAnalysis masks secret environment values everywhere an analysis
+// carries them.
+func redactAnalysis(analysis *CheckpointAnalysis) {
+	if analysis.Metadata != nil {
+		secrets.RedactContainerState(analysis.Metadata.ContainerState)
+	}
+
+	var walk func(p *ProcessInfo)
+	walk = func(p *ProcessInfo) {
+		p.Environment = secrets.RedactEnvironment(p.Environment)
+		for i := range p.Children {
+			walk(&p.Children[i])
+		}
+	}
+	if analysis.ProcessTree != nil {
+		walk(analysis.ProcessTree)
+	}
+}
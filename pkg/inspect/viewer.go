@@ -2,6 +2,8 @@ package inspect
 
 import (
 	"docker-cr/pkg/docker"
+	"docker-cr/pkg/secrets"
+	"docker-cr/pkg/utils"
 	"encoding/json"
 	"fmt"
 	"sort"
@@ -24,6 +26,8 @@ type ViewOptions struct {
 	ShowAll         bool
 	OutputFormat    string // "text", "json", "tree"
 	Verbose         bool
+	// Redact masks environment values that look like credentials
+	Redact          bool
 }
 
 func NewViewer(logger *logrus.Logger) *Viewer {
@@ -38,6 +42,9 @@ func (v *Viewer) ShowCheckpoint(checkpointDir string, options ViewOptions) (stri
 	if err != nil {
 		return "", fmt.Errorf("failed to analyze checkpoint: %w", err)
 	}
+	if options.Redact {
+		redactAnalysis(analysis)
+	}
 
 	switch options.OutputFormat {
 	case "json":
@@ -163,9 +170,19 @@ func (v *Viewer) formatText(analysis *CheckpointAnalysis, options ViewOptions) (
 		output.WriteString(fmt.Sprintf("Open Files: %d\n", usage.OpenFiles))
 
 		if len(usage.Cgroups) > 0 {
-			output.WriteString("Cgroups:\n")
-			for controller, path := range usage.Cgroups {
-				output.WriteString(fmt.Sprintf("  %s: %s\n", controller, path))
+			if usage.CgroupMode != "" {
+				output.WriteString(fmt.Sprintf("Cgroups (%s):\n", usage.CgroupMode))
+			} else {
+				output.WriteString("Cgroups:\n")
+			}
+			for _, controller := range sortedKeys(usage.Cgroups) {
+				output.WriteString(fmt.Sprintf("  %s: %s\n", controller, usage.Cgroups[controller]))
+			}
+		}
+		if len(usage.CgroupLimits) > 0 {
+			output.WriteString("Cgroup Limits:\n")
+			for _, file := range sortedKeys(usage.CgroupLimits) {
+				output.WriteString(fmt.Sprintf("  %s: %s\n", file, usage.CgroupLimits[file]))
 			}
 		}
 		output.WriteString("\n")
@@ -378,4 +395,226 @@ func (v *Viewer) GetSummary(checkpointDir string) (string, error) {
 	}
 
 	return output.String(), nil
-}
\ No newline at end of file
+}
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ShowDiff renders the changes between two checkpoints as text or json.
+func (v *Viewer) ShowDiff(fromDir, toDir, format string) (string, error) {
+	diff, err := v.analyzer.DiffCheckpoints(fromDir, toDir)
+	if err != nil {
+		return "", err
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal diff to JSON: %w", err)
+		}
+		return string(data) + "\n", nil
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("--- %s\n+++ %s\n\n", diff.From, diff.To))
+
+	section := func(title string, n int) bool {
+		if n == 0 {
+			return false
+		}
+		output.WriteString(fmt.Sprintf("=== %s ===\n", title))
+		return true
+	}
+
+	if section("Processes", len(diff.Processes)) {
+		for _, c := range diff.Processes {
+			output.WriteString(fmt.Sprintf("%s %d %s %s\n", changeMark(c.Change), c.PID, c.Command, c.Detail))
+		}
+		output.WriteString("\n")
+	}
+
+	if section("File Descriptors", len(diff.Files)) {
+		for _, c := range diff.Files {
+			line := fmt.Sprintf("%s pid %d fd %d: %s", changeMark(c.Change), c.PID, c.FD, c.Path)
+			if c.OldPath != "" {
+				line += " (was " + c.OldPath + ")"
+			}
+			output.WriteString(line + "\n")
+		}
+		output.WriteString("\n")
+	}
+
+	if section("Sockets", len(diff.Sockets)) {
+		for _, c := range diff.Sockets {
+			line := fmt.Sprintf("%s pid %d fd %d: %s", changeMark(c.Change), c.PID, c.FD, c.Socket)
+			switch {
+			case c.Change == ChangeChanged && c.OldState != c.State:
+				line += fmt.Sprintf(" [%s -> %s]", c.OldState, c.State)
+			case c.State != "":
+				line += " [" + c.State + "]"
+			case c.OldState != "":
+				line += " [" + c.OldState + "]"
+			}
+			output.WriteString(line + "\n")
+		}
+		output.WriteString("\n")
+	}
+
+	if section("Memory", len(diff.Memory)) {
+		for _, c := range diff.Memory {
+			output.WriteString(fmt.Sprintf("%s pid %d %s %-30s %s\n", changeMark(c.Change), c.PID, c.Start, c.Path, formatDelta(c.Delta)))
+		}
+		output.WriteString(fmt.Sprintf("Total: %s\n\n", formatDelta(diff.MemoryDelta)))
+	}
+
+	if section("Environment", len(diff.Environment)) {
+		for _, c := range diff.Environment {
+			output.WriteString(formatValueChange(c))
+		}
+		output.WriteString("\n")
+	}
+
+	if section("Mount Mappings", len(diff.Mounts)) {
+		for _, c := range diff.Mounts {
+			output.WriteString(formatValueChange(c))
+		}
+		output.WriteString("\n")
+	}
+
+	if section("Image Files", len(diff.Images)) {
+		for _, c := range diff.Images {
+			output.WriteString(fmt.Sprintf("%s %-30s %s\n", changeMark(c.Change), c.File, formatDelta(c.Delta)))
+		}
+		output.WriteString(fmt.Sprintf("Total: %s\n", formatDelta(diff.ImagesDelta)))
+	}
+
+	return output.String(), nil
+}
+
+func changeMark(change string) string {
+	switch change {
+	case ChangeAdded:
+		return "+"
+	case ChangeRemoved:
+		return "-"
+	}
+	return "~"
+}
+
+func formatDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + utils.FormatSize(-delta)
+	}
+	return "+" + utils.FormatSize(delta)
+}
+
+func formatValueChange(c ValueChange) string {
+	switch c.Change {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s=%s\n", c.Key, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s=%s\n", c.Key, c.Old)
+	}
+	return fmt.Sprintf("~ %s=%s (was %s)\n", c.Key, c.New, c.Old)
+}
+
+func (v *Viewer) FormatDrift(report *DriftReport, format string) (string, error) {
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal drift report to JSON: %w", err)
+		}
+		return string(data) + "\n", nil
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("--- %s (checkpoint)\n+++ %s (running)\n\n", report.Checkpoint, report.Container))
+
+	values := func(title string, changes []ValueChange) {
+		if len(changes) == 0 {
+			return
+		}
+		output.WriteString(fmt.Sprintf("=== %s ===\n", title))
+		for _, c := range changes {
+			output.WriteString(formatValueChange(c))
+		}
+		output.WriteString("\n")
+	}
+
+	values("Configuration", report.Config)
+	values("Environment", report.Environment)
+	values("Mount Mappings", report.Mounts)
+
+	if len(report.Processes) > 0 {
+		output.WriteString("=== Processes ===\n")
+		for _, c := range report.Processes {
+			output.WriteString(fmt.Sprintf("%s %s (%s)\n", changeMark(c.Change), c.Command, c.Detail))
+		}
+		output.WriteString("\n")
+	}
+
+	if len(report.Ports) > 0 {
+		output.WriteString("=== Listening Ports ===\n")
+		for _, c := range report.Ports {
+			output.WriteString(fmt.Sprintf("%s %s\n", changeMark(c.Change), c.Key))
+		}
+		output.WriteString("\n")
+	}
+
+	for _, skipped := range report.Skipped {
+		output.WriteString(fmt.Sprintf("Not compared: %s\n", skipped))
+	}
+
+	if report.HasDrift() {
+		output.WriteString("Drift detected\n")
+	} else {
+		output.WriteString("No drift\n")
+	}
+	return output.String(), nil
+}
+
+func (v *Viewer) FormatMemoryMatches(matches []MemoryMatch, format string) (string, error) {
+	if format == "json" {
+		data, err := json.MarshalIndent(matches, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal matches to JSON: %w", err)
+		}
+		return string(data) + "\n", nil
+	}
+
+	var output strings.Builder
+	for _, m := range matches {
+		output.WriteString(fmt.Sprintf("PID %d (%s) %s", m.PID, m.Process, m.Address))
+		if m.VMA != "" {
+			output.WriteString(fmt.Sprintf(" in %s+0x%x", m.VMA, m.Offset))
+			if m.Path != "" {
+				output.WriteString(" " + m.Path)
+			}
+		}
+		output.WriteString(": " + m.Match + "\n")
+	}
+	output.WriteString(fmt.Sprintf("%d matches\n", len(matches)))
+	return output.String(), nil
+}
+
+func (v *Viewer) FormatSecrets(findings []secrets.Finding, format string) (string, error) {
+	if format == "json" {
+		data, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal findings to JSON: %w", err)
+		}
+		return string(data) + "\n", nil
+	}
+
+	var output strings.Builder
+	for _, f := range findings {
+		output.WriteString(fmt.Sprintf("%-8s %-40s %-16s %s\n", f.Source, f.Location, f.Rule, f.Match))
+	}
+	output.WriteString(fmt.Sprintf("%d likely secrets found\n", len(findings)))
+	return output.String(), nil
+}
@@ -0,0 +1,269 @@
+package restore
+
+import (
+	"context"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type CloneConfig struct {
+	CheckpointDir string `json:"checkpoint_dir"`
+	Replicas      int    `json:"replicas"`
+	NamePrefix    string `json:"name_prefix"`
+	// WorkDir holds one directory per replica with its restore files and
+	// copies of writable mounts; it must outlive the replicas
+	WorkDir string `json:"work_dir"`
+	// Restore is the template for every replica's restore
+	Restore RestoreConfig `json:"restore"`
+}
+
+type ReplicaResult struct {
+	Name    string `json:"name"`
+	WorkDir string `json:"work_dir"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Clone restores one checkpoint as several independent containers at once.
+// Each replica gets its own name, hostname and address, its own copy of the
+// writable mounts, and its own directory for restore logs and mount maps.
+func (m *Manager) Clone(ctx context.Context, config CloneConfig) ([]ReplicaResult, error) {
+	if config.Replicas < 1 {
+		return nil, fmt.Errorf("replicas must be at least 1")
+	}
+	if config.Restore.IPAddress != "" && config.Replicas > 1 {
+		return nil, fmt.Errorf("cannot give %d replicas the same IP address", config.Replicas)
+	}
+	for _, spec := range config.Restore.Publish {
+		if strings.Contains(spec, ":") && config.Replicas > 1 {
+			return nil, fmt.Errorf("cannot bind host port %s for %d replicas", spec, config.Replicas)
+		}
+	}
+
+	// Replicas share one copy of an encrypted or stored checkpoint
+	dir, cleanup, err := m.checkpointManager.OpenCheckpoint(config.CheckpointDir, config.Restore.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	config.CheckpointDir = dir
+
+	if err := m.checkpointManager.ValidateCheckpoint(config.CheckpointDir); err != nil {
+		return nil, fmt.Errorf("checkpoint validation failed: %w", err)
+	}
+	if len(config.Restore.TrustedKeys) > 0 {
+		if err := m.checkpointManager.VerifySignature(config.CheckpointDir, config.Restore.TrustedKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata, err := m.checkpointManager.GetCheckpointInfo(config.CheckpointDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint metadata: %w", err)
+	}
+
+	// Naming the source's networks makes every replica join the network
+	// namespace Docker sets up for it, with an address of its own
+	if len(config.Restore.Networks) == 0 {
+		config.Restore.Networks = docker.OriginalNetworks(metadata.ContainerState)
+	}
+
+	if config.NamePrefix == "" {
+		config.NamePrefix = metadata.ContainerState.Name + "-clone-"
+	}
+
+	m.logger.Infof("Cloning %s into %d replicas", config.CheckpointDir, config.Replicas)
+
+	results := make([]ReplicaResult, config.Replicas)
+	var wg sync.WaitGroup
+	for i := range results {
+		var restoreConfig RestoreConfig
+		results[i], restoreConfig = ReplicaConfig(config, i)
+
+		wg.Add(1)
+		go func(result *ReplicaResult) {
+			defer wg.Done()
+			if err := m.restoreReplica(ctx, config, restoreConfig, metadata.MountMappings, result); err != nil {
+				m.logger.Errorf("Replica %s failed: %v", result.Name, err)
+				result.Error = err.Error()
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d replicas failed", failed, config.Replicas)
+	}
+
+	return results, nil
+}
+
+// ReplicaConfig returns the name and work directory of the replica with the
+// given index and the restore config it starts from. The checkpoint
+// directory and mount remaps are set once its files are in place.
+func ReplicaConfig(config CloneConfig, index int) (ReplicaResult, RestoreConfig) {
+	name := fmt.Sprintf("%s%d", config.NamePrefix, index+1)
+	result := ReplicaResult{Name: name, WorkDir: filepath.Join(config.WorkDir, name)}
+
+	restoreConfig := config.Restore
+	restoreConfig.NewContainerName = name
+	restoreConfig.Hostname = name
+	// Connections of the source must not be answered by every replica
+	restoreConfig.TcpClose = true
+	// Every replica would claim the host ports of the source
+	restoreConfig.KeepPorts = false
+
+	return result, restoreConfig
+}
+
+func (m *Manager) restoreReplica(ctx context.Context, config CloneConfig, restoreConfig RestoreConfig, mappings []docker.MountMapping, result *ReplicaResult) (err error) {
+	if utils.DirExists(result.WorkDir) {
+		return fmt.Errorf("replica directory already exists: %s", result.WorkDir)
+	}
+
+	defer func() {
+		if err != nil {
+			utils.RemoveDir(result.WorkDir)
+		}
+	}()
+
+	replicaDir, err := PrepareReplicaDir(config.CheckpointDir, result.WorkDir)
+	if err != nil {
+		return err
+	}
+
+	restoreConfig.CheckpointDir = replicaDir
+	restoreConfig.RemapMounts, err = m.copyWritableMounts(mappings, config.Restore.RemapMounts, filepath.Join(result.WorkDir, "mounts"))
+	if err != nil {
+		return err
+	}
+
+	return m.Restore(ctx, restoreConfig)
+}
+
+// PrepareReplicaDir lays out a checkpoint directory for one replica: its own
+// copies of the metadata files, manifest and logs, and an images directory of
+// hard links to the shared, read-only images. The parent link of an
+// incremental dump is rewritten to the original parent, since CRIU and the
+// parent lookup both resolve it against the replica's images directory.
+func PrepareReplicaDir(checkpointDir, workDir string) (string, error) {
+	replicaDir := filepath.Join(workDir, "checkpoint")
+	if err := utils.EnsureDir(replicaDir); err != nil {
+		return "", err
+	}
+
+	// The manifest lists every top-level file, so all of them are copied
+	entries, err := os.ReadDir(checkpointDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read checkpoint directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := utils.ReadFile(filepath.Join(checkpointDir, entry.Name()))
+		if err != nil {
+			return "", err
+		}
+		if err := utils.WriteFile(filepath.Join(replicaDir, entry.Name()), data); err != nil {
+			return "", err
+		}
+	}
+
+	imagesDir, err := filepath.Abs(filepath.Join(checkpointDir, "images"))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve images directory: %w", err)
+	}
+	if err := linkImages(imagesDir, filepath.Join(replicaDir, "images")); err != nil {
+		return "", err
+	}
+
+	return replicaDir, nil
+}
+
+// linkImages hard-links the images in imagesDir into target, copying them
+// where target is on another file system. Relative symlinks are made
+// absolute so they keep pointing where they did.
+func linkImages(imagesDir, target string) error {
+	if err := utils.EnsureDir(target); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(imagesDir)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint images: %w", err)
+	}
+	for _, entry := range entries {
+		source := filepath.Join(imagesDir, entry.Name())
+		dest := filepath.Join(target, entry.Name())
+
+		switch {
+		case entry.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(source)
+			if err != nil {
+				return fmt.Errorf("failed to read link %s: %w", source, err)
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(imagesDir, link)
+			}
+			if err := os.Symlink(link, dest); err != nil {
+				return fmt.Errorf("failed to link %s: %w", dest, err)
+			}
+		case entry.Type().IsRegular():
+			if err := os.Link(source, dest); err == nil {
+				continue
+			}
+			fallthrough
+		default:
+			if err := utils.CopyTree(source, dest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyWritableMounts gives a replica copy-on-write copies of the writable
+// bind mounts and volumes, and returns the remaps pointing at them. Mounts
+// already remapped by the caller are copied from their new source.
+func (m *Manager) copyWritableMounts(mappings []docker.MountMapping, remaps map[string]string, mountsDir string) (map[string]string, error) {
+	replicaRemaps := make(map[string]string)
+	for containerPath, hostPath := range remaps {
+		replicaRemaps[containerPath] = hostPath
+	}
+
+	for _, mapping := range mappings {
+		if mapping.ReadOnly || (mapping.Type != "bind" && mapping.Type != "volume") {
+			continue
+		}
+
+		source := mapping.HostPath
+		if remapped, ok := remaps[mapping.ContainerPath]; ok {
+			source = remapped
+		}
+		if !utils.FileExists(source) {
+			continue
+		}
+
+		target := filepath.Join(mountsDir, strings.Trim(strings.ReplaceAll(mapping.ContainerPath, "/", "_"), "_"))
+		if err := utils.CopyTree(source, target); err != nil {
+			return nil, fmt.Errorf("failed to copy writable mount %s: %w", mapping.ContainerPath, err)
+		}
+
+		m.logger.Debugf("Copied writable mount %s to %s", mapping.ContainerPath, target)
+		replicaRemaps[mapping.ContainerPath] = target
+	}
+
+	return replicaRemaps, nil
+}
@@ -0,0 +1,57 @@
+package restore
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"fmt"
+)
+
+// RestoreGroup restores every member of a group checkpoint in dependency
+// order, using config as the template for each member. Restored names are
+// those GetRestoreOptions picks. If a member fails, the members restored so
+// far are removed again.
+func (m *Manager) RestoreGroup(ctx context.Context, manifestPath string, config RestoreConfig) ([]string, error) {
+	manifest, err := checkpoint.LoadGroupManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := manifest.RestoreOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Infof("Restoring group %s (%d containers)", manifest.Group, len(members))
+
+	var restored []string
+	for _, member := range members {
+		defaults, err := m.GetRestoreOptions(member.CheckpointDir)
+		if err != nil {
+			m.removeRestored(restored)
+			return nil, fmt.Errorf("cannot restore group member %s: %w", member.Container, err)
+		}
+
+		memberConfig := config
+		memberConfig.CheckpointDir = member.CheckpointDir
+		memberConfig.NewContainerName = defaults.NewContainerName
+
+		m.logger.Infof("Restoring group member %s as %s", member.Container, memberConfig.NewContainerName)
+		if err := m.Restore(ctx, memberConfig); err != nil {
+			m.removeRestored(restored)
+			return nil, fmt.Errorf("restore of group member %s failed: %w", member.Container, err)
+		}
+		restored = append(restored, memberConfig.NewContainerName)
+	}
+
+	return restored, nil
+}
+
+func (m *Manager) removeRestored(names []string) {
+	for i := len(names) - 1; i >= 0; i-- {
+		if err := m.dockerManager.RemoveContainer(context.Background(), names[i]); err != nil {
+			m.logger.Errorf("Failed to remove restored group member %s: %v", names[i], err)
+		} else {
+			m.logger.Infof("Removed restored group member %s", names[i])
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package restore
 
 import (
+	"context"
 	"docker-cr/pkg/checkpoint"
 	"docker-cr/pkg/docker"
 	"docker-cr/pkg/utils"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -16,19 +18,36 @@ type Manager struct {
 	criuManager       *checkpoint.CRIUManager
 	checkpointManager *checkpoint.Manager
 	logger            *logrus.Logger
+
+	// criuMu serializes CRIU restores so concurrent restores (e.g. clones)
+	// never compete for the same PIDs
+	criuMu sync.Mutex
 }
 
 type RestoreConfig struct {
-	CheckpointDir   string `json:"checkpoint_dir"`
-	NewContainerName string `json:"new_container_name"`
-	LogLevel        int    `json:"log_level"`
-	ManageCgroups   bool   `json:"manage_cgroups"`
-	TcpEstablished  bool   `json:"tcp_established"`
-	RestoreSibling  bool   `json:"restore_sibling"`
-	Shell           bool   `json:"shell"`
-	ValidateEnv     bool   `json:"validate_env"`
-	AutoFixMounts   bool   `json:"auto_fix_mounts"`
-	SkipMounts      []string `json:"skip_mounts"`
+	CheckpointDir    string   `json:"checkpoint_dir"`
+	NewContainerName string   `json:"new_container_name"`
+	LogLevel         int      `json:"log_level"`
+	ManageCgroups    bool     `json:"manage_cgroups"`
+	TcpEstablished   bool     `json:"tcp_established"`
+	RestoreSibling   bool     `json:"restore_sibling"`
+	Shell            bool     `json:"shell"`
+	ValidateEnv      bool     `json:"validate_env"`
+	AutoFixMounts    bool     `json:"auto_fix_mounts"`
+	SkipMounts       []string `json:"skip_mounts"`
+	Networks         []string `json:"networks,omitempty"`
+	IPAddress        string   `json:"ip_address,omitempty"`
+	Publish          []string `json:"publish,omitempty"`
+	KeepPorts        bool     `json:"keep_ports,omitempty"` // Bind the original host ports instead of random ones
+	Hostname         string   `json:"hostname,omitempty"`
+	TcpClose         bool     `json:"tcp_close,omitempty"`
+
+	// Remaps, hook scripts and key files are host paths used as root, so
+	// they are never taken from JSON such as API requests
+	RemapMounts map[string]string         `json:"-"`
+	Hooks       *checkpoint.Hooks         `json:"-"`
+	Encryption  *checkpoint.EncryptionKey `json:"-"`
+	TrustedKeys []string                  `json:"-"` // Require a manifest signed by one of these public keys
 }
 
 func NewManager(dockerManager *docker.Manager, checkpointManager *checkpoint.Manager, logger *logrus.Logger) *Manager {
@@ -40,13 +59,41 @@ func NewManager(dockerManager *docker.Manager, checkpointManager *checkpoint.Man
 	}
 }
 
-func (m *Manager) Restore(config RestoreConfig) error {
+// Restore restores a container from a checkpoint. If it fails after changing
+// the host, every change is undone and a *RestoreError describes the rollback.
+func (m *Manager) Restore(ctx context.Context, config RestoreConfig) (err error) {
 	m.logger.Infof("Starting restore from checkpoint: %s", config.CheckpointDir)
 
-	// 1. Validate checkpoint exists and is complete
+	// Encrypted and stored checkpoints are restored from a private copy,
+	// but the restore log must outlive it
+	logFile, err := filepath.Abs(filepath.Join(config.CheckpointDir, "restore.log"))
+	if err != nil {
+		return fmt.Errorf("failed to resolve checkpoint directory: %w", err)
+	}
+	dir, cleanup, err := m.checkpointManager.OpenCheckpoint(config.CheckpointDir, config.Encryption)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	config.CheckpointDir = dir
+
+	// Registered after cleanup so the rollback still finds the private copy
+	tx := NewTransaction(m.logger)
+	defer func() {
+		if err != nil {
+			err = tx.Rollback(err)
+		}
+	}()
+
+	// 1. Validate checkpoint exists, is complete and unmodified
 	if err := m.checkpointManager.ValidateCheckpoint(config.CheckpointDir); err != nil {
 		return fmt.Errorf("checkpoint validation failed: %w", err)
 	}
+	if len(config.TrustedKeys) > 0 {
+		if err := m.checkpointManager.VerifySignature(config.CheckpointDir, config.TrustedKeys); err != nil {
+			return err
+		}
+	}
 
 	// 2. Load checkpoint metadata
 	metadata, err := m.checkpointManager.GetCheckpointInfo(config.CheckpointDir)
@@ -64,6 +111,13 @@ func (m *Manager) Restore(config RestoreConfig) error {
 		return fmt.Errorf("failed to load mount mappings: %w", err)
 	}
 
+	// Everything below (validation, external mounts, ext_mount_map) works
+	// on the remapped host paths
+	mountMappings, err = m.remapMounts(mountMappings, config.RemapMounts)
+	if err != nil {
+		return err
+	}
+
 	// 4. Validate restore environment
 	if config.ValidateEnv {
 		if err := m.validateRestoreEnvironment(originalState, mountMappings); err != nil {
@@ -71,36 +125,71 @@ func (m *Manager) Restore(config RestoreConfig) error {
 		}
 	}
 
-	// 5. Create target container for restore
-	containerID, err := m.dockerManager.CreateRestoreContainer(originalState, config.NewContainerName)
+	// A new network or hostname only reaches the restored processes if they
+	// join the restore container's namespaces
+	joins, err := m.joinNamespaces(config, metadata)
+	if err != nil {
+		return err
+	}
+
+	// 5. Recreate missing networks and create target container for restore
+	if err := m.ensureNetworks(ctx, tx, metadata.Networks, config.Networks); err != nil {
+		return err
+	}
+
+	containerID, err := m.dockerManager.CreateRestoreContainer(ctx, originalState, docker.RestoreContainerOptions{
+		Name:          config.NewContainerName,
+		Hostname:      config.Hostname,
+		Networks:      config.Networks,
+		IPAddress:     config.IPAddress,
+		Publish:       config.Publish,
+		KeepHostPorts: config.KeepPorts,
+		Hold:          len(joins) > 0,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create restore container: %w", err)
 	}
 
 	m.logger.Infof("Created restore container: %s", containerID[:12])
+	tx.Record("removed container "+containerID[:12], func(ctx context.Context) error {
+		return m.dockerManager.RemoveContainer(ctx, containerID)
+	})
 
 	// 6. Prepare mount namespace (critical for fixing mount errors)
-	if err := m.prepareMountNamespace(containerID, mountMappings, config.AutoFixMounts); err != nil {
+	if err := m.prepareMountNamespace(tx, containerID, mountMappings, config.AutoFixMounts); err != nil {
 		return fmt.Errorf("failed to prepare mount namespace: %w", err)
 	}
 
 	// 7. Start the container to get a PID
-	if err := m.dockerManager.StartContainer(containerID); err != nil {
+	if err := m.dockerManager.StartContainer(ctx, containerID); err != nil {
+		if config.KeepPorts && docker.IsPortConflict(err) {
+			return fmt.Errorf("host ports of %s are still in use, probably by the original container; restore without --keep-ports to get new host ports: %w", originalState.Name, err)
+		}
 		return fmt.Errorf("failed to start restore container: %w", err)
 	}
 
 	// 8. Get container PID for restore target
-	newPID, err := m.dockerManager.GetContainerPID(containerID)
+	newPID, err := m.dockerManager.GetContainerPID(ctx, containerID)
 	if err != nil {
 		return fmt.Errorf("failed to get container PID: %w", err)
 	}
 
 	m.logger.Infof("Restore target PID: %d", newPID)
 
-	// 9. Stop the container (CRIU will restore it)
-	timeout := 5
-	if err := m.dockerManager.StopContainer(containerID, &timeout); err != nil {
-		m.logger.Warnf("Failed to gracefully stop container, continuing: %v", err)
+	// Established connections are bound to the old addresses
+	tcpClose := config.TcpEstablished && config.TcpClose
+	if config.TcpEstablished && !tcpClose && m.addressChanged(ctx, originalState, containerID) {
+		m.logger.Warn("Container address changed, established TCP connections will be closed on restore")
+		tcpClose = true
+	}
+
+	// 9. Stop the container (CRIU will restore it), unless its namespaces
+	// are to be joined: stopping it would tear down its network
+	if len(joins) == 0 {
+		timeout := 5
+		if err := m.dockerManager.StopContainer(ctx, containerID, &timeout); err != nil {
+			m.logger.Warnf("Failed to gracefully stop container, continuing: %v", err)
+		}
 	}
 
 	// 10. Configure CRIU restore options
@@ -108,32 +197,72 @@ func (m *Manager) Restore(config RestoreConfig) error {
 	extMountMapFile := filepath.Join(config.CheckpointDir, "ext_mount_map")
 
 	// Create external mount map file
-	if err := m.criuManager.CreateExtMountMapFile(mountMappings, extMountMapFile); err != nil {
+	err = tx.WriteFile(extMountMapFile, func() error {
+		return m.criuManager.CreateExtMountMapFile(mountMappings, extMountMapFile)
+	})
+	if err != nil {
 		return fmt.Errorf("failed to create external mount map: %w", err)
 	}
 
 	criuOpts := checkpoint.RestoreOptions{
 		WorkDir:        config.CheckpointDir,
 		ImagesDir:      imagesDir,
-		LogFile:        filepath.Join(config.CheckpointDir, "restore.log"),
+		LogFile:        logFile,
 		LogLevel:       config.LogLevel,
 		External:       m.buildExternalMountArgs(mountMappings, config.SkipMounts),
 		ExtMountMap:    m.criuManager.BuildExtMountMapArgs(mountMappings),
 		SkipMnt:        config.SkipMounts,
 		ManageCgroups:  config.ManageCgroups,
 		TcpEstablished: config.TcpEstablished,
+		TcpClose:       tcpClose,
 		RestoreSibling: config.RestoreSibling,
 		Shell:          config.Shell,
 		EmptyNs:        0x40, // CLONE_NEWNS - handle mount namespace issues
 	}
 
+	criuOpts.Hooks = config.Hooks.WithEnv(map[string]string{
+		"DOCKER_CR_CONTAINER":      config.NewContainerName,
+		"DOCKER_CR_CHECKPOINT_DIR": config.CheckpointDir,
+	})
+
+	if config.ManageCgroups {
+		criuOpts.CgroupRoot, criuOpts.ManageCgroupsMode = m.restoreCgroups(originalState, containerID)
+	}
+
+	// Rejoin the namespaces the checkpoint shares with the host or other
+	// containers
+	for _, ns := range metadata.ExternalNamespaces {
+		path, err := m.dockerManager.NamespacePath(ctx, ns)
+		if err != nil {
+			return fmt.Errorf("cannot rejoin shared %s namespace: %w", ns.Type, err)
+		}
+		m.logger.Infof("Rejoining %s namespace of %s (%s)", ns.Type, ns.Owner, path)
+		criuOpts.InheritNamespaces = append(criuOpts.InheritNamespaces, checkpoint.InheritNamespace{Key: ns.Key, Path: path})
+	}
+
+	for _, nsType := range joins {
+		path := fmt.Sprintf("/proc/%d/ns/%s", newPID, nsType)
+		m.logger.Infof("Restoring into the %s namespace of the restore container (%s)", nsType, path)
+		criuOpts.JoinNamespaces = append(criuOpts.JoinNamespaces, checkpoint.JoinNamespace{Type: nsType, Path: path})
+	}
+
 	// 11. Perform CRIU restore
-	if err := m.criuManager.RestoreProcess(criuOpts); err != nil {
-		return fmt.Errorf("CRIU restore failed: %w", err)
+	m.criuMu.Lock()
+	err = m.criuManager.RestoreProcess(ctx, criuOpts)
+	m.criuMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("restore of %s failed: %w", config.NewContainerName, err)
+	}
+
+	if criuOpts.CgroupRoot != "" && len(originalState.Cgroup.Limits) > 0 {
+		m.logger.Infof("Reapplying %d cgroup limits of %s", len(originalState.Cgroup.Limits), originalState.Name)
+		if err := docker.ApplyCgroupLimits(originalState.Cgroup, originalState.ID, containerID); err != nil {
+			m.logger.Warnf("Restored container may run with different limits: %v", err)
+		}
 	}
 
 	// 12. Verify restoration
-	if err := m.verifyRestoration(config.NewContainerName); err != nil {
+	if err := m.verifyRestoration(ctx, config.NewContainerName); err != nil {
 		m.logger.Warnf("Restoration verification failed: %v", err)
 		return fmt.Errorf("restore verification failed: %w", err)
 	}
@@ -142,7 +271,83 @@ func (m *Manager) Restore(config RestoreConfig) error {
 	return nil
 }
 
-func (m *Manager) prepareMountNamespace(containerID string, mappings []docker.MountMapping, autoFix bool) error {
+// ensureNetworks recreates the networks captured at checkpoint time that the
+// restored container will join but which no longer exist.
+func (m *Manager) ensureNetworks(ctx context.Context, tx *Transaction, specs []docker.NetworkSpec, requested []string) error {
+	if len(requested) > 0 {
+		wanted := make(map[string]bool)
+		for _, name := range requested {
+			wanted[name] = true
+		}
+
+		var filtered []docker.NetworkSpec
+		for _, spec := range specs {
+			if wanted[spec.Name] {
+				filtered = append(filtered, spec)
+			}
+		}
+		specs = filtered
+	}
+
+	created, err := m.dockerManager.EnsureNetworks(ctx, specs)
+	for _, networkID := range created {
+		networkID := networkID
+		tx.Record("removed network "+networkID[:12], func(ctx context.Context) error {
+			return m.dockerManager.RemoveNetwork(ctx, networkID)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prepare networks: %w", err)
+	}
+
+	return nil
+}
+
+// restoreCgroups picks the cgroup root and management mode for restoring
+// into the container containerID. The tasks go to the new container's own
+// cgroup rather than the original's; the limits recorded at checkpoint time
+// are written to it once the restore succeeds.
+func (m *Manager) restoreCgroups(originalState *docker.ContainerState, containerID string) (string, string) {
+	if originalState.Cgroup == nil {
+		m.logger.Warn("Checkpoint has no cgroup information, restoring into the dumped cgroup paths")
+		return "", ""
+	}
+
+	root, err := docker.RelocateCgroupPath(originalState.Cgroup.Path, originalState.ID, containerID)
+	if err != nil {
+		m.logger.Warnf("Cannot relocate cgroups, restoring into the dumped paths: %v", err)
+		return "", ""
+	}
+
+	m.logger.Infof("Restoring into %s cgroup %s", originalState.Cgroup.Mode, root)
+	return root, "full"
+}
+
+// addressChanged reports whether the restore container has an IP address
+// the original container did not have.
+func (m *Manager) addressChanged(ctx context.Context, originalState *docker.ContainerState, containerID string) bool {
+	ips, err := m.dockerManager.GetContainerIPs(ctx, containerID)
+	if err != nil {
+		m.logger.Warnf("Cannot compare container addresses, assuming they changed: %v", err)
+		return true
+	}
+
+	original := make(map[string]bool)
+	for _, endpoint := range originalState.NetworkConfig {
+		if endpoint != nil && endpoint.IPAddress != "" {
+			original[endpoint.IPAddress] = true
+		}
+	}
+
+	for _, ip := range ips {
+		if ip != "" && !original[ip] {
+			return true
+		}
+	}
+	return len(original) > 0 && len(ips) == 0
+}
+
+func (m *Manager) prepareMountNamespace(tx *Transaction, containerID string, mappings []docker.MountMapping, autoFix bool) error {
 	m.logger.Info("Preparing mount namespace for restore")
 
 	// 1. Validate all mount sources exist on host
@@ -151,7 +356,12 @@ func (m *Manager) prepareMountNamespace(containerID string, mappings []docker.Mo
 			if !utils.FileExists(mapping.HostPath) && !utils.DirExists(mapping.HostPath) {
 				if autoFix {
 					m.logger.Infof("Creating missing mount source: %s", mapping.HostPath)
-					if err := utils.EnsureDir(mapping.HostPath); err != nil {
+					// A file bind mount needs a file to mount over
+					create := tx.EnsureDir
+					if mapping.SourceKind == docker.MountSourceFile {
+						create = tx.CreateFile
+					}
+					if err := create(mapping.HostPath); err != nil {
 						return fmt.Errorf("failed to create mount source %s: %w", mapping.HostPath, err)
 					}
 				} else {
@@ -237,11 +447,11 @@ func (m *Manager) checkPtraceConflicts() error {
 	return nil
 }
 
-func (m *Manager) verifyRestoration(containerName string) error {
+func (m *Manager) verifyRestoration(ctx context.Context, containerName string) error {
 	m.logger.Info("Verifying restoration...")
 
 	// Get container state
-	state, err := m.dockerManager.GetContainerState(containerName)
+	state, err := m.dockerManager.GetContainerState(ctx, containerName)
 	if err != nil {
 		// Container might not be running yet, try to get basic info
 		m.logger.Warn("Container not running, checking basic status...")
@@ -255,7 +465,7 @@ func (m *Manager) verifyRestoration(containerName string) error {
 	m.logger.Infof("  Image: %s", state.Image)
 
 	// Try to get recent logs
-	logs, err := m.dockerManager.GetContainerLogs(state.ID, "10")
+	logs, err := m.dockerManager.GetContainerLogs(ctx, state.ID, "10")
 	if err == nil && logs != "" {
 		m.logger.Infof("Recent container logs:\n%s", logs)
 	}
@@ -263,7 +473,7 @@ func (m *Manager) verifyRestoration(containerName string) error {
 	return nil
 }
 
-func (m *Manager) RestoreFromArchive(archivePath, newContainerName string, config RestoreConfig) error {
+func (m *Manager) RestoreFromArchive(ctx context.Context, archivePath, newContainerName string, config RestoreConfig) error {
 	// Extract archive to temporary directory
 	tempDir := filepath.Join(os.TempDir(), "docker-cr-restore")
 	if err := utils.EnsureDir(tempDir); err != nil {
@@ -276,7 +486,7 @@ func (m *Manager) RestoreFromArchive(archivePath, newContainerName string, confi
 	config.CheckpointDir = archivePath
 	config.NewContainerName = newContainerName
 
-	return m.Restore(config)
+	return m.Restore(ctx, config)
 }
 
 func (m *Manager) GetRestoreOptions(checkpointDir string) (*RestoreConfig, error) {
@@ -301,4 +511,4 @@ func (m *Manager) GetRestoreOptions(checkpointDir string) (*RestoreConfig, error
 	}
 
 	return config, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,84 @@
+package restore
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/checkpoint-restore/go-criu/v7/crit"
+)
+
+// joinNamespaces picks the namespaces of the restore container the restored
+// processes must join instead of recreating the dumped ones: net when the
+// restore changes the network, uts when it sets a hostname.
+func (m *Manager) joinNamespaces(config RestoreConfig, metadata *checkpoint.CheckpointMetadata) ([]string, error) {
+	var joins []string
+
+	if len(config.Networks) > 0 || config.IPAddress != "" || len(config.Publish) > 0 {
+		for _, ns := range metadata.ExternalNamespaces {
+			if ns.Type == "net" {
+				return nil, fmt.Errorf("the checkpoint shares the net namespace of %s, so its network cannot be changed", ns.Owner)
+			}
+		}
+		if err := m.checkPinnedSockets(config.CheckpointDir, metadata.ContainerState); err != nil {
+			return nil, err
+		}
+		joins = append(joins, "net")
+	}
+
+	if config.Hostname != "" {
+		joins = append(joins, "uts")
+	}
+
+	return joins, nil
+}
+
+// checkPinnedSockets fails when the checkpoint listens on an address of the
+// original container, which does not exist in the new network.
+func (m *Manager) checkPinnedSockets(checkpointDir string, originalState *docker.ContainerState) error {
+	var addresses []string
+	for _, endpoint := range originalState.NetworkConfig {
+		if endpoint != nil {
+			addresses = append(addresses, endpoint.IPAddress, endpoint.GlobalIPv6Address)
+		}
+	}
+
+	c := crit.New(nil, nil, filepath.Join(checkpointDir, "images"), false, true)
+	sks, err := c.ExploreSk()
+	if err != nil {
+		m.logger.Warnf("Cannot read sockets from images, not checking for listeners on the old address: %v", err)
+		return nil
+	}
+
+	var sockets []*crit.Socket
+	for _, sk := range sks {
+		sockets = append(sockets, sk.Sockets...)
+	}
+	if pinned := PinnedSockets(sockets, addresses); len(pinned) > 0 {
+		return fmt.Errorf("the checkpoint listens on the original container address (%v), which cannot be restored in a new network; bind to the wildcard address or keep the original network", pinned)
+	}
+	return nil
+}
+
+// PinnedSockets returns the listening TCP and bound UDP sockets, as
+// "proto addr:port", that are bound to one of addresses.
+func PinnedSockets(sockets []*crit.Socket, addresses []string) []string {
+	bound := make(map[string]bool)
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && !ip.IsUnspecified() {
+			bound[ip.String()] = true
+		}
+	}
+
+	var pinned []string
+	for _, socket := range sockets {
+		listening := (socket.Protocol == "TCP" && socket.State == "LISTEN") || socket.Protocol == "UDP"
+		ip := net.ParseIP(socket.SrcAddr)
+		if listening && ip != nil && bound[ip.String()] {
+			pinned = append(pinned, fmt.Sprintf("%s %s", socket.Protocol, net.JoinHostPort(ip.String(), fmt.Sprint(socket.SrcPort))))
+		}
+	}
+	return pinned
+}
@@ -0,0 +1,94 @@
+package restore
+
+import (
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/utils"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ParseMountRemaps builds a container path -> new host path map from
+// "<container-path>=<host-path>" specs and an optional JSON mapping file
+// holding an object of the same pairs. Specs take precedence over the file.
+func ParseMountRemaps(specs []string, mappingFile string) (map[string]string, error) {
+	remaps := make(map[string]string)
+
+	if mappingFile != "" {
+		data, err := utils.ReadFile(mappingFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &remaps); err != nil {
+			return nil, fmt.Errorf("failed to parse mount mapping file %s: %w", mappingFile, err)
+		}
+	}
+
+	for _, spec := range specs {
+		containerPath, hostPath, ok := strings.Cut(spec, "=")
+		if !ok || containerPath == "" || hostPath == "" {
+			return nil, fmt.Errorf("invalid mount remap %q, expected <container-path>=<host-path>", spec)
+		}
+		remaps[containerPath] = hostPath
+	}
+
+	for containerPath, hostPath := range remaps {
+		if !filepath.IsAbs(containerPath) || !filepath.IsAbs(hostPath) {
+			return nil, fmt.Errorf("mount remap %s=%s must use absolute paths", containerPath, hostPath)
+		}
+	}
+
+	return remaps, nil
+}
+
+// remapMounts returns a copy of mappings with the host paths replaced as
+// given by remaps. Every remapped container path must be a recorded mount.
+func (m *Manager) remapMounts(mappings []docker.MountMapping, remaps map[string]string) ([]docker.MountMapping, error) {
+	if len(remaps) == 0 {
+		return mappings, nil
+	}
+
+	remapped := make([]docker.MountMapping, len(mappings))
+	copy(remapped, mappings)
+
+	used := make(map[string]bool)
+	for i := range remapped {
+		mapping := &remapped[i]
+		newHostPath, ok := remaps[mapping.ContainerPath]
+		if !ok {
+			continue
+		}
+		used[mapping.ContainerPath] = true
+
+		m.logger.Infof("Remapping mount %s: %s -> %s", mapping.ContainerPath, mapping.HostPath, newHostPath)
+		m.checkSourceKind(*mapping, newHostPath)
+		mapping.HostPath = newHostPath
+	}
+
+	for containerPath := range remaps {
+		if !used[containerPath] {
+			return nil, fmt.Errorf("cannot remap %s: the checkpoint has no mount at that path", containerPath)
+		}
+	}
+
+	return remapped, nil
+}
+
+// checkSourceKind warns when a new mount source is not the same kind of
+// object (file or directory) as the one the checkpoint was taken with.
+func (m *Manager) checkSourceKind(mapping docker.MountMapping, newHostPath string) {
+	if mapping.SourceKind == "" {
+		return
+	}
+
+	kind := docker.MountSourceKind(newHostPath)
+	switch {
+	case kind == "":
+		if mapping.SourceKind == docker.MountSourceFile {
+			m.logger.Warnf("New source %s for %s does not exist; the checkpoint expects a file", newHostPath, mapping.ContainerPath)
+		}
+	case kind != mapping.SourceKind:
+		m.logger.Warnf("New source %s for %s is a %s but the checkpoint was taken with a %s", newHostPath, mapping.ContainerPath, kind, mapping.SourceKind)
+	}
+}
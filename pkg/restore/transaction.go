@@ -0,0 +1,173 @@
+package restore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rollbackTimeout bounds the cleanup after a failed restore. Rollback runs on
+// its own context so it still happens when the restore was cancelled.
+const rollbackTimeout = 30 * time.Second
+
+// RollbackAction describes one side effect undone after a failed restore.
+type RollbackAction struct {
+	Description string `json:"description"`
+	Error       string `json:"error,omitempty"`
+}
+
+// RestoreError is returned when a restore fails after it already changed the
+// host. RolledBack lists every change that was undone, newest first.
+type RestoreError struct {
+	Err        error
+	RolledBack []RollbackAction
+}
+
+func (e *RestoreError) Error() string {
+	var undone, failed []string
+	for _, action := range e.RolledBack {
+		if action.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", action.Description, action.Error))
+		} else {
+			undone = append(undone, action.Description)
+		}
+	}
+
+	msg := e.Err.Error()
+	if len(undone) > 0 {
+		msg += "; rolled back: " + strings.Join(undone, ", ")
+	}
+	if len(failed) > 0 {
+		msg += "; failed to roll back: " + strings.Join(failed, ", ")
+	}
+	return msg
+}
+
+func (e *RestoreError) Unwrap() error {
+	return e.Err
+}
+
+type rollbackStep struct {
+	description string
+	undo        func(ctx context.Context) error
+}
+
+// Transaction records the side effects of a restore so they can be undone in
+// reverse order if a later step fails. A restore that succeeds simply drops it.
+type Transaction struct {
+	logger *logrus.Logger
+	steps  []rollbackStep
+}
+
+func NewTransaction(logger *logrus.Logger) *Transaction {
+	return &Transaction{logger: logger}
+}
+
+// Record registers undo to be run on rollback.
+func (t *Transaction) Record(description string, undo func(ctx context.Context) error) {
+	t.steps = append(t.steps, rollbackStep{description: description, undo: undo})
+}
+
+// EnsureDir creates dirPath like utils.EnsureDir and records the topmost
+// directory that did not exist before.
+func (t *Transaction) EnsureDir(dirPath string) error {
+	created := ""
+	for dir := filepath.Clean(dirPath); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		created = dir
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	if created == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dirPath, err)
+	}
+
+	t.Record("removed directory "+created, func(ctx context.Context) error {
+		return os.RemoveAll(created)
+	})
+	return nil
+}
+
+// CreateFile creates an empty filePath, and its missing parent directories,
+// recording their removal.
+func (t *Transaction) CreateFile(filePath string) error {
+	if err := t.EnsureDir(filepath.Dir(filePath)); err != nil {
+		return err
+	}
+
+	return t.WriteFile(filePath, func() error {
+		if err := os.WriteFile(filePath, nil, 0644); err != nil {
+			return fmt.Errorf("failed to create file %s: %w", filePath, err)
+		}
+		return nil
+	})
+}
+
+// WriteFile writes a generated file and records how to put back whatever was
+// there before.
+func (t *Transaction) WriteFile(filePath string, write func() error) error {
+	previous, err := os.ReadFile(filePath)
+	existed := err == nil
+
+	if err := write(); err != nil {
+		return err
+	}
+
+	if existed {
+		t.Record("restored previous "+filePath, func(ctx context.Context) error {
+			return os.WriteFile(filePath, previous, 0644)
+		})
+	} else {
+		t.Record("removed file "+filePath, func(ctx context.Context) error {
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// Rollback undoes all recorded steps in reverse order and wraps cause in a
+// RestoreError listing them. cause is returned as is if nothing was recorded.
+func (t *Transaction) Rollback(cause error) error {
+	if len(t.steps) == 0 {
+		return cause
+	}
+
+	t.logger.Warnf("Restore failed, rolling back %d change(s)", len(t.steps))
+
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	restoreErr := &RestoreError{Err: cause}
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		step := t.steps[i]
+		action := RollbackAction{Description: step.description}
+
+		if err := step.undo(ctx); err != nil {
+			action.Error = err.Error()
+			t.logger.Errorf("Rollback step failed: %s: %v", step.description, err)
+		} else {
+			t.logger.Infof("Rolled back: %s", step.description)
+		}
+
+		restoreErr.RolledBack = append(restoreErr.RolledBack, action)
+	}
+
+	t.steps = nil
+	return restoreErr
+}
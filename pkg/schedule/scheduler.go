@@ -0,0 +1,290 @@
+package schedule
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/utils"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NamePrefix marks checkpoints created by the scheduler so rotation never
+// touches checkpoints taken by hand.
+const NamePrefix = "auto-"
+
+const statusFileName = "schedule_status.json"
+
+type Scheduler struct {
+	dockerManager     *docker.Manager
+	checkpointManager *checkpoint.Manager
+	logger            *logrus.Logger
+}
+
+// Job is one entry of a schedule file, or the flags of `docker-cr schedule`.
+type Job struct {
+	Container      string `json:"container"`
+	Every          string `json:"every"`
+	Keep           int    `json:"keep"`
+	FullEvery      int    `json:"full_every"`
+	OutputDir      string `json:"output_dir"`
+	Incremental    bool   `json:"incremental"`
+	TcpEstablished bool   `json:"tcp_established"`
+	FileLocks      bool   `json:"file_locks"`
+	ManageCgroups  bool   `json:"manage_cgroups"`
+	MaxFailures    int    `json:"max_failures"`
+}
+
+type ScheduleFile struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// JobStatus is persisted next to the container's checkpoints after every run.
+type JobStatus struct {
+	Container           string `json:"container"`
+	LastRun             string `json:"last_run"`
+	LastSuccess         string `json:"last_success"`
+	LastCheckpoint      string `json:"last_checkpoint"`
+	LastError           string `json:"last_error,omitempty"`
+	Runs                int    `json:"runs"`
+	Failures            int    `json:"failures"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	ChainLength         int    `json:"chain_length"`
+}
+
+func NewScheduler(dockerManager *docker.Manager, checkpointManager *checkpoint.Manager, logger *logrus.Logger) *Scheduler {
+	return &Scheduler{
+		dockerManager:     dockerManager,
+		checkpointManager: checkpointManager,
+		logger:            logger,
+	}
+}
+
+func LoadScheduleFile(filePath string) (*ScheduleFile, error) {
+	data, err := utils.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule file: %w", err)
+	}
+
+	var file ScheduleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule file: %w", err)
+	}
+
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("schedule file %s contains no jobs", filePath)
+	}
+
+	return &file, nil
+}
+
+// Run checkpoints every job on its interval until ctx is cancelled or a job
+// exceeds its MaxFailures limit.
+func (s *Scheduler) Run(ctx context.Context, jobs []Job) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	intervals := make([]time.Duration, len(jobs))
+	for i := range jobs {
+		interval, err := s.validateJob(&jobs[i])
+		if err != nil {
+			return fmt.Errorf("invalid schedule for %s: %w", jobs[i].Container, err)
+		}
+		intervals[i] = interval
+	}
+
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(job Job, interval time.Duration) {
+			defer wg.Done()
+			if err := s.runJob(ctx, job, interval); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(job, intervals[i])
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+func (s *Scheduler) validateJob(job *Job) (time.Duration, error) {
+	if job.Container == "" {
+		return 0, fmt.Errorf("container is required")
+	}
+
+	interval, err := utils.ParseDuration(job.Every)
+	if err != nil {
+		return 0, err
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("interval must be positive")
+	}
+
+	if job.OutputDir == "" {
+		job.OutputDir = "/tmp/docker-checkpoints"
+	}
+	if job.FullEvery <= 0 {
+		job.FullEvery = job.Keep
+	}
+	if job.FullEvery <= 0 {
+		job.FullEvery = 10
+	}
+
+	return interval, nil
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job Job, interval time.Duration) error {
+	s.logger.Infof("Scheduling checkpoints of %s every %s (keep %d)", job.Container, interval, job.Keep)
+
+	status := &JobStatus{Container: job.Container}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunOnce(ctx, job, status); err != nil {
+			s.logger.Errorf("Scheduled checkpoint of %s failed (%d in a row): %v",
+				job.Container, status.ConsecutiveFailures, err)
+
+			if job.MaxFailures > 0 && status.ConsecutiveFailures >= job.MaxFailures {
+				return fmt.Errorf("giving up on %s after %d consecutive failures: %w",
+					job.Container, status.ConsecutiveFailures, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			s.logger.Infof("Stopped scheduling checkpoints of %s", job.Container)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce takes a single scheduled checkpoint, rotates old ones and updates
+// the job status. An incremental chain is restarted with a full dump after
+// FullEvery checkpoints or after any failure.
+func (s *Scheduler) RunOnce(ctx context.Context, job Job, status *JobStatus) error {
+	status.Runs++
+	status.LastRun = utils.GetCurrentTimestamp()
+
+	err := s.checkpoint(ctx, job, status)
+	if err != nil {
+		status.Failures++
+		status.ConsecutiveFailures++
+		status.LastError = err.Error()
+		status.ChainLength = 0
+	} else {
+		status.ConsecutiveFailures = 0
+		status.LastError = ""
+		status.LastSuccess = status.LastRun
+	}
+
+	s.saveStatus(job, status)
+	return err
+}
+
+func (s *Scheduler) checkpoint(ctx context.Context, job Job, status *JobStatus) error {
+	state, err := s.dockerManager.GetContainerState(ctx, job.Container)
+	if err != nil {
+		return fmt.Errorf("failed to get container state: %w", err)
+	}
+	status.Container = state.Name
+
+	config := checkpoint.CheckpointConfig{
+		OutputDir:      job.OutputDir,
+		CheckpointName: NamePrefix + time.Now().UTC().Format("20060102T150405Z"),
+		LeaveRunning:   true,
+		TcpEstablished: job.TcpEstablished,
+		FileLocks:      job.FileLocks,
+		LogLevel:       4,
+		ManageCgroups:  job.ManageCgroups,
+		TrackMem:       job.Incremental,
+	}
+
+	config.ParentCheckpoint = NextParent(job, status)
+	incremental := config.ParentCheckpoint != ""
+
+	if err := s.checkpointManager.Checkpoint(ctx, job.Container, config); err != nil {
+		return err
+	}
+
+	status.LastCheckpoint = filepath.Join(job.OutputDir, state.Name, config.CheckpointName)
+	if incremental {
+		status.ChainLength++
+	} else {
+		status.ChainLength = 1
+	}
+	s.logger.Infof("Scheduled checkpoint created: %s", status.LastCheckpoint)
+
+	if job.Keep > 0 {
+		result, err := s.checkpointManager.Prune(checkpoint.PruneConfig{
+			OutputDir:  job.OutputDir,
+			Container:  state.Name,
+			NamePrefix: NamePrefix,
+			Policy:     checkpoint.RetentionPolicy{KeepLast: job.Keep},
+		})
+		if err != nil {
+			// Rotation problems should not fail an otherwise good checkpoint
+			s.logger.Warnf("Failed to rotate checkpoints of %s: %v", state.Name, err)
+		} else if result.Deleted > 0 {
+			s.logger.Infof("Rotated %d old checkpoint(s) of %s", result.Deleted, state.Name)
+		}
+	}
+
+	return nil
+}
+
+// NextParent returns the checkpoint the next run of job builds on, or "" when
+// it takes a full dump: the chain is empty, was broken by a failure, or has
+// reached FullEvery checkpoints.
+func NextParent(job Job, status *JobStatus) string {
+	if !job.Incremental || status.LastCheckpoint == "" {
+		return ""
+	}
+	if status.ChainLength <= 0 || status.ChainLength >= job.FullEvery {
+		return ""
+	}
+	return status.LastCheckpoint
+}
+
+func (s *Scheduler) saveStatus(job Job, status *JobStatus) {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		s.logger.Warnf("Failed to marshal schedule status: %v", err)
+		return
+	}
+
+	statusFile := filepath.Join(job.OutputDir, status.Container, statusFileName)
+	if err := utils.WriteFile(statusFile, data); err != nil {
+		s.logger.Warnf("Failed to write schedule status: %v", err)
+	}
+}
+
+// LoadStatus reads the status written by a running scheduler.
+func LoadStatus(outputDir, container string) (*JobStatus, error) {
+	data, err := utils.ReadFile(filepath.Join(outputDir, container, statusFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var status JobStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule status: %w", err)
+	}
+
+	return &status, nil
+}
@@ -0,0 +1,196 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"docker-cr/pkg/docker"
+	"encoding/hex"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces secret values in redacted output
+const Redacted = "[REDACTED]"
+
+// Values at least this long with at least this much Shannon entropy per
+// character look like generated keys or tokens
+const (
+	minEntropyLength = 20
+	minEntropy       = 4.0
+)
+
+// Rule is a pattern for a well-known credential format.
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+var Rules = []Rule{
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"gitlab-token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`)},
+	{"stripe-key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{24,}\b`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY-----`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"url-credentials", regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://[^/\s:@"]+:[^/\s@"]+@[^/\s"]+`)},
+}
+
+// Names of variables that hold credentials whatever their value looks like
+var sensitiveName = regexp.MustCompile(`(?i)pass(word|wd|phrase)?$|secret|token|api_?key|access_?key|private_?key|credential|(^|_)auth(_|$)|_pw$`)
+
+type Finding struct {
+	// Source is "env", "metadata" or "memory"
+	Source   string `json:"source"`
+	Location string `json:"location"`
+	Rule     string `json:"rule"`
+	// Match is the secret with all but its first characters masked
+	Match string `json:"match"`
+	// Fingerprint tells apart secrets whose masked matches are the same
+	Fingerprint string `json:"-"`
+}
+
+// NewFinding records secret, masked, as found by rule.
+func NewFinding(source, location, rule, secret string) Finding {
+	sum := sha256.Sum256([]byte(secret))
+	return Finding{
+		Source:      source,
+		Location:    location,
+		Rule:        rule,
+		Match:       Mask(secret),
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+}
+
+// Entropy returns the Shannon entropy of s in bits per character.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len([]rune(s)))
+	var entropy float64
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// MatchRule returns the name of the first rule matching s.
+func MatchRule(s string) (string, bool) {
+	for _, rule := range Rules {
+		if rule.Pattern.MatchString(s) {
+			return rule.Name, true
+		}
+	}
+	return "", false
+}
+
+// classifyEnv returns why the variable key=value is likely a secret.
+func classifyEnv(key, value string) (string, bool) {
+	if value == "" {
+		return "", false
+	}
+	if rule, ok := MatchRule(value); ok {
+		return rule, true
+	}
+	if sensitiveName.MatchString(key) {
+		return "sensitive-name", true
+	}
+	if len(value) >= minEntropyLength && !strings.Contains(value, " ") && !strings.HasPrefix(value, "/") && Entropy(value) >= minEntropy {
+		return "high-entropy", true
+	}
+	return "", false
+}
+
+// IsSecretEnv reports whether the environment variable key=value likely holds
+// a credential.
+func IsSecretEnv(key, value string) bool {
+	_, ok := classifyEnv(key, value)
+	return ok
+}
+
+func ScanEnvironment(env map[string]string) []Finding {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var findings []Finding
+	for _, key := range keys {
+		if rule, ok := classifyEnv(key, env[key]); ok {
+			findings = append(findings, NewFinding("env", key, rule, env[key]))
+		}
+	}
+	return findings
+}
+
+// ScanText looks for credentials matching Rules in data.
+func ScanText(source, location string, data []byte) []Finding {
+	var findings []Finding
+	for _, rule := range Rules {
+		for _, match := range rule.Pattern.FindAll(data, -1) {
+			findings = append(findings, NewFinding(source, location, rule.Name, string(match)))
+		}
+	}
+	return findings
+}
+
+// Mask keeps the first characters of a secret so findings can be told apart.
+func Mask(secret string) string {
+	visible := 4
+	if len(secret) <= 2*visible {
+		visible = len(secret) / 4
+	}
+	return secret[:visible] + strings.Repeat("*", 8)
+}
+
+// RedactEnvironment returns a copy of env with secret values replaced.
+func RedactEnvironment(env map[string]string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(env))
+	for key, value := range env {
+		if IsSecretEnv(key, value) {
+			value = Redacted
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// RedactEnvList redacts a KEY=VALUE list such as a container's Config.Env.
+func RedactEnvList(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	redacted := make([]string, len(env))
+	for i, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		if IsSecretEnv(key, value) {
+			entry = key + "=" + Redacted
+		}
+		redacted[i] = entry
+	}
+	return redacted
+}
+
+// RedactContainerState masks secret environment values of a saved container
+// in place, both in the environment map and in the container config.
+func RedactContainerState(state *docker.ContainerState) {
+	if state == nil {
+		return
+	}
+	state.Environment = RedactEnvironment(state.Environment)
+	if state.Config != nil {
+		state.Config.Env = RedactEnvList(state.Config.Env)
+	}
+}
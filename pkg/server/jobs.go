@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"docker-cr/pkg/utils"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type JobState string
+
+const (
+	JobPending   JobState = "pending"
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Job tracks one asynchronous checkpoint or restore request.
+type Job struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Target     string      `json:"target"`
+	State      JobState    `json:"state"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	CreatedAt  string      `json:"created_at"`
+	StartedAt  string      `json:"started_at,omitempty"`
+	FinishedAt string      `json:"finished_at,omitempty"`
+
+	cancel   context.CancelFunc
+	finished time.Time
+}
+
+// Finished jobs are kept for polling until they are older than the TTL or
+// outnumber the limit, oldest first
+const (
+	defaultMaxFinishedJobs = 100
+	defaultJobTTL          = time.Hour
+)
+
+type jobFunc func(ctx context.Context) (interface{}, error)
+
+type jobStore struct {
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	wg          sync.WaitGroup
+	maxFinished int
+	ttl         time.Duration
+}
+
+func newJobStore(maxFinished int, ttl time.Duration) *jobStore {
+	if maxFinished <= 0 {
+		maxFinished = defaultMaxFinishedJobs
+	}
+	if ttl <= 0 {
+		ttl = defaultJobTTL
+	}
+	return &jobStore{jobs: make(map[string]*Job), maxFinished: maxFinished, ttl: ttl}
+}
+
+// evict drops finished jobs past the TTL, then the oldest ones beyond the
+// limit. The caller holds s.mu.
+func (s *jobStore) evict() {
+	var finished []*Job
+	for id, job := range s.jobs {
+		if job.finished.IsZero() {
+			continue
+		}
+		if time.Since(job.finished) > s.ttl {
+			delete(s.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+
+	if len(finished) <= s.maxFinished {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].finished.Before(finished[j].finished)
+	})
+	for _, job := range finished[:len(finished)-s.maxFinished] {
+		delete(s.jobs, job.ID)
+	}
+}
+
+// start registers a job and runs fn in the background. The returned copy is
+// a snapshot; use get for the current state.
+func (s *jobStore) start(ctx context.Context, jobType, target string, fn jobFunc) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	job := &Job{
+		ID:        id,
+		Type:      jobType,
+		Target:    target,
+		State:     JobPending,
+		CreatedAt: utils.GetCurrentTimestamp(),
+		cancel:    cancel,
+	}
+
+	s.mu.Lock()
+	s.evict()
+	s.jobs[id] = job
+	snapshot := *job
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.run(ctx, job, fn)
+
+	return snapshot, nil
+}
+
+func (s *jobStore) run(ctx context.Context, job *Job, fn jobFunc) {
+	defer s.wg.Done()
+	defer job.cancel()
+
+	s.mu.Lock()
+	if ctx.Err() != nil {
+		job.State = JobCancelled
+		job.FinishedAt = utils.GetCurrentTimestamp()
+		job.finished = time.Now()
+		s.mu.Unlock()
+		return
+	}
+	job.State = JobRunning
+	job.StartedAt = utils.GetCurrentTimestamp()
+	s.mu.Unlock()
+
+	result, err := fn(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.FinishedAt = utils.GetCurrentTimestamp()
+	job.finished = time.Now()
+	switch {
+	case ctx.Err() != nil:
+		job.State = JobCancelled
+		if err != nil {
+			job.Error = err.Error()
+		}
+	case err != nil:
+		job.State = JobFailed
+		job.Error = err.Error()
+	default:
+		job.State = JobSucceeded
+		job.Result = result
+	}
+}
+
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (s *jobStore) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt < jobs[j].CreatedAt
+	})
+	return jobs
+}
+
+// cancel requests cancellation of a pending or running job.
+func (s *jobStore) cancel(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+
+	if job.State != JobPending && job.State != JobRunning {
+		return *job, fmt.Errorf("job %s already %s", id, job.State)
+	}
+
+	job.cancel()
+	return *job, nil
+}
+
+func (s *jobStore) cancelAll() {
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		job.cancel()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func newJobID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
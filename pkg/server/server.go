@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/inspect"
+	"docker-cr/pkg/restore"
+	"docker-cr/pkg/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var errJobNotFound = errors.New("job not found")
+
+// Server exposes the checkpoint, restore and inspect managers as a JSON API.
+//
+//	GET    /v1/checkpoints[?container=NAME]      list checkpoints
+//	POST   /v1/checkpoints                       start a checkpoint job
+//	GET    /v1/checkpoints/{container}/{name}    inspect (?summary=true for text summary)
+//	DELETE /v1/checkpoints/{container}/{name}    delete a checkpoint
+//	POST   /v1/restores                          start a restore job
+//	GET    /v1/jobs                              list jobs
+//	GET    /v1/jobs/{id}                         job status
+//	DELETE /v1/jobs/{id}                         cancel a job
+type Server struct {
+	dockerManager     *docker.Manager
+	checkpointManager *checkpoint.Manager
+	restoreManager    *restore.Manager
+	viewer            *inspect.Viewer
+	logger            *logrus.Logger
+	config            ServerConfig
+	jobs              *jobStore
+}
+
+type ServerConfig struct {
+	SocketPath string `json:"socket_path"`
+	TCPAddr    string `json:"tcp_addr"`
+	OutputDir  string `json:"output_dir"`
+	// Finished jobs kept for polling, and for how long; 0 means 100 and 1h
+	MaxJobs int           `json:"max_jobs,omitempty"`
+	JobTTL  time.Duration `json:"job_ttl,omitempty"`
+
+	// Hook scripts and keys of every job; requests cannot name host
+	// executables or key files
+	Hooks       *checkpoint.Hooks         `json:"hooks,omitempty"`
+	Encryption  *checkpoint.EncryptionKey `json:"encryption,omitempty"`
+	SigningKey  string                    `json:"signing_key,omitempty"`
+	TrustedKeys []string                  `json:"trusted_keys,omitempty"`
+}
+
+type CheckpointRequest struct {
+	Container string `json:"container"`
+	checkpoint.CheckpointConfig
+}
+
+type RestoreRequest struct {
+	Container  string `json:"container"`
+	Checkpoint string `json:"checkpoint"`
+	restore.RestoreConfig
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewServer(dockerManager *docker.Manager, checkpointManager *checkpoint.Manager, restoreManager *restore.Manager, viewer *inspect.Viewer, logger *logrus.Logger, config ServerConfig) *Server {
+	return &Server{
+		dockerManager:     dockerManager,
+		checkpointManager: checkpointManager,
+		restoreManager:    restoreManager,
+		viewer:            viewer,
+		logger:            logger,
+		config:            config,
+		jobs:              newJobStore(config.MaxJobs, config.JobTTL),
+	}
+}
+
+// Serve listens on the configured Unix socket (and TCP address, if any)
+// until ctx is cancelled, then cancels outstanding jobs.
+func (s *Server) Serve(ctx context.Context) error {
+	var listeners []net.Listener
+
+	if s.config.SocketPath != "" {
+		if err := os.Remove(s.config.SocketPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+		// Group members must reach the socket, so its directory is not private
+		if err := os.MkdirAll(filepath.Dir(s.config.SocketPath), 0755); err != nil {
+			return fmt.Errorf("failed to create socket directory: %w", err)
+		}
+
+		l, err := net.Listen("unix", s.config.SocketPath)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.config.SocketPath, err)
+		}
+		if err := os.Chmod(s.config.SocketPath, 0660); err != nil {
+			l.Close()
+			return fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+		defer os.Remove(s.config.SocketPath)
+		listeners = append(listeners, l)
+	}
+
+	if s.config.TCPAddr != "" {
+		l, err := net.Listen("tcp", s.config.TCPAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.config.TCPAddr, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		return fmt.Errorf("no socket or TCP address configured")
+	}
+
+	httpServer := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		s.logger.Infof("API server listening on %s://%s", l.Addr().Network(), l.Addr())
+		go func(l net.Listener) {
+			errs <- httpServer.Serve(l)
+		}(l)
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-errs:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	httpServer.Shutdown(shutdownCtx)
+
+	s.jobs.cancelAll()
+
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/checkpoints", s.handleCheckpoints)
+	mux.HandleFunc("/v1/checkpoints/", s.handleCheckpoint)
+	mux.HandleFunc("/v1/restores", s.handleRestores)
+	mux.HandleFunc("/v1/jobs", s.handleJobs)
+	mux.HandleFunc("/v1/jobs/", s.handleJob)
+	return mux
+}
+
+func (s *Server) handleCheckpoints(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := s.checkpointManager.ListCheckpoints(s.config.OutputDir)
+		if err != nil {
+			if !utils.DirExists(s.config.OutputDir) {
+				writeJSON(w, http.StatusOK, []checkpoint.CheckpointEntry{})
+				return
+			}
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		container := r.URL.Query().Get("container")
+		filtered := make([]checkpoint.CheckpointEntry, 0, len(entries))
+		for _, entry := range entries {
+			if container == "" || entry.Container == container {
+				filtered = append(filtered, entry)
+			}
+		}
+		writeJSON(w, http.StatusOK, filtered)
+
+	case http.MethodPost:
+		var req CheckpointRequest
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Container == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("container is required"))
+			return
+		}
+
+		config := req.CheckpointConfig
+		config.OutputDir = s.config.OutputDir
+		config.Hooks = s.config.Hooks
+		config.Encryption = s.config.Encryption
+		config.SigningKey = s.config.SigningKey
+		if config.CheckpointName == "" {
+			config.CheckpointName = "checkpoint-" + time.Now().UTC().Format("20060102T150405Z")
+		}
+		if err := validatePathElement(config.CheckpointName); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if config.LogLevel == 0 {
+			config.LogLevel = 4
+		}
+		// The parent is a checkpoint name of the same container, never a
+		// host path.
+		parent := config.ParentCheckpoint
+		if parent != "" {
+			if err := validatePathElement(parent); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid parent checkpoint: %w", err))
+				return
+			}
+		}
+
+		job, err := s.jobs.start(context.Background(), "checkpoint", req.Container, func(ctx context.Context) (interface{}, error) {
+			state, err := s.dockerManager.GetContainerState(ctx, req.Container)
+			if err != nil {
+				return nil, err
+			}
+			if parent != "" {
+				if config.ParentCheckpoint, err = s.checkpointPath(state.Name, parent); err != nil {
+					return nil, err
+				}
+				if !utils.DirExists(config.ParentCheckpoint) {
+					return nil, fmt.Errorf("parent checkpoint %s/%s not found", state.Name, parent)
+				}
+			}
+			if err := s.checkpointManager.Checkpoint(ctx, req.Container, config); err != nil {
+				return nil, err
+			}
+			return map[string]string{
+				"container":      state.Name,
+				"checkpoint":     config.CheckpointName,
+				"checkpoint_dir": filepath.Join(config.OutputDir, state.Name, config.CheckpointName),
+			}, nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) handleCheckpoint(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/checkpoints/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("expected /v1/checkpoints/{container}/{name}"))
+		return
+	}
+
+	checkpointDir, err := s.checkpointPath(parts[0], parts[1])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !utils.DirExists(checkpointDir) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("checkpoint %s/%s not found", parts[0], parts[1]))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		dir, cleanup, err := s.checkpointManager.OpenCheckpoint(checkpointDir, s.config.Encryption)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer cleanup()
+
+		if r.URL.Query().Get("summary") == "true" {
+			summary, err := s.viewer.GetSummary(dir)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"summary": summary})
+			return
+		}
+
+		output, err := s.viewer.ShowCheckpoint(dir, inspect.ViewOptions{OutputFormat: "json", ShowAll: true})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(output))
+
+	case http.MethodDelete:
+		store := checkpoint.StoreOf(checkpointDir)
+		if err := s.checkpointManager.DeleteCheckpoint(checkpointDir); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if store != "" {
+			// CollectGarbage holds the store lock while it sweeps.
+			if _, err := s.checkpointManager.CollectGarbage(store); err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (s *Server) handleRestores(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req RestoreRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	checkpointDir, err := s.checkpointPath(req.Container, req.Checkpoint)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	defaults, err := s.restoreManager.GetRestoreOptions(checkpointDir)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	config := req.RestoreConfig
+	config.CheckpointDir = checkpointDir
+	config.Hooks = s.config.Hooks
+	config.Encryption = s.config.Encryption
+	config.TrustedKeys = s.config.TrustedKeys
+	if config.NewContainerName == "" {
+		config.NewContainerName = defaults.NewContainerName
+	}
+	if config.LogLevel == 0 {
+		config.LogLevel = defaults.LogLevel
+	}
+
+	job, err := s.jobs.start(context.Background(), "restore", req.Container+"/"+req.Checkpoint, func(ctx context.Context) (interface{}, error) {
+		if err := s.restoreManager.Restore(ctx, config); err != nil {
+			return nil, err
+		}
+		return map[string]string{"container": config.NewContainerName}, nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+
+	switch r.Method {
+	case http.MethodGet:
+		job, ok := s.jobs.get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, errJobNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+
+	case http.MethodDelete:
+		job, err := s.jobs.cancel(id)
+		if errors.Is(err, errJobNotFound) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, job)
+
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// checkpointPath maps API identifiers onto the output directory, refusing
+// anything that could escape it.
+func (s *Server) checkpointPath(container, name string) (string, error) {
+	if err := validatePathElement(container); err != nil {
+		return "", fmt.Errorf("invalid container: %w", err)
+	}
+	if err := validatePathElement(name); err != nil {
+		return "", fmt.Errorf("invalid checkpoint name: %w", err)
+	}
+	return filepath.Join(s.config.OutputDir, container, name), nil
+}
+
+func validatePathElement(element string) error {
+	if element == "" || element == "." || element == ".." || strings.ContainsAny(element, `/\`) {
+		return fmt.Errorf("%q is not a valid name", element)
+	}
+	return nil
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
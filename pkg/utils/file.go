@@ -3,22 +3,62 @@ package utils
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
+// Checkpoints hold process memory and environments, so everything written
+// here is private to the owner.
 func WriteFile(filePath string, data []byte) error {
 	dir := filepath.Dir(filePath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
 		return fmt.Errorf("failed to write file %s: %w", filePath, err)
 	}
 
 	return nil
 }
 
+// WriteFileAtomic writes data to a temporary file next to filePath and
+// renames it into place, so readers never see a partially written file.
+func WriteFileAtomic(filePath string, data []byte) error {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", filePath, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync file %s: %w", filePath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", filePath, err)
+	}
+
+	if err := os.Rename(tmp.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to replace file %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
 func ReadFile(filePath string) ([]byte, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
@@ -39,7 +79,7 @@ func DirExists(dirPath string) bool {
 
 func EnsureDir(dirPath string) error {
 	if !DirExists(dirPath) {
-		if err := os.MkdirAll(dirPath, 0755); err != nil {
+		if err := os.MkdirAll(dirPath, 0700); err != nil {
 			return fmt.Errorf("failed to create directory %s: %w", dirPath, err)
 		}
 	}
@@ -79,4 +119,20 @@ func GetFileSize(filePath string) (int64, error) {
 		return 0, fmt.Errorf("failed to stat file %s: %w", filePath, err)
 	}
 	return info.Size(), nil
-}
\ No newline at end of file
+}
+
+// CopyTree copies src (a file or directory) to dst preserving ownership and
+// permissions. Where the filesystem supports it the copy shares data blocks
+// with the source (reflink) and is copy-on-write.
+func CopyTree(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(dst), err)
+	}
+
+	output, err := exec.Command("cp", "-a", "--reflink=auto", src, dst).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w: %s", src, dst, err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
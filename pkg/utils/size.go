@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable size such as "512M", "10G" or "1.5GB".
+// Units are binary (1K = 1024 bytes); a bare number is taken as bytes.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	if s == "" {
+		return 0, fmt.Errorf("invalid size")
+	}
+
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if unit.suffix != "B" && strings.HasSuffix(s, unit.suffix) {
+			factor = unit.factor
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(n * float64(factor)), nil
+}
+
+// FormatSize renders a byte count using the largest fitting binary unit.
+func FormatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if unit.factor > 1 && size >= unit.factor {
+			return fmt.Sprintf("%.1f%sB", float64(size)/float64(unit.factor), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
+
+// DirSize returns the total size of all regular files below dirPath.
+// Symlinks are not followed.
+func DirSize(dirPath string) (int64, error) {
+	var total int64
+
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute size of %s: %w", dirPath, err)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,367 @@
+package watch
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/restore"
+	"docker-cr/pkg/utils"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/sirupsen/logrus"
+)
+
+// LabelCheckpointOnStop opts a container in to being checkpointed by the watcher.
+const LabelCheckpointOnStop = "docker-cr.checkpoint-on-stop"
+
+const stateFileName = "watch_state.json"
+
+type Watcher struct {
+	dockerManager     *docker.Manager
+	checkpointManager *checkpoint.Manager
+	restoreManager    *restore.Manager
+	logger            *logrus.Logger
+	config            WatchConfig
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+	wg       sync.WaitGroup
+}
+
+type WatchConfig struct {
+	OutputDir      string        `json:"output_dir"`
+	AutoRestore    bool          `json:"auto_restore"`
+	TcpEstablished bool          `json:"tcp_established"`
+	FileLocks      bool          `json:"file_locks"`
+	ManageCgroups  bool          `json:"manage_cgroups"`
+	ReconnectDelay time.Duration `json:"reconnect_delay"`
+}
+
+// PendingRestore is a checkpoint taken because the host was shutting down,
+// waiting to be restored once the daemon is back.
+type PendingRestore struct {
+	Container  string `json:"container"`
+	Checkpoint string `json:"checkpoint"`
+	Reason     string `json:"reason"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type watchState struct {
+	Pending []PendingRestore `json:"pending"`
+}
+
+func NewWatcher(dockerManager *docker.Manager, checkpointManager *checkpoint.Manager, restoreManager *restore.Manager, logger *logrus.Logger, config WatchConfig) *Watcher {
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = 5 * time.Second
+	}
+
+	return &Watcher{
+		dockerManager:     dockerManager,
+		checkpointManager: checkpointManager,
+		restoreManager:    restoreManager,
+		logger:            logger,
+		config:            config,
+		inFlight:          make(map[string]bool),
+	}
+}
+
+// Run follows the Docker event stream until ctx is cancelled, reconnecting
+// whenever the daemon goes away. Pending restores are retried on startup and
+// after every reconnect when AutoRestore is set.
+func (w *Watcher) Run(ctx context.Context) error {
+	label := LabelCheckpointOnStop + "=true"
+	w.logger.Infof("Watching containers labelled %s", label)
+
+	for {
+		if err := w.dockerManager.Ping(ctx); err != nil {
+			w.logger.Warnf("%v, retrying in %s", err, w.config.ReconnectDelay)
+		} else {
+			if w.config.AutoRestore {
+				w.RestorePending(ctx)
+			}
+
+			if err := w.follow(ctx, label); ctx.Err() == nil {
+				w.logger.Warnf("Lost Docker event stream: %v, reconnecting in %s", err, w.config.ReconnectDelay)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			w.wg.Wait()
+			return nil
+		case <-time.After(w.config.ReconnectDelay):
+		}
+	}
+}
+
+func (w *Watcher) follow(ctx context.Context, label string) error {
+	messages, errs := w.dockerManager.WatchContainerEvents(ctx, label, "kill")
+
+	for {
+		select {
+		case msg := <-messages:
+			w.handleEvent(ctx, msg)
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handleEvent checkpoints a container that was sent its stop signal, as
+// docker stop does before the stop timeout runs out. Docker reports the kill
+// only after the signal was delivered, so the dump races the container's own
+// shutdown: it only succeeds for containers that take a while to exit on the
+// signal. For a guaranteed checkpoint run docker-cr checkpoint before
+// stopping the container.
+func (w *Watcher) handleEvent(ctx context.Context, msg events.Message) {
+	name := msg.Actor.Attributes["name"]
+	signal := msg.Actor.Attributes["signal"]
+
+	// SIGKILL leaves no time to dump
+	if signal == "9" {
+		w.logger.Warnf("Container %s received SIGKILL, too late to checkpoint", name)
+		return
+	}
+
+	state, err := w.dockerManager.GetContainerState(ctx, msg.Actor.ID)
+	if err != nil {
+		w.logger.Warnf("Cannot checkpoint %s on stop: %v", name, err)
+		return
+	}
+	stopSignal := ""
+	if state.Config != nil {
+		stopSignal = state.Config.StopSignal
+	}
+	// Other signals, e.g. SIGHUP to reload, do not stop the container
+	if !IsStopSignal(signal, stopSignal) {
+		w.logger.Debugf("Ignoring signal %s sent to %s", signal, name)
+		return
+	}
+
+	w.logger.Infof("Container %s is being stopped (signal %s), checkpointing", name, signal)
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if _, err := w.checkpointContainer(ctx, msg.Actor.ID, "stop"); err != nil {
+			w.logger.Errorf("Checkpoint of %s on stop failed: %v", name, err)
+		}
+	}()
+}
+
+// IsStopSignal reports whether signal, as numbered in a Docker kill event,
+// is the stop signal a container is configured with, e.g. "SIGTERM", "TERM"
+// or "15". An empty stopSignal is Docker's default, SIGTERM.
+func IsStopSignal(signal, stopSignal string) bool {
+	if stopSignal == "" {
+		stopSignal = "SIGTERM"
+	}
+
+	number, err := strconv.Atoi(stopSignal)
+	if err != nil {
+		sig, ok := stopSignals[strings.TrimPrefix(strings.ToUpper(stopSignal), "SIG")]
+		if !ok {
+			return false
+		}
+		number = int(sig)
+	}
+	return signal == strconv.Itoa(number)
+}
+
+// Signals containers are commonly stopped with, by name without "SIG"
+var stopSignals = map[string]syscall.Signal{
+	"TERM":  syscall.SIGTERM,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"HUP":   syscall.SIGHUP,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+	"PWR":   syscall.SIGPWR,
+	"KILL":  syscall.SIGKILL,
+}
+
+// RestoreName is the name a pending checkpoint of container is restored as
+// at the given time, unique across repeated shutdowns.
+func RestoreName(container string, at time.Time) string {
+	return fmt.Sprintf("%s-restored-%s", container, at.UTC().Format("20060102T150405Z"))
+}
+
+// CheckpointAll checkpoints every running labelled container. It is meant to
+// be called when the host is shutting down, before Docker stops containers,
+// and records each checkpoint for automatic restore.
+func (w *Watcher) CheckpointAll(ctx context.Context, reason string) error {
+	containers, err := w.dockerManager.ListRunningContainers(ctx, LabelCheckpointOnStop+"=true")
+	if err != nil {
+		return err
+	}
+
+	w.logger.Infof("Checkpointing %d container(s) before %s", len(containers), reason)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		pending []PendingRestore
+		failed  int
+	)
+
+	for id, name := range containers {
+		wg.Add(1)
+		go func(id, name string) {
+			defer wg.Done()
+
+			checkpointDir, err := w.checkpointContainer(ctx, id, "shutdown")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				w.logger.Errorf("Checkpoint of %s before %s failed: %v", name, reason, err)
+				failed++
+				return
+			}
+			pending = append(pending, PendingRestore{
+				Container:  name,
+				Checkpoint: checkpointDir,
+				Reason:     reason,
+				CreatedAt:  utils.GetCurrentTimestamp(),
+			})
+		}(id, name)
+	}
+	wg.Wait()
+
+	if err := w.addPending(pending); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checkpoints failed", failed, len(containers))
+	}
+	return nil
+}
+
+func (w *Watcher) checkpointContainer(ctx context.Context, containerID, reason string) (string, error) {
+	w.mu.Lock()
+	if w.inFlight[containerID] {
+		w.mu.Unlock()
+		return "", fmt.Errorf("checkpoint already in progress")
+	}
+	w.inFlight[containerID] = true
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		delete(w.inFlight, containerID)
+		w.mu.Unlock()
+	}()
+
+	state, err := w.dockerManager.GetContainerState(ctx, containerID)
+	if err != nil {
+		return "", err
+	}
+
+	config := checkpoint.CheckpointConfig{
+		OutputDir:      w.config.OutputDir,
+		CheckpointName: fmt.Sprintf("%s-%s", reason, time.Now().UTC().Format("20060102T150405Z")),
+		LeaveRunning:   true,
+		TcpEstablished: w.config.TcpEstablished,
+		FileLocks:      w.config.FileLocks,
+		LogLevel:       4,
+		ManageCgroups:  w.config.ManageCgroups,
+	}
+
+	if err := w.checkpointManager.Checkpoint(ctx, containerID, config); err != nil {
+		return "", err
+	}
+
+	checkpointDir := filepath.Join(w.config.OutputDir, state.Name, config.CheckpointName)
+	w.logger.Infof("Checkpointed %s to %s", state.Name, checkpointDir)
+	return checkpointDir, nil
+}
+
+// RestorePending restores checkpoints recorded by CheckpointAll whose
+// container is not running (again).
+func (w *Watcher) RestorePending(ctx context.Context) {
+	state, err := w.loadState()
+	if err != nil {
+		w.logger.Warnf("Failed to load watch state: %v", err)
+		return
+	}
+
+	var remaining []PendingRestore
+	for _, pending := range state.Pending {
+		if _, err := w.dockerManager.GetContainerState(ctx, pending.Container); err == nil {
+			w.logger.Infof("Container %s is already running, dropping pending restore", pending.Container)
+			continue
+		}
+
+		restoreConfig, err := w.restoreManager.GetRestoreOptions(pending.Checkpoint)
+		if err != nil {
+			w.logger.Errorf("Cannot restore %s: %v", pending.Container, err)
+			remaining = append(remaining, pending)
+			continue
+		}
+
+		restoreConfig.NewContainerName = RestoreName(pending.Container, time.Now())
+		w.logger.Infof("Restoring %s from %s as %s", pending.Container, pending.Checkpoint, restoreConfig.NewContainerName)
+		if err := w.restoreManager.Restore(ctx, *restoreConfig); err != nil {
+			w.logger.Errorf("Automatic restore of %s failed: %v", pending.Container, err)
+			remaining = append(remaining, pending)
+		}
+	}
+
+	state.Pending = remaining
+	if err := w.saveState(state); err != nil {
+		w.logger.Warnf("Failed to save watch state: %v", err)
+	}
+}
+
+func (w *Watcher) addPending(pending []PendingRestore) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	state, err := w.loadState()
+	if err != nil {
+		return err
+	}
+
+	state.Pending = append(state.Pending, pending...)
+	return w.saveState(state)
+}
+
+func (w *Watcher) loadState() (*watchState, error) {
+	stateFile := filepath.Join(w.config.OutputDir, stateFileName)
+	if !utils.FileExists(stateFile) {
+		return &watchState{}, nil
+	}
+
+	data, err := utils.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var state watchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse watch state: %w", err)
+	}
+
+	return &state, nil
+}
+
+func (w *Watcher) saveState(state *watchState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal watch state: %w", err)
+	}
+
+	return utils.WriteFile(filepath.Join(w.config.OutputDir, stateFileName), data)
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"docker-cr/pkg/docker"
+	"os"
+	"testing"
+)
+
+func TestRelocateCgroupPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/system.slice/docker-abc123.scope", "/system.slice/docker-def456.scope"},
+		{"/docker/abc123", "/docker/def456"},
+	}
+
+	for _, tt := range tests {
+		got, err := docker.RelocateCgroupPath(tt.path, "abc123", "def456")
+		if err != nil {
+			t.Fatalf("RelocateCgroupPath(%s) failed: %v", tt.path, err)
+		}
+		if got != tt.expected {
+			t.Errorf("RelocateCgroupPath(%s) = %s, expected %s", tt.path, got, tt.expected)
+		}
+	}
+
+	if _, err := docker.RelocateCgroupPath("/custom/parent", "abc123", "def456"); err == nil {
+		t.Error("Expected a path without the container ID to be rejected")
+	}
+}
+
+func TestDetectCgroupMode(t *testing.T) {
+	if _, err := os.Stat("/sys/fs/cgroup"); err != nil {
+		t.Skip("No cgroup filesystem mounted")
+	}
+
+	mode, err := docker.DetectCgroupMode()
+	if err != nil {
+		t.Fatalf("DetectCgroupMode failed: %v", err)
+	}
+
+	switch mode {
+	case docker.CgroupModeV1, docker.CgroupModeV2, docker.CgroupModeHybrid:
+	default:
+		t.Fatalf("DetectCgroupMode returned unknown mode %q", mode)
+	}
+
+	// The unified hierarchy exposes cgroup.controllers at its root
+	_, statErr := os.Stat("/sys/fs/cgroup/cgroup.controllers")
+	if (mode == docker.CgroupModeV2) != (statErr == nil) {
+		t.Errorf("DetectCgroupMode returned %s, cgroup.controllers at the root: %v", mode, statErr == nil)
+	}
+}
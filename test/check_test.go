@@ -0,0 +1,52 @@
+package test
+
+import (
+	"docker-cr/pkg/check"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestAnalyzeProcessFlags(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("Cannot listen on loopback: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	lockFile, err := os.Create(filepath.Join(t.TempDir(), "lock"))
+	if err != nil {
+		t.Fatalf("Failed to create lock file: %v", err)
+	}
+	defer lockFile.Close()
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatalf("Failed to lock: %v", err)
+	}
+
+	report, err := check.AnalyzeProcess(os.Getpid(), nil)
+	if err != nil {
+		t.Fatalf("AnalyzeProcess failed: %v", err)
+	}
+
+	flags := make(map[string]bool)
+	for _, flag := range report.Flags {
+		flags[flag] = true
+	}
+	if !flags["--tcp"] {
+		t.Errorf("Expected --tcp for an established connection, got %v", report.Flags)
+	}
+	if !flags["--file-locks"] {
+		t.Errorf("Expected --file-locks for a held lock, got %v", report.Flags)
+	}
+	if report.Verdict == check.VerdictReady {
+		t.Errorf("Expected a verdict requiring flags, got %s", report.Verdict)
+	}
+}
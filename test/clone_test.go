@@ -0,0 +1,92 @@
+package test
+
+import (
+	"docker-cr/pkg/restore"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplicaConfig(t *testing.T) {
+	config := restore.CloneConfig{
+		NamePrefix: "web-",
+		WorkDir:    "/var/lib/clones",
+		Restore: restore.RestoreConfig{
+			Publish:        []string{"80"},
+			KeepPorts:      true,
+			TcpEstablished: true,
+		},
+	}
+
+	names := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		result, restoreConfig := restore.ReplicaConfig(config, i)
+
+		if names[result.Name] {
+			t.Errorf("Replica name %s used twice", result.Name)
+		}
+		names[result.Name] = true
+
+		if result.WorkDir != filepath.Join(config.WorkDir, result.Name) {
+			t.Errorf("Replica %s has work directory %s", result.Name, result.WorkDir)
+		}
+		if restoreConfig.NewContainerName != result.Name || restoreConfig.Hostname != result.Name {
+			t.Errorf("Replica %s restores as %s with hostname %s", result.Name, restoreConfig.NewContainerName, restoreConfig.Hostname)
+		}
+		if restoreConfig.KeepPorts {
+			t.Errorf("Replica %s keeps the host ports of the source", result.Name)
+		}
+		if !restoreConfig.TcpClose {
+			t.Errorf("Replica %s restores the connections of the source", result.Name)
+		}
+		if len(restoreConfig.Publish) != 1 {
+			t.Errorf("Replica %s lost its published ports", result.Name)
+		}
+	}
+
+	if !names["web-1"] || !names["web-3"] {
+		t.Errorf("Expected replicas web-1..web-3, got %v", names)
+	}
+	if !config.Restore.KeepPorts || config.Restore.TcpClose {
+		t.Errorf("ReplicaConfig modified the template")
+	}
+}
+
+func TestPrepareReplicaDir(t *testing.T) {
+	output := t.TempDir()
+	parent := writeFakeCheckpoint(t, output, "web", "cp1", time.Now().Add(-time.Hour), nil, "")
+	child := writeFakeCheckpoint(t, output, "web", "cp2", time.Now(), nil, "cp1")
+
+	replicaDir, err := restore.PrepareReplicaDir(child, filepath.Join(t.TempDir(), "web-1"))
+	if err != nil {
+		t.Fatalf("PrepareReplicaDir failed: %v", err)
+	}
+
+	// The replica has its own images directory sharing the image files
+	imagesDir := filepath.Join(replicaDir, "images")
+	if info, err := os.Lstat(imagesDir); err != nil || !info.IsDir() {
+		t.Fatalf("Expected a real images directory, got %v (%v)", info, err)
+	}
+	original, _ := os.Stat(filepath.Join(child, "images", "pages-1.img"))
+	linked, err := os.Stat(filepath.Join(imagesDir, "pages-1.img"))
+	if err != nil || !os.SameFile(original, linked) {
+		t.Errorf("Expected pages-1.img to be hard-linked to the checkpoint's, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(replicaDir, "checkpoint_metadata.json")); err != nil {
+		t.Errorf("Metadata was not copied: %v", err)
+	}
+
+	// The parent link still leads to the parent checkpoint, not to a
+	// sibling of the replica
+	link, err := os.Readlink(filepath.Join(imagesDir, "parent"))
+	if err != nil {
+		t.Fatalf("Parent link missing: %v", err)
+	}
+	if link != filepath.Join(parent, "images") {
+		t.Errorf("Parent link points to %s, expected %s", link, filepath.Join(parent, "images"))
+	}
+	if _, err := os.Stat(filepath.Join(imagesDir, "parent", "pages-1.img")); err != nil {
+		t.Errorf("Parent images not reachable from the replica: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package test
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCRIUCancelled(t *testing.T) {
+	criuManager := checkpoint.NewCRIUManager(setupTestLogger())
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A cancelled operation never starts a CRIU worker
+	err := criuManager.CheckpointProcess(ctx, 0, checkpoint.CheckpointOptions{
+		ImagesDir: filepath.Join(dir, "images"),
+		WorkDir:   dir,
+		LogFile:   "dump.log",
+		LogLevel:  4,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected checkpoint to be cancelled, got %v", err)
+	}
+
+	err = criuManager.RestoreProcess(ctx, checkpoint.RestoreOptions{
+		ImagesDir: filepath.Join(dir, "images"),
+		WorkDir:   dir,
+		LogFile:   "restore.log",
+		LogLevel:  4,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected restore to be cancelled, got %v", err)
+	}
+}
+
+func TestProcessCgroups(t *testing.T) {
+	if _, err := os.Stat("/proc/self/cgroup"); err != nil {
+		t.Skip("No cgroup information available")
+	}
+
+	cgroups, err := docker.ProcessCgroups(os.Getpid())
+	if err != nil {
+		t.Fatalf("ProcessCgroups failed: %v", err)
+	}
+	if len(cgroups) == 0 {
+		t.Fatal("Expected at least one cgroup")
+	}
+	for controller, path := range cgroups {
+		if len(path) == 0 || path[0] != '/' {
+			t.Errorf("Controller %q has invalid path %q", controller, path)
+		}
+	}
+
+	if _, err := docker.ProcessCgroups(-1); err == nil {
+		t.Error("Expected an error for a missing process")
+	}
+}
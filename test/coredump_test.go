@@ -0,0 +1,172 @@
+package test
+
+import (
+	"bytes"
+	"debug/elf"
+	"docker-cr/pkg/inspect"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/checkpoint-restore/go-criu/v7/crit"
+	core_x86 "github.com/checkpoint-restore/go-criu/v7/crit/images/core-x86"
+	criu_core "github.com/checkpoint-restore/go-criu/v7/crit/images/criu-core"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/mm"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/pagemap"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/pstree"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/vma"
+	"google.golang.org/protobuf/proto"
+)
+
+func writeTestImage(t *testing.T, path, magic string, entries ...proto.Message) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+
+	img := &crit.CriuImage{Magic: magic}
+	for _, entry := range entries {
+		img.Entries = append(img.Entries, &crit.CriuEntry{Message: entry})
+	}
+	if err := crit.New(nil, file, "", false, false).Encode(img); err != nil {
+		t.Fatalf("Failed to encode %s: %v", path, err)
+	}
+}
+
+func TestGenerateCoreDump(t *testing.T) {
+	dir := t.TempDir()
+	imagesDir := filepath.Join(dir, "images")
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+
+	pageSize := uint64(os.Getpagesize())
+	zero32, zero64 := proto.Uint32(0), proto.Uint64(0)
+	ip := proto.Uint64(0x401234)
+
+	writeTestImage(t, filepath.Join(imagesDir, "pstree.img"), "PSTREE", &pstree.PstreeEntry{
+		Pid: proto.Uint32(1), Ppid: zero32, Pgid: proto.Uint32(1), Sid: proto.Uint32(1), Threads: []uint32{1},
+	})
+	writeTestImage(t, filepath.Join(imagesDir, "core-1.img"), "CORE", &criu_core.CoreEntry{
+		Mtype: criu_core.CoreEntry_X86_64.Enum(),
+		ThreadInfo: &core_x86.ThreadInfoX86{
+			ClearTidAddr: zero64,
+			Gpregs: &core_x86.UserX86RegsEntry{
+				R15: zero64, R14: zero64, R13: zero64, R12: zero64, Bp: zero64, Bx: zero64,
+				R11: zero64, R10: zero64, R9: zero64, R8: zero64, Ax: zero64, Cx: zero64,
+				Dx: zero64, Si: zero64, Di: zero64, OrigAx: zero64, Ip: ip, Cs: zero64,
+				Flags: zero64, Sp: zero64, Ss: zero64, FsBase: zero64, GsBase: zero64,
+				Ds: zero64, Es: zero64, Fs: zero64, Gs: zero64,
+			},
+			Fpregs: &core_x86.UserX86FpregsEntry{
+				Cwd: zero32, Swd: zero32, Twd: zero32, Fop: zero32,
+				Rip: zero64, Rdp: zero64, Mxcsr: zero32, MxcsrMask: zero32,
+			},
+		},
+		Tc: &criu_core.TaskCoreEntry{
+			TaskState: proto.Uint32(1), ExitCode: zero32, Personality: zero32,
+			Flags: zero32, BlkSigset: zero64, Comm: proto.String("app"),
+		},
+	})
+
+	// One VMA with dumped pages, one without, and a private file mapping
+	// with only its middle page dumped
+	newVMA := func(start, end uint64, status uint32) *vma.VmaEntry {
+		return &vma.VmaEntry{
+			Start: proto.Uint64(start), End: proto.Uint64(end), Pgoff: zero64, Shmid: zero64,
+			Prot: proto.Uint32(3), Flags: zero32, Status: proto.Uint32(status), Fd: proto.Int64(-1),
+		}
+	}
+	writeTestImage(t, filepath.Join(imagesDir, "mm-1.img"), "MM", &mm.MmEntry{
+		MmStartCode: zero64, MmEndCode: zero64, MmStartData: zero64, MmEndData: zero64,
+		MmStartStack: zero64, MmStartBrk: zero64, MmBrk: zero64,
+		MmArgStart: zero64, MmArgEnd: zero64, MmEnvStart: zero64, MmEnvEnd: zero64,
+		ExeFileId:   zero32,
+		MmSavedAuxv: []uint64{6, pageSize, 0, 0},
+		Vmas: []*vma.VmaEntry{
+			newVMA(0x400000, 0x400000+2*pageSize, 1),
+			newVMA(0x600000, 0x600000+pageSize, 1),
+			newVMA(0x700000, 0x700000+3*pageSize, 1|1<<6),
+		},
+	})
+	writeTestImage(t, filepath.Join(imagesDir, "pagemap-1.img"), "PAGEMAP",
+		&pagemap.PagemapHead{PagesId: proto.Uint32(1)},
+		&pagemap.PagemapEntry{Vaddr: proto.Uint64(0x400000), NrPages: proto.Uint32(2), Flags: proto.Uint32(1 << 2)},
+		&pagemap.PagemapEntry{Vaddr: proto.Uint64(0x700000 + pageSize), NrPages: proto.Uint32(1), Flags: proto.Uint32(1 << 2)},
+	)
+
+	pages := make([]byte, 3*pageSize)
+	copy(pages, "hello core")
+	copy(pages[2*pageSize:], "patched page")
+	if err := os.WriteFile(filepath.Join(imagesDir, "pages-1.img"), pages, 0644); err != nil {
+		t.Fatalf("Failed to write pages: %v", err)
+	}
+
+	outputDir := filepath.Join(dir, "cores")
+	dumps, err := inspect.NewAnalyzer(setupTestLogger()).GenerateCoreDumps(dir, 1, outputDir)
+	if err != nil {
+		t.Fatalf("GenerateCoreDumps failed: %v", err)
+	}
+	if len(dumps) != 1 || dumps[0].Command != "app" {
+		t.Fatalf("Unexpected dumps: %+v", dumps)
+	}
+
+	core, err := elf.Open(filepath.Join(outputDir, "core.1"))
+	if err != nil {
+		t.Fatalf("Failed to open core: %v", err)
+	}
+	defer core.Close()
+
+	if core.Type != elf.ET_CORE || core.Machine != elf.EM_X86_64 {
+		t.Fatalf("Expected an x86_64 core, got %v %v", core.Type, core.Machine)
+	}
+	if len(core.Progs) != 6 || core.Progs[0].Type != elf.PT_NOTE {
+		t.Fatalf("Expected a note and five load segments, got %d segments", len(core.Progs))
+	}
+
+	data, err := io.ReadAll(core.Progs[1].Open())
+	if err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+	if core.Progs[1].Vaddr != 0x400000 || !bytes.Equal(data, pages[:2*pageSize]) {
+		t.Errorf("First segment does not hold the dumped pages")
+	}
+	if core.Progs[2].Filesz != 0 || core.Progs[2].Memsz != pageSize {
+		t.Errorf("Segment without dumped pages should be empty in the file, got filesz %d memsz %d",
+			core.Progs[2].Filesz, core.Progs[2].Memsz)
+	}
+
+	// Only the dumped page of the file mapping is in the core, the pages
+	// around it are left to the mapped file
+	for i, expected := range []struct {
+		vaddr  uint64
+		filesz uint64
+	}{{0x700000, 0}, {0x700000 + pageSize, pageSize}, {0x700000 + 2*pageSize, 0}} {
+		prog := core.Progs[3+i]
+		if prog.Vaddr != expected.vaddr || prog.Filesz != expected.filesz || prog.Memsz != pageSize {
+			t.Errorf("File mapping segment %d: vaddr %#x filesz %d memsz %d", i, prog.Vaddr, prog.Filesz, prog.Memsz)
+		}
+	}
+	data, err = io.ReadAll(core.Progs[4].Open())
+	if err != nil || !bytes.Equal(data, pages[2*pageSize:]) {
+		t.Errorf("File mapping segment does not hold the dumped page")
+	}
+
+	// The first note is the main thread's NT_PRSTATUS; rip is the 17th register
+	notes, err := io.ReadAll(core.Progs[0].Open())
+	if err != nil {
+		t.Fatalf("Failed to read notes: %v", err)
+	}
+	if binary.LittleEndian.Uint32(notes[8:]) != uint32(elf.NT_PRSTATUS) {
+		t.Fatalf("Expected NT_PRSTATUS first")
+	}
+	desc := notes[20:]
+	if rip := binary.LittleEndian.Uint64(desc[112+16*8:]); rip != *ip {
+		t.Errorf("Expected rip %#x, got %#x", *ip, rip)
+	}
+}
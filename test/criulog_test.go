@@ -0,0 +1,51 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"errors"
+	"testing"
+)
+
+func TestAnalyzeCRIULog(t *testing.T) {
+	log := `(00.000012) Version: 3.19 (gitid 0)
+(00.004521) Dumping mounts
+(00.012877) Error (criu/sk-inet.c:188): inet: Connected TCP socket, consider using --tcp-established option.
+(00.013002) Error (criu/cr-dump.c:1688): Dump files (pid: 1234) failed with -1
+`
+	failure := errors.New("exit status 1")
+
+	criuErr := checkpoint.AnalyzeCRIULog("dump", []byte(log), failure)
+	if !criuErr.Known || criuErr.Hint != "add --tcp" {
+		t.Errorf("Expected the TCP signature, got %+v", criuErr)
+	}
+	if len(criuErr.Errors) != 2 {
+		t.Errorf("Expected 2 error lines, got %d", len(criuErr.Errors))
+	}
+	if !errors.Is(criuErr, failure) {
+		t.Error("Expected CRIUError to wrap the original error")
+	}
+
+	log = `(00.021000)      1: Error (criu/mount.c:2011): mnt: Can't mount at ./data: No such file or directory
+`
+	criuErr = checkpoint.AnalyzeCRIULog("restore", []byte(log), failure)
+	if criuErr.Hint != "create the mount source, or use --remap-mount /data=<host-path>" {
+		t.Errorf("Unexpected hint for a missing mount point: %q", criuErr.Hint)
+	}
+	if criuErr.Errors[0].PID != 1 {
+		t.Errorf("Expected PID 1, got %d", criuErr.Errors[0].PID)
+	}
+
+	log = `(00.003000) Error (criu/mount.c:1412): mnt: FS mnt /mnt/fuse dev 0x2c root / unsupported id 812
+`
+	criuErr = checkpoint.AnalyzeCRIULog("dump", []byte(log), failure)
+	if criuErr.Hint != "bind-mount /mnt/fuse into the container from the host so it is dumped as an external mount" {
+		t.Errorf("Unexpected hint for an unsupported mount: %q", criuErr.Hint)
+	}
+
+	log = `(00.001000) Error (criu/foo.c:1): something new went wrong
+`
+	criuErr = checkpoint.AnalyzeCRIULog("dump", []byte(log), failure)
+	if criuErr.Known || criuErr.Cause != "something new went wrong" {
+		t.Errorf("Expected the first error as cause, got %+v", criuErr)
+	}
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/inspect"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func writeTestCheckpoint(t *testing.T, dir string, env map[string]string, mounts []docker.MountMapping, pagesSize int) {
+	t.Helper()
+
+	metadata := checkpoint.CheckpointMetadata{
+		ContainerState: &docker.ContainerState{
+			ID:          "0123456789abcdef",
+			Name:        "web",
+			Config:      &container.Config{Cmd: []string{"nginx"}},
+			Environment: env,
+		},
+		MountMappings: mounts,
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("Failed to marshal metadata: %v", err)
+	}
+
+	imagesDir := filepath.Join(dir, "images")
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		t.Fatalf("Failed to create images dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "checkpoint_metadata.json"), data, 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	data, err = json.Marshal(mounts)
+	if err != nil {
+		t.Fatalf("Failed to marshal mount mappings: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mount_mappings.json"), data, 0644); err != nil {
+		t.Fatalf("Failed to write mount mappings: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imagesDir, "pages-1.img"), make([]byte, pagesSize), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+}
+
+func TestDiffCheckpoints(t *testing.T) {
+	base := t.TempDir()
+	from := filepath.Join(base, "a")
+	to := filepath.Join(base, "b")
+
+	writeTestCheckpoint(t, from, map[string]string{"MODE": "blue", "OLD": "1"},
+		[]docker.MountMapping{{ContainerPath: "/data", HostPath: "/srv/data", Type: "bind"}}, 4096)
+	writeTestCheckpoint(t, to, map[string]string{"MODE": "green", "NEW": "1"},
+		[]docker.MountMapping{{ContainerPath: "/data", HostPath: "/srv/data2", Type: "bind"}}, 12288)
+
+	diff, err := inspect.NewAnalyzer(setupTestLogger()).DiffCheckpoints(from, to)
+	if err != nil {
+		t.Fatalf("DiffCheckpoints failed: %v", err)
+	}
+
+	changes := make(map[string]string)
+	for _, c := range diff.Environment {
+		changes[c.Key] = c.Change
+	}
+	if changes["MODE"] != inspect.ChangeChanged || changes["OLD"] != inspect.ChangeRemoved || changes["NEW"] != inspect.ChangeAdded {
+		t.Errorf("Unexpected environment changes: %+v", diff.Environment)
+	}
+
+	if len(diff.Mounts) != 1 || diff.Mounts[0].Change != inspect.ChangeChanged {
+		t.Errorf("Expected /data to be changed, got %+v", diff.Mounts)
+	}
+
+	if len(diff.Images) != 1 || diff.Images[0].Delta != 8192 || diff.ImagesDelta != 8192 {
+		t.Errorf("Expected pages-1.img to grow by 8192 bytes, got %+v", diff.Images)
+	}
+}
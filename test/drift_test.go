@@ -0,0 +1,88 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/inspect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestCompareDrift(t *testing.T) {
+	analysis := &inspect.CheckpointAnalysis{
+		Metadata: &checkpoint.CheckpointMetadata{
+			ContainerState: &docker.ContainerState{
+				Name:        "web",
+				Image:       "nginx:1.25",
+				Config:      &container.Config{Cmd: []string{"nginx"}},
+				Environment: map[string]string{"MODE": "blue", "OLD": "1"},
+			},
+		},
+		MountMappings: []docker.MountMapping{{ContainerPath: "/data", HostPath: "/srv/data", Type: "bind"}},
+		FromImages:    true,
+		ProcessTree: &inspect.ProcessInfo{
+			PID:     1,
+			Command: "nginx",
+			Sockets: []inspect.SocketInfo{{Protocol: "tcp", State: "LISTEN", LocalPort: 80}},
+			Children: []inspect.ProcessInfo{
+				{PID: 2, Command: "nginx"},
+				{PID: 3, Command: "nginx"},
+				{PID: 4, Command: "cron"},
+			},
+		},
+	}
+
+	live := inspect.LiveState{
+		State: &docker.ContainerState{
+			Name:        "web",
+			Image:       "nginx:1.27",
+			Config:      &container.Config{Cmd: []string{"nginx"}},
+			Environment: map[string]string{"MODE": "green", "NEW": "1"},
+			ProcessPID:  4242,
+		},
+		Mounts:   []docker.MountMapping{{ContainerPath: "/data", HostPath: "/srv/data", Type: "bind"}},
+		Commands: []string{"nginx", "nginx", "sh"},
+		Ports:    map[string]bool{"tcp/80": true, "tcp/8080": true},
+	}
+
+	report := inspect.CompareDrift("/checkpoints/web/cp1", analysis, live)
+	if !report.HasDrift() {
+		t.Fatal("Expected drift to be detected")
+	}
+
+	if len(report.Config) != 1 || report.Config[0].Key != "image" || report.Config[0].New != "nginx:1.27" {
+		t.Errorf("Expected only the image to change, got %+v", report.Config)
+	}
+
+	env := make(map[string]string)
+	for _, c := range report.Environment {
+		env[c.Key] = c.Change
+	}
+	if env["MODE"] != inspect.ChangeChanged || env["OLD"] != inspect.ChangeRemoved || env["NEW"] != inspect.ChangeAdded || len(env) != 3 {
+		t.Errorf("Unexpected environment changes: %+v", report.Environment)
+	}
+
+	if len(report.Mounts) != 0 {
+		t.Errorf("Expected unchanged mounts, got %+v", report.Mounts)
+	}
+
+	processes := make(map[string]string)
+	for _, c := range report.Processes {
+		processes[c.Command] = c.Change
+	}
+	if processes["nginx"] != inspect.ChangeChanged || processes["cron"] != inspect.ChangeRemoved || processes["sh"] != inspect.ChangeAdded {
+		t.Errorf("Unexpected process changes: %+v", report.Processes)
+	}
+
+	if len(report.Ports) != 1 || report.Ports[0].Key != "tcp/8080" || report.Ports[0].Change != inspect.ChangeAdded {
+		t.Errorf("Expected only tcp/8080 to be added, got %+v", report.Ports)
+	}
+
+	// A stopped container is compared on its configuration only
+	live.State.ProcessPID = 0
+	report = inspect.CompareDrift("/checkpoints/web/cp1", analysis, live)
+	if len(report.Processes) != 0 || len(report.Ports) != 0 || len(report.Skipped) != 1 {
+		t.Errorf("Expected processes and ports to be skipped, got %+v", report)
+	}
+}
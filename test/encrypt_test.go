@@ -0,0 +1,102 @@
+package test
+
+import (
+	"bytes"
+	"docker-cr/pkg/checkpoint"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestKey(t *testing.T, dir, name string, fill byte) checkpoint.EncryptionKey {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(bytes.Repeat([]byte{fill}, 32))+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return checkpoint.EncryptionKey{KeyFile: path}
+}
+
+func TestEncryptCheckpoint(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "web", "cp1")
+	// Several chunks of the encrypted stream
+	writeTestCheckpoint(t, dir, map[string]string{"API_TOKEN": "abc123"}, nil, 3<<20+17)
+	pages, err := os.ReadFile(filepath.Join(dir, "images", "pages-1.img"))
+	if err != nil {
+		t.Fatalf("Failed to read pages: %v", err)
+	}
+	copy(pages, "process memory")
+	if err := os.WriteFile(filepath.Join(dir, "images", "pages-1.img"), pages, 0644); err != nil {
+		t.Fatalf("Failed to write pages: %v", err)
+	}
+
+	manager := checkpoint.NewManager(nil, setupTestLogger())
+	key := writeTestKey(t, base, "key", 1)
+	if err := manager.EncryptCheckpoint(dir, key); err != nil {
+		t.Fatalf("EncryptCheckpoint failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read checkpoint: %v", err)
+	}
+	if len(entries) != 2 || !checkpoint.IsEncrypted(dir) {
+		t.Fatalf("Expected only the encrypted archive and its description, got %d entries", len(entries))
+	}
+	info, err := manager.GetCheckpointInfo(dir)
+	if err != nil || info.ContainerState.Name != "web" {
+		t.Fatalf("Expected the container name of an encrypted checkpoint, got %+v, %v", info, err)
+	}
+
+	decrypted, cleanup, err := manager.DecryptCheckpoint(dir, key)
+	if err != nil {
+		t.Fatalf("DecryptCheckpoint failed: %v", err)
+	}
+	restored, err := os.ReadFile(filepath.Join(decrypted, "images", "pages-1.img"))
+	if err != nil || !bytes.Equal(restored, pages) {
+		t.Errorf("Decrypted pages differ from the original")
+	}
+	if stat, err := os.Stat(filepath.Dir(decrypted)); err != nil || stat.Mode().Perm() != 0700 {
+		t.Errorf("Decrypted checkpoint should be private, got %v", stat.Mode())
+	}
+	if _, err := os.Stat(filepath.Join(decrypted, "mount_mappings.json")); err != nil {
+		t.Errorf("Decrypted checkpoint is incomplete: %v", err)
+	}
+	cleanup()
+	if _, err := os.Stat(decrypted); !os.IsNotExist(err) {
+		t.Errorf("cleanup should remove the decrypted checkpoint")
+	}
+
+	if _, _, err := manager.DecryptCheckpoint(dir, writeTestKey(t, base, "other", 2)); err == nil {
+		t.Errorf("Decrypting with the wrong key should fail")
+	}
+
+	archive := filepath.Join(dir, "checkpoint.tar.enc")
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	if bytes.Contains(data, []byte("process memory")) {
+		t.Errorf("Archive holds plain text memory")
+	}
+
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)/2] ^= 0xff
+	if err := os.WriteFile(archive, tampered, 0600); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if _, _, err := manager.DecryptCheckpoint(dir, key); err == nil {
+		t.Errorf("Decrypting a modified archive should fail")
+	}
+
+	// Dropping the last chunk must not go unnoticed
+	if err := os.WriteFile(archive, data[:len(data)-2<<20], 0600); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if _, _, err := manager.DecryptCheckpoint(dir, key); err == nil {
+		t.Errorf("Decrypting a truncated archive should fail")
+	}
+}
@@ -0,0 +1,39 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"testing"
+)
+
+func TestGroupRestoreOrder(t *testing.T) {
+	manifest := &checkpoint.GroupManifest{
+		Members: []checkpoint.GroupMember{
+			{Container: "shop-web-1", Service: "web", DependsOn: []string{"api"}},
+			{Container: "shop-api-1", Service: "api", DependsOn: []string{"db", "cache"}},
+			{Container: "shop-db-1", Service: "db"},
+			{Container: "shop-cache-1", Service: "cache", DependsOn: []string{"external"}},
+		},
+	}
+
+	order, err := manifest.RestoreOrder()
+	if err != nil {
+		t.Fatalf("RestoreOrder failed: %v", err)
+	}
+
+	position := make(map[string]int)
+	for i, member := range order {
+		position[member.Service] = i
+	}
+
+	if len(order) != 4 {
+		t.Fatalf("Expected 4 members, got %d", len(order))
+	}
+	if position["db"] > position["api"] || position["cache"] > position["api"] || position["api"] > position["web"] {
+		t.Errorf("Dependencies not restored first: %+v", order)
+	}
+
+	manifest.Members[2].DependsOn = []string{"web"}
+	if _, err := manifest.RestoreOrder(); err == nil {
+		t.Error("Expected a dependency cycle to be rejected")
+	}
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointCmdArgs(t *testing.T) {
+	criuManager := checkpoint.NewCRIUManager(setupTestLogger())
+	opts := checkpoint.CheckpointOptions{ImagesDir: "/cp/images", LogFile: "dump.log", LogLevel: 4}
+
+	// The command fallback only asks for what the caller asked for
+	args := strings.Join(criuManager.CheckpointCmdArgs(42, opts), " ")
+	for _, flag := range []string{"--tcp-established", "--file-locks", "--manage-cgroups", "--shell-job", "--leave-running"} {
+		if strings.Contains(args, flag) {
+			t.Errorf("Unrequested %s in %s", flag, args)
+		}
+	}
+	if !strings.HasPrefix(args, "dump -t 42 -D /cp/images --log-file dump.log -v4") {
+		t.Errorf("Unexpected arguments: %s", args)
+	}
+
+	opts.TcpEstablished = true
+	opts.FileLocks = true
+	opts.ManageCgroups = true
+	opts.LeaveRunning = true
+	args = strings.Join(criuManager.CheckpointCmdArgs(42, opts), " ")
+	for _, flag := range []string{"--tcp-established", "--file-locks", "--manage-cgroups=soft", "--leave-running"} {
+		if !strings.Contains(args, flag) {
+			t.Errorf("Missing %s in %s", flag, args)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "meta", "checkpoint_metadata.json")
+
+	if err := utils.WriteFileAtomic(filePath, []byte(`{"version":"1.0"}`)); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+	if err := utils.WriteFileAtomic(filePath, []byte(`{"version":"1.1"}`)); err != nil {
+		t.Fatalf("WriteFileAtomic failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil || string(data) != `{"version":"1.1"}` {
+		t.Errorf("Unexpected contents %q (%v)", data, err)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(filePath))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected only the written file, got %v (%v)", entries, err)
+	}
+}
+
+func TestHasCheckpointData(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "web", "checkpoint1")
+	if checkpoint.HasCheckpointData(dir) {
+		t.Error("A missing directory holds no checkpoint")
+	}
+
+	// A discarded attempt only leaves its CRIU log, so the name can be reused
+	if err := utils.EnsureDir(dir); err != nil {
+		t.Fatalf("Failed to create checkpoint dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "dump.log"), []byte("Error (criu/cr-dump.c)"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	if checkpoint.HasCheckpointData(dir) {
+		t.Error("A directory with only the CRIU log must be reusable")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "checkpoint_metadata.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if !checkpoint.HasCheckpointData(dir) {
+		t.Error("An existing checkpoint must not be overwritten")
+	}
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHooksRun(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "events")
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$CRTOOLS_SCRIPT_ACTION $CRTOOLS_INIT_PID $DOCKER_CR_CONTAINER\" >> " + output + "\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write hook script: %v", err)
+	}
+
+	var called []string
+	hooks := &checkpoint.Hooks{Scripts: []string{script}}
+	hooks.On(checkpoint.HookPostRestore, func(ctx context.Context, event string, pid int) error {
+		called = append(called, event)
+		return nil
+	})
+	hooks = hooks.WithEnv(map[string]string{"DOCKER_CR_CONTAINER": "web"})
+
+	if err := hooks.Run(context.Background(), checkpoint.HookPreDump, 0); err != nil {
+		t.Fatalf("pre-dump hook failed: %v", err)
+	}
+	if err := hooks.Run(context.Background(), checkpoint.HookPostRestore, 42); err != nil {
+		t.Fatalf("post-restore hook failed: %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Hook script did not run: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || lines[0] != "pre-dump  web" || lines[1] != "post-restore 42 web" {
+		t.Errorf("Unexpected hook script runs: %q", lines)
+	}
+	if len(called) != 1 || called[0] != checkpoint.HookPostRestore {
+		t.Errorf("Expected only the post-restore callback, got %v", called)
+	}
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"context"
 	"docker-cr/pkg/checkpoint"
 	"docker-cr/pkg/docker"
 	"docker-cr/pkg/restore"
@@ -40,11 +41,11 @@ func cleanupTestContainer(t *testing.T) {
 	defer dockerManager.Close()
 
 	// Stop and remove test container if it exists
-	if err := dockerManager.StopContainer(testContainerName, nil); err != nil {
+	if err := dockerManager.StopContainer(context.Background(), testContainerName, nil); err != nil {
 		t.Logf("Failed to stop test container (may not exist): %v", err)
 	}
 
-	if err := dockerManager.RemoveContainer(testContainerName); err != nil {
+	if err := dockerManager.RemoveContainer(context.Background(), testContainerName); err != nil {
 		t.Logf("Failed to remove test container (may not exist): %v", err)
 	}
 }
@@ -110,7 +111,7 @@ func TestCheckpointRestore(t *testing.T) {
 		}
 
 		// This would fail if container doesn't exist, which is expected in CI
-		err := checkpointManager.Checkpoint(testContainerName, config)
+		err := checkpointManager.Checkpoint(context.Background(), testContainerName, config)
 		if err != nil {
 			t.Logf("Expected error checkpointing non-existent container: %v", err)
 			t.Skip("Skipping rest of test - container not available")
@@ -150,7 +151,7 @@ func TestCheckpointRestore(t *testing.T) {
 			SkipMounts:       []string{},
 		}
 
-		err := restoreManager.Restore(config)
+		err := restoreManager.Restore(context.Background(), config)
 		if err != nil {
 			t.Errorf("Restore failed: %v", err)
 		}
@@ -342,7 +343,7 @@ func BenchmarkCheckpointOperations(b *testing.B) {
 }
 
 // Helper function for manual testing
-func ExampleCheckpointRestore() {
+func Example_checkpointRestore() {
 	logger := setupTestLogger()
 
 	// This is an example of how to use the API programmatically
@@ -369,7 +370,7 @@ func ExampleCheckpointRestore() {
 	}
 
 	// Perform checkpoint
-	err = checkpointManager.Checkpoint("example-container", checkpointConfig)
+	err = checkpointManager.Checkpoint(context.Background(), "example-container", checkpointConfig)
 	if err != nil {
 		logger.Errorf("Checkpoint failed: %v", err)
 		return
@@ -393,7 +394,7 @@ func ExampleCheckpointRestore() {
 	}
 
 	// Perform restore
-	err = restoreManager.Restore(restoreConfig)
+	err = restoreManager.Restore(context.Background(), restoreConfig)
 	if err != nil {
 		logger.Errorf("Restore failed: %v", err)
 		return
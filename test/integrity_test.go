@@ -0,0 +1,104 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"docker-cr/pkg/checkpoint"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestSigningKeys(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	privatePath := filepath.Join(dir, name+".pem")
+	publicPath := filepath.Join(dir, name+".pub")
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return privatePath, publicPath
+}
+
+func TestCheckpointManifest(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "cp1")
+	writeTestCheckpoint(t, dir, nil, nil, 8192)
+	if err := os.WriteFile(filepath.Join(dir, "container_metadata.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write container metadata: %v", err)
+	}
+
+	manager := checkpoint.NewManager(nil, setupTestLogger())
+	if _, err := manager.WriteManifest(dir); err != nil {
+		t.Fatalf("WriteManifest failed: %v", err)
+	}
+	signKey, trustedKey := writeTestSigningKeys(t, base, "signer")
+	_, otherKey := writeTestSigningKeys(t, base, "other")
+	if err := manager.SignManifest(dir, signKey); err != nil {
+		t.Fatalf("SignManifest failed: %v", err)
+	}
+
+	if err := manager.ValidateCheckpoint(dir); err != nil {
+		t.Fatalf("Intact checkpoint failed validation: %v", err)
+	}
+	if err := manager.VerifySignature(dir, []string{otherKey, trustedKey}); err != nil {
+		t.Errorf("Signature by a trusted key was rejected: %v", err)
+	}
+	if err := manager.VerifySignature(dir, []string{otherKey}); err == nil {
+		t.Errorf("Signature by an untrusted key was accepted")
+	}
+
+	// Files written by a restore are not part of the checkpoint
+	if err := os.WriteFile(filepath.Join(dir, "restore.log"), []byte("log"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+	if err := manager.ValidateCheckpoint(dir); err != nil {
+		t.Errorf("Restore log should not fail validation: %v", err)
+	}
+
+	pages := filepath.Join(dir, "images", "pages-1.img")
+	if err := os.Truncate(pages, 4096); err != nil {
+		t.Fatalf("Failed to truncate pages: %v", err)
+	}
+	if err := manager.ValidateCheckpoint(dir); err == nil {
+		t.Errorf("Truncated pages passed validation")
+	}
+	if err := os.Truncate(pages, 8192); err != nil {
+		t.Fatalf("Failed to restore pages size: %v", err)
+	}
+
+	extra := filepath.Join(dir, "images", "pages-2.img")
+	if err := os.WriteFile(extra, []byte("x"), 0644); err != nil {
+		t.Fatalf("Failed to write image: %v", err)
+	}
+	if err := manager.ValidateCheckpoint(dir); err == nil {
+		t.Errorf("Image missing from the manifest passed validation")
+	}
+	os.Remove(extra)
+
+	// A re-written manifest matches the files but not the signature
+	if _, err := manager.WriteManifest(dir); err != nil {
+		t.Fatalf("WriteManifest failed: %v", err)
+	}
+	if err := manager.VerifySignature(dir, []string{trustedKey}); err == nil {
+		t.Errorf("Modified manifest kept a valid signature")
+	}
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"bytes"
+	"docker-cr/pkg/inspect"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	criu_core "github.com/checkpoint-restore/go-criu/v7/crit/images/criu-core"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/fdinfo"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/fown"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/mm"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/pagemap"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/pstree"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/regfile"
+	"github.com/checkpoint-restore/go-criu/v7/crit/images/vma"
+	"google.golang.org/protobuf/proto"
+)
+
+// writeTestProcessImages writes the core, mm, pagemap and pages images of a
+// process with one anonymous VMA at start holding pages.
+func writeTestProcessImages(t *testing.T, imagesDir string, pid, pagesID uint32, start uint64, pages []byte) {
+	t.Helper()
+
+	zero32, zero64 := proto.Uint32(0), proto.Uint64(0)
+	writeTestImage(t, filepath.Join(imagesDir, fmt.Sprintf("core-%d.img", pid)), "CORE", &criu_core.CoreEntry{
+		Mtype: criu_core.CoreEntry_X86_64.Enum(),
+		Tc: &criu_core.TaskCoreEntry{
+			TaskState: proto.Uint32(1), ExitCode: zero32, Personality: zero32,
+			Flags: zero32, BlkSigset: zero64, Comm: proto.String(fmt.Sprintf("proc%d", pid)),
+		},
+	})
+	writeTestImage(t, filepath.Join(imagesDir, fmt.Sprintf("mm-%d.img", pid)), "MM", &mm.MmEntry{
+		MmStartCode: zero64, MmEndCode: zero64, MmStartData: zero64, MmEndData: zero64,
+		MmStartStack: zero64, MmStartBrk: zero64, MmBrk: zero64,
+		MmArgStart: zero64, MmArgEnd: zero64, MmEnvStart: zero64, MmEnvEnd: zero64,
+		ExeFileId: zero32,
+		Vmas: []*vma.VmaEntry{{
+			Start: proto.Uint64(start), End: proto.Uint64(start + uint64(len(pages))), Pgoff: zero64, Shmid: zero64,
+			Prot: proto.Uint32(3), Flags: zero32, Status: proto.Uint32(1), Fd: proto.Int64(-1),
+		}},
+	})
+	writeTestImage(t, filepath.Join(imagesDir, fmt.Sprintf("pagemap-%d.img", pid)), "PAGEMAP",
+		&pagemap.PagemapHead{PagesId: proto.Uint32(pagesID)},
+		&pagemap.PagemapEntry{
+			Vaddr:   proto.Uint64(start),
+			NrPages: proto.Uint32(uint32(len(pages) / os.Getpagesize())),
+			Flags:   proto.Uint32(1 << 2),
+		},
+	)
+	if err := os.WriteFile(filepath.Join(imagesDir, fmt.Sprintf("pages-%d.img", pagesID)), pages, 0644); err != nil {
+		t.Fatalf("Failed to write pages: %v", err)
+	}
+}
+
+func TestSearchMemory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCheckpoint(t, dir, nil, nil, 0)
+	imagesDir := filepath.Join(dir, "images")
+
+	// Init has a higher PID than its child, as after a PID namespace wrap
+	zero32 := proto.Uint32(0)
+	writeTestImage(t, filepath.Join(imagesDir, "pstree.img"), "PSTREE",
+		&pstree.PstreeEntry{Pid: proto.Uint32(10), Ppid: zero32, Pgid: proto.Uint32(10), Sid: proto.Uint32(10)},
+		&pstree.PstreeEntry{Pid: proto.Uint32(5), Ppid: proto.Uint32(10), Pgid: proto.Uint32(10), Sid: proto.Uint32(10)},
+	)
+	writeTestImage(t, filepath.Join(imagesDir, "files.img"), "FILES", &fdinfo.FileEntry{
+		Type: fdinfo.FdTypes_REG.Enum(),
+		Id:   zero32,
+		Reg: &regfile.RegFileEntry{
+			Id: zero32, Flags: zero32, Pos: proto.Uint64(0), Name: proto.String("/app"),
+			Fown: &fown.FownEntry{Uid: zero32, Euid: zero32, Signum: zero32, PidType: zero32, Pid: zero32},
+		},
+	})
+
+	// Two search windows of init memory, with a match across their boundary
+	token := []byte("SECRET-TOKEN")
+	initPages := make([]byte, 2<<20)
+	copy(initPages[100:], token)
+	copy(initPages[1<<20-4:], token)
+	writeTestProcessImages(t, imagesDir, 10, 1, 0x400000, initPages)
+
+	childPages := make([]byte, os.Getpagesize())
+	copy(childPages, token)
+	writeTestProcessImages(t, imagesDir, 5, 2, 0x7f0000000000, childPages)
+
+	analyzer := inspect.NewAnalyzer(setupTestLogger())
+	matches, err := analyzer.SearchMemory(dir, 0, regexp.MustCompile(string(token)), 0)
+	if err != nil {
+		t.Fatalf("SearchMemory failed: %v", err)
+	}
+
+	found := make(map[string]int)
+	for _, m := range matches {
+		found[fmt.Sprintf("%d@%s", m.PID, m.Address)]++
+	}
+	expected := []string{
+		fmt.Sprintf("10@0x%x", 0x400000+100),
+		fmt.Sprintf("10@0x%x", 0x400000+1<<20-4),
+		"5@0x7f0000000000",
+	}
+	if len(matches) != len(expected) {
+		t.Errorf("Expected %d matches, got %v", len(expected), found)
+	}
+	for _, key := range expected {
+		if found[key] != 1 {
+			t.Errorf("Expected one match at %s, got %d", key, found[key])
+		}
+	}
+
+	// pid 0 dumps from init, not from the lowest PID
+	output := filepath.Join(t.TempDir(), "vma.bin")
+	vma, err := analyzer.DumpVMA(dir, 0, 0x400010, output)
+	if err != nil {
+		t.Fatalf("DumpVMA failed: %v", err)
+	}
+	if vma.StartAddr != "0x400000" {
+		t.Errorf("Dumped VMA %s-%s, expected the one at 0x400000", vma.StartAddr, vma.EndAddr)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Failed to read dumped VMA: %v", err)
+	}
+	if !bytes.Equal(data, initPages) {
+		t.Errorf("Dumped VMA does not hold the memory of init")
+	}
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestExternalNamespaceArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []docker.SharedNamespace
+		expected   []string
+	}{
+		{"None", nil, nil},
+		{
+			"HostNet",
+			[]docker.SharedNamespace{{Type: "net", Inode: 4026531992, Owner: "host", Key: "net-ns-host"}},
+			[]string{"net[4026531992]:net-ns-host"},
+		},
+		{
+			"NetAndPid",
+			[]docker.SharedNamespace{
+				{Type: "net", Inode: 4026532300, Owner: "db", Key: "net-ns-db"},
+				{Type: "pid", Inode: 4026532301, Owner: "db", Key: "pid-ns-db"},
+			},
+			[]string{"net[4026532300]:net-ns-db", "pid[4026532301]:pid-ns-db"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkpoint.ExternalNamespaceArgs(tt.namespaces); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ExternalNamespaceArgs() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInheritFdArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []checkpoint.InheritNamespace
+		expected   []string
+	}{
+		{"None", nil, nil},
+		{
+			"One",
+			[]checkpoint.InheritNamespace{{Key: "net-ns-host", Path: "/proc/1/ns/net"}},
+			[]string{"--inherit-fd", "fd[3]:net-ns-host"},
+		},
+		{
+			"InOrder",
+			[]checkpoint.InheritNamespace{
+				{Key: "net-ns-db", Path: "/proc/42/ns/net"},
+				{Key: "pid-ns-db", Path: "/proc/42/ns/pid"},
+			},
+			[]string{"--inherit-fd", "fd[3]:net-ns-db", "--inherit-fd", "fd[4]:pid-ns-db"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkpoint.InheritFdArgs(tt.namespaces, 3); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("InheritFdArgs() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestJoinNsArgs(t *testing.T) {
+	namespaces := []checkpoint.JoinNamespace{
+		{Type: "net", Path: "/proc/42/ns/net"},
+		{Type: "uts", Path: "/proc/42/ns/uts"},
+	}
+	expected := []string{"--join-ns", "net:/proc/42/ns/net", "--join-ns", "uts:/proc/42/ns/uts"}
+
+	if got := checkpoint.JoinNsArgs(namespaces); !reflect.DeepEqual(got, expected) {
+		t.Errorf("JoinNsArgs() = %v, expected %v", got, expected)
+	}
+	if got := checkpoint.JoinNsArgs(nil); got != nil {
+		t.Errorf("JoinNsArgs(nil) = %v, expected none", got)
+	}
+}
+
+func TestSharedNamespaces(t *testing.T) {
+	hostInodes := map[string]uint64{"net": 100, "pid": 101, "ipc": 102, "uts": 103}
+	// The db container owns net 200 and pid 201
+	ownerNamespace := func(owner, nsType string) (string, uint64, error) {
+		if owner != "db" {
+			return "", 0, errors.New("no such container")
+		}
+		return "db", map[string]uint64{"net": 200, "pid": 201}[nsType], nil
+	}
+
+	tests := []struct {
+		name     string
+		inodes   map[string]uint64
+		joined   map[string]string
+		expected []docker.SharedNamespace
+	}{
+		{
+			"Private",
+			map[string]uint64{"net": 300, "pid": 301, "ipc": 302},
+			nil,
+			nil,
+		},
+		{
+			"HostNetwork",
+			map[string]uint64{"net": 100, "pid": 301, "uts": 303},
+			nil,
+			[]docker.SharedNamespace{{Type: "net", Inode: 100, Owner: "host", Key: "net-ns-host"}},
+		},
+		{
+			"JoinedContainer",
+			map[string]uint64{"net": 200, "pid": 201, "ipc": 302},
+			map[string]string{"net": "db", "pid": "db"},
+			[]docker.SharedNamespace{
+				{Type: "net", Inode: 200, Owner: "db", Key: "net-ns-db"},
+				{Type: "pid", Inode: 201, Owner: "db", Key: "pid-ns-db"},
+			},
+		},
+		{
+			"OwnerRestarted",
+			map[string]uint64{"net": 250},
+			map[string]string{"net": "db"},
+			nil,
+		},
+		{
+			"OwnerGone",
+			map[string]uint64{"net": 200},
+			map[string]string{"net": "cache"},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := docker.SharedNamespaces(tt.inodes, hostInodes, tt.joined, ownerNamespace)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("SharedNamespaces() = %+v, expected %+v", got, tt.expected)
+			}
+		})
+	}
+}
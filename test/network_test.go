@@ -0,0 +1,138 @@
+package test
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/restore"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/checkpoint-restore/go-criu/v7/crit"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestPinnedSockets(t *testing.T) {
+	sockets := []*crit.Socket{
+		{Protocol: "TCP", State: "LISTEN", SrcAddr: "172.17.0.2", SrcPort: 8080},
+		{Protocol: "TCP", State: "LISTEN", SrcAddr: "0.0.0.0", SrcPort: 80},
+		{Protocol: "TCP", State: "LISTEN", SrcAddr: "127.0.0.1", SrcPort: 6379},
+		{Protocol: "TCP", State: "ESTABLISHED", SrcAddr: "172.17.0.2", SrcPort: 80, DestAddr: "172.17.0.1", DestPort: 51000},
+		{Protocol: "UDP", SrcAddr: "172.17.0.2", SrcPort: 53},
+		{FdType: "UNIXSK", SrcAddr: "/run/app.sock"},
+	}
+
+	// Established connections are closed on an address change, so only
+	// listeners and bound datagram sockets are pinned to the old address
+	expected := []string{"TCP 172.17.0.2:8080", "UDP 172.17.0.2:53"}
+	if got := restore.PinnedSockets(sockets, []string{"172.17.0.2", ""}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("PinnedSockets() = %v, expected %v", got, expected)
+	}
+	if got := restore.PinnedSockets(sockets, nil); got != nil {
+		t.Errorf("Without addresses nothing is pinned, got %v", got)
+	}
+}
+
+func TestOriginalNetworks(t *testing.T) {
+	state := &docker.ContainerState{
+		HostConfig: &container.HostConfig{NetworkMode: "backend"},
+		NetworkConfig: map[string]*network.EndpointSettings{
+			"frontend": {IPAddress: "172.20.0.5"},
+			"backend":  {IPAddress: "172.21.0.5"},
+		},
+	}
+	if got := docker.OriginalNetworks(state); !reflect.DeepEqual(got, []string{"backend", "frontend"}) {
+		t.Errorf("Expected the primary network first, got %v", got)
+	}
+
+	// Replicas of containers without networks of their own join nothing
+	for _, mode := range []container.NetworkMode{"host", "none", "container:db"} {
+		state.HostConfig.NetworkMode = mode
+		if got := docker.OriginalNetworks(state); got != nil {
+			t.Errorf("Expected no networks for %s networking, got %v", mode, got)
+		}
+	}
+}
+
+// A container restored onto another network must really use it: the
+// restored process sees the new address, not the checkpointed one.
+func TestRestoreJoinsNewNetwork(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Skipping test - requires root privileges")
+	}
+	for _, tool := range []string{"docker", "criu"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("Skipping test - %s not found", tool)
+		}
+	}
+
+	network := "docker-cr-test-net"
+	name := "docker-cr-join-net"
+	restored := name + "-restored"
+	address := "172.31.250.50"
+
+	cleanup := func() {
+		exec.Command("docker", "rm", "-f", name, restored).Run()
+		exec.Command("docker", "network", "rm", network).Run()
+	}
+	cleanup()
+	defer cleanup()
+
+	if out, err := exec.Command("docker", "network", "create", "--subnet", "172.31.250.0/24", network).CombinedOutput(); err != nil {
+		t.Skipf("Skipping test - cannot create network: %v: %s", err, out)
+	}
+	if out, err := exec.Command("docker", "run", "-d", "--name", name, testImage, "sleep", "300").CombinedOutput(); err != nil {
+		t.Skipf("Skipping test - cannot start container: %v: %s", err, out)
+	}
+
+	logger := setupTestLogger()
+	dockerManager, err := docker.NewManager(logger)
+	if err != nil {
+		t.Fatalf("Failed to create Docker manager: %v", err)
+	}
+	defer dockerManager.Close()
+
+	checkpointManager := checkpoint.NewManager(dockerManager, logger)
+	restoreManager := restore.NewManager(dockerManager, checkpointManager, logger)
+
+	state, err := dockerManager.GetContainerState(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get container state: %v", err)
+	}
+
+	output := t.TempDir()
+	err = checkpointManager.Checkpoint(context.Background(), name, checkpoint.CheckpointConfig{
+		OutputDir:      output,
+		CheckpointName: "cp",
+		LogLevel:       4,
+	})
+	if err != nil {
+		t.Skipf("Skipping test - cannot checkpoint in this environment: %v", err)
+	}
+
+	err = restoreManager.Restore(context.Background(), restore.RestoreConfig{
+		CheckpointDir:    filepath.Join(output, state.Name, "cp"),
+		NewContainerName: restored,
+		LogLevel:         4,
+		Networks:         []string{network},
+		IPAddress:        address,
+	})
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	// CRIU restores the process with its original PID
+	fibTrie, err := os.ReadFile(fmt.Sprintf("/proc/%d/net/fib_trie", state.ProcessPID))
+	if err != nil {
+		t.Fatalf("Restored process is not running: %v", err)
+	}
+	if !strings.Contains(string(fibTrie), address) {
+		t.Errorf("Restored process does not see the new address %s", address)
+	}
+}
@@ -0,0 +1,33 @@
+package test
+
+import (
+	"docker-cr/pkg/restore"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMountRemaps(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "remap.json")
+	if err := os.WriteFile(mappingFile, []byte(`{"/data": "/mnt/old", "/config": "/etc/app"}`), 0644); err != nil {
+		t.Fatalf("Failed to write mapping file: %v", err)
+	}
+
+	remaps, err := restore.ParseMountRemaps([]string{"/data=/mnt/new"}, mappingFile)
+	if err != nil {
+		t.Fatalf("ParseMountRemaps failed: %v", err)
+	}
+
+	if remaps["/data"] != "/mnt/new" {
+		t.Errorf("Expected flag to override file for /data, got %q", remaps["/data"])
+	}
+	if remaps["/config"] != "/etc/app" {
+		t.Errorf("Expected /config from file, got %q", remaps["/config"])
+	}
+
+	for _, spec := range []string{"/data", "=/mnt/new", "data=/mnt/new"} {
+		if _, err := restore.ParseMountRemaps([]string{spec}, ""); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
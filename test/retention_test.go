@@ -0,0 +1,161 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/utils"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFakeCheckpoint lays out a checkpoint directory the way Checkpoint does,
+// without running CRIU.
+func writeFakeCheckpoint(t *testing.T, outputDir, container, name string, created time.Time, labels map[string]string, parent string) string {
+	t.Helper()
+
+	checkpointDir := filepath.Join(outputDir, container, name)
+	imagesDir := filepath.Join(checkpointDir, "images")
+	if err := utils.EnsureDir(imagesDir); err != nil {
+		t.Fatalf("Failed to create checkpoint directory: %v", err)
+	}
+
+	if err := utils.WriteFile(filepath.Join(imagesDir, "pages-1.img"), make([]byte, 1024)); err != nil {
+		t.Fatalf("Failed to create image file: %v", err)
+	}
+
+	if parent != "" {
+		if err := os.Symlink(filepath.Join("..", "..", parent, "images"), filepath.Join(imagesDir, "parent")); err != nil {
+			t.Fatalf("Failed to create parent link: %v", err)
+		}
+	}
+
+	metadata := checkpoint.CheckpointMetadata{
+		ContainerState: &docker.ContainerState{ID: container + "-0123456789", Name: container, Labels: labels},
+		CheckpointPath: checkpointDir,
+		CreatedAt:      created.UTC().Format(time.RFC3339),
+		Version:        "1.0",
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatalf("Failed to marshal metadata: %v", err)
+	}
+
+	if err := utils.WriteFile(filepath.Join(checkpointDir, "checkpoint_metadata.json"), data); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+
+	return checkpointDir
+}
+
+func TestPruneCheckpoints(t *testing.T) {
+	logger := setupTestLogger()
+	checkpointManager := checkpoint.NewManager(nil, logger)
+	now := time.Now()
+
+	t.Run("KeepLastDryRun", func(t *testing.T) {
+		outputDir := t.TempDir()
+		for i, name := range []string{"cp1", "cp2", "cp3"} {
+			writeFakeCheckpoint(t, outputDir, "web", name, now.Add(time.Duration(i-3)*time.Hour), nil, "")
+		}
+
+		result, err := checkpointManager.Prune(checkpoint.PruneConfig{
+			OutputDir: outputDir,
+			Policy:    checkpoint.RetentionPolicy{KeepLast: 1},
+			DryRun:    true,
+		})
+		if err != nil {
+			t.Fatalf("Prune failed: %v", err)
+		}
+
+		if result.Deleted != 2 {
+			t.Errorf("Expected 2 checkpoints marked for deletion, got %d", result.Deleted)
+		}
+
+		if !utils.DirExists(filepath.Join(outputDir, "web", "cp1")) {
+			t.Error("Dry run must not delete checkpoints")
+		}
+	})
+
+	t.Run("KeepsIncrementalParents", func(t *testing.T) {
+		outputDir := t.TempDir()
+		writeFakeCheckpoint(t, outputDir, "db", "full", now.Add(-3*time.Hour), nil, "")
+		writeFakeCheckpoint(t, outputDir, "db", "inc1", now.Add(-2*time.Hour), nil, "full")
+		writeFakeCheckpoint(t, outputDir, "db", "inc2", now.Add(-1*time.Hour), nil, "inc1")
+
+		result, err := checkpointManager.Prune(checkpoint.PruneConfig{
+			OutputDir: outputDir,
+			Policy:    checkpoint.RetentionPolicy{KeepLast: 1},
+		})
+		if err != nil {
+			t.Fatalf("Prune failed: %v", err)
+		}
+
+		if result.Deleted != 0 {
+			t.Errorf("Expected the whole incremental chain to be kept, %d deleted", result.Deleted)
+		}
+
+		for _, name := range []string{"full", "inc1", "inc2"} {
+			if !utils.DirExists(filepath.Join(outputDir, "db", name)) {
+				t.Errorf("Checkpoint %s was deleted", name)
+			}
+		}
+	})
+
+	t.Run("LabelOverridesPolicy", func(t *testing.T) {
+		outputDir := t.TempDir()
+		labels := map[string]string{checkpoint.LabelRetentionMaxAge: "1d"}
+		writeFakeCheckpoint(t, outputDir, "batch", "old", now.Add(-48*time.Hour), labels, "")
+		writeFakeCheckpoint(t, outputDir, "batch", "new", now.Add(-1*time.Hour), labels, "")
+
+		result, err := checkpointManager.Prune(checkpoint.PruneConfig{
+			OutputDir: outputDir,
+			UseLabels: true,
+		})
+		if err != nil {
+			t.Fatalf("Prune failed: %v", err)
+		}
+
+		if result.Deleted != 1 {
+			t.Errorf("Expected 1 checkpoint deleted, got %d", result.Deleted)
+		}
+
+		if utils.DirExists(filepath.Join(outputDir, "batch", "old")) {
+			t.Error("Expected checkpoint older than the label max-age to be deleted")
+		}
+		if !utils.DirExists(filepath.Join(outputDir, "batch", "new")) {
+			t.Error("Expected recent checkpoint to be kept")
+		}
+	})
+}
+
+func TestParseSizeAndDuration(t *testing.T) {
+	sizes := map[string]int64{
+		"512":  512,
+		"1K":   1024,
+		"10M":  10 << 20,
+		"2GB":  2 << 30,
+		"1.5G": 3 << 29,
+	}
+	for input, expected := range sizes {
+		got, err := utils.ParseSize(input)
+		if err != nil || got != expected {
+			t.Errorf("ParseSize(%q) = %d, %v; expected %d", input, got, err, expected)
+		}
+	}
+
+	durations := map[string]time.Duration{
+		"30m": 30 * time.Minute,
+		"7d":  7 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	}
+	for input, expected := range durations {
+		got, err := utils.ParseDuration(input)
+		if err != nil || got != expected {
+			t.Errorf("ParseDuration(%q) = %s, %v; expected %s", input, got, err, expected)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/schedule"
+	"docker-cr/pkg/utils"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNextParent(t *testing.T) {
+	job := schedule.Job{Container: "batch", Incremental: true, FullEvery: 3}
+
+	tests := []struct {
+		name         string
+		job          schedule.Job
+		status       schedule.JobStatus
+		expectParent bool
+	}{
+		{"FirstRun", job, schedule.JobStatus{}, false},
+		{"ChainStarted", job, schedule.JobStatus{LastCheckpoint: "/cp/auto-1", ChainLength: 1}, true},
+		{"ChainGrowing", job, schedule.JobStatus{LastCheckpoint: "/cp/auto-2", ChainLength: 2}, true},
+		{"ChainFull", job, schedule.JobStatus{LastCheckpoint: "/cp/auto-3", ChainLength: 3}, false},
+		// A failed run resets the chain length
+		{"AfterFailure", job, schedule.JobStatus{LastCheckpoint: "/cp/auto-2", ChainLength: 0, ConsecutiveFailures: 1}, false},
+		{"NotIncremental", schedule.Job{Container: "batch", FullEvery: 3}, schedule.JobStatus{LastCheckpoint: "/cp/auto-1", ChainLength: 1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := schedule.NextParent(tt.job, &tt.status)
+			if tt.expectParent && parent != tt.status.LastCheckpoint {
+				t.Errorf("Expected an incremental dump on %s, got %q", tt.status.LastCheckpoint, parent)
+			}
+			if !tt.expectParent && parent != "" {
+				t.Errorf("Expected a full dump, got parent %q", parent)
+			}
+		})
+	}
+}
+
+func TestLoadScheduleFile(t *testing.T) {
+	dir := t.TempDir()
+
+	scheduleFile := filepath.Join(dir, "schedule.json")
+	if err := os.WriteFile(scheduleFile, []byte(`{"jobs": [{"container": "batch", "every": "30m", "keep": 5, "incremental": true}]}`), 0644); err != nil {
+		t.Fatalf("Failed to write schedule file: %v", err)
+	}
+	file, err := schedule.LoadScheduleFile(scheduleFile)
+	if err != nil {
+		t.Fatalf("LoadScheduleFile failed: %v", err)
+	}
+	if len(file.Jobs) != 1 || file.Jobs[0].Container != "batch" || file.Jobs[0].Keep != 5 || !file.Jobs[0].Incremental {
+		t.Errorf("Unexpected jobs: %+v", file.Jobs)
+	}
+
+	emptyFile := filepath.Join(dir, "empty.json")
+	if err := os.WriteFile(emptyFile, []byte(`{"jobs": []}`), 0644); err != nil {
+		t.Fatalf("Failed to write schedule file: %v", err)
+	}
+	if _, err := schedule.LoadScheduleFile(emptyFile); err == nil {
+		t.Error("Expected a schedule file without jobs to be rejected")
+	}
+}
+
+func TestScheduledRotation(t *testing.T) {
+	checkpointManager := checkpoint.NewManager(nil, setupTestLogger())
+	outputDir := t.TempDir()
+	now := time.Now()
+
+	writeFakeCheckpoint(t, outputDir, "batch", "manual", now.Add(-5*time.Hour), nil, "")
+	writeFakeCheckpoint(t, outputDir, "batch", schedule.NamePrefix+"1", now.Add(-4*time.Hour), nil, "")
+	writeFakeCheckpoint(t, outputDir, "batch", schedule.NamePrefix+"2", now.Add(-3*time.Hour), nil, schedule.NamePrefix+"1")
+	writeFakeCheckpoint(t, outputDir, "batch", schedule.NamePrefix+"3", now.Add(-2*time.Hour), nil, "")
+	writeFakeCheckpoint(t, outputDir, "batch", schedule.NamePrefix+"4", now.Add(-1*time.Hour), nil, "")
+
+	result, err := checkpointManager.Prune(checkpoint.PruneConfig{
+		OutputDir:  outputDir,
+		Container:  "batch",
+		NamePrefix: schedule.NamePrefix,
+		Policy:     checkpoint.RetentionPolicy{KeepLast: 2},
+	})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+
+	if result.Deleted != 2 {
+		t.Errorf("Expected 2 scheduled checkpoints rotated out, got %d", result.Deleted)
+	}
+	for name, kept := range map[string]bool{
+		"manual":                  true,
+		schedule.NamePrefix + "1": false,
+		schedule.NamePrefix + "2": false,
+		schedule.NamePrefix + "3": true,
+		schedule.NamePrefix + "4": true,
+	} {
+		if utils.DirExists(filepath.Join(outputDir, "batch", name)) != kept {
+			t.Errorf("Checkpoint %s: expected kept=%v", name, kept)
+		}
+	}
+}
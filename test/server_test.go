@@ -0,0 +1,253 @@
+package test
+
+import (
+	"bytes"
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/inspect"
+	"docker-cr/pkg/restore"
+	"docker-cr/pkg/server"
+	"docker-cr/pkg/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, outputDir string) *httptest.Server {
+	t.Helper()
+
+	logger := setupTestLogger()
+	checkpointManager := checkpoint.NewManager(nil, logger)
+	restoreManager := restore.NewManager(nil, checkpointManager, logger)
+
+	srv := server.NewServer(nil, checkpointManager, restoreManager, inspect.NewViewer(logger), logger,
+		server.ServerConfig{OutputDir: outputDir})
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestServerAPI(t *testing.T) {
+	outputDir := t.TempDir()
+	writeFakeCheckpoint(t, outputDir, "web", "cp1", time.Now().Add(-time.Hour), nil, "")
+	writeFakeCheckpoint(t, outputDir, "web", "cp2", time.Now(), nil, "")
+
+	ts := newTestServer(t, outputDir)
+
+	t.Run("ListCheckpoints", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/v1/checkpoints?container=web")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var entries []checkpoint.CheckpointEntry
+		if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		if len(entries) != 2 || entries[0].Name != "cp2" {
+			t.Errorf("Expected cp2 and cp1 newest first, got %+v", entries)
+		}
+	})
+
+	t.Run("DeleteCheckpoint", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/v1/checkpoints/web/cp1", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", resp.StatusCode)
+		}
+		if utils.DirExists(filepath.Join(outputDir, "web", "cp1")) {
+			t.Error("Checkpoint was not deleted")
+		}
+	})
+
+	t.Run("RejectsPathTraversal", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/v1/checkpoints/web/..%2F..", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNoContent {
+			t.Error("Path traversal must not delete anything")
+		}
+		if !utils.DirExists(outputDir) {
+			t.Fatal("Output directory was deleted")
+		}
+	})
+
+	t.Run("RejectsHostPaths", func(t *testing.T) {
+		bodies := map[string]string{
+			"/v1/checkpoints": `{"container":"web","hooks":{"scripts":["/bin/sh"]}}`,
+			"/v1/restores":    `{"container":"web","checkpoint":"cp2","encryption":{"key_file":"/etc/shadow"}}`,
+		}
+		for path, body := range bodies {
+			resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected 400 for host paths in a %s request, got %d", path, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("RejectsParentPath", func(t *testing.T) {
+		for _, parent := range []string{"/var/lib/docker-cr/web/cp2", "../web/cp2", ".."} {
+			body := `{"container":"web","parent_checkpoint":"` + parent + `"}`
+			resp, err := http.Post(ts.URL+"/v1/checkpoints", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected 400 for parent checkpoint %q, got %d", parent, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("UnknownJob", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/v1/jobs/does-not-exist")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestServerDeleteCollectsChunks(t *testing.T) {
+	outputDir := t.TempDir()
+	store := checkpoint.StoreDir(outputDir)
+	manager := checkpoint.NewManager(nil, setupTestLogger())
+
+	dir := filepath.Join(outputDir, "web", "cp1")
+	writeTestCheckpoint(t, dir, nil, nil, 0)
+	if err := os.WriteFile(filepath.Join(dir, "container_metadata.json"), []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write container metadata: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "images", "pages-1.img"), bytes.Repeat([]byte{1}, 1<<20), 0644); err != nil {
+		t.Fatalf("Failed to write pages: %v", err)
+	}
+	if _, err := manager.WriteManifest(dir); err != nil {
+		t.Fatalf("WriteManifest failed: %v", err)
+	}
+	if err := manager.StoreCheckpoint(dir, store); err != nil {
+		t.Fatalf("StoreCheckpoint failed: %v", err)
+	}
+
+	ts := newTestServer(t, outputDir)
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/v1/checkpoints/web/cp1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", resp.StatusCode)
+	}
+	if stored := blobBytes(t, store); stored != 0 {
+		t.Errorf("Expected the deleted checkpoint's chunks to be collected, store still holds %d bytes", stored)
+	}
+}
+
+func TestServerEvictsFinishedJobs(t *testing.T) {
+	outputDir := t.TempDir()
+	writeFakeCheckpoint(t, outputDir, "web", "cp1", time.Now(), nil, "")
+
+	newServer := func(config server.ServerConfig) *httptest.Server {
+		logger := setupTestLogger()
+		checkpointManager := checkpoint.NewManager(nil, logger)
+		restoreManager := restore.NewManager(nil, checkpointManager, logger)
+		config.OutputDir = outputDir
+
+		ts := httptest.NewServer(server.NewServer(nil, checkpointManager, restoreManager, inspect.NewViewer(logger), logger, config).Handler())
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	// The fake checkpoint fails validation, so every restore job fails quickly
+	runJobs := func(ts *httptest.Server, n int) {
+		for i := 0; i < n; i++ {
+			resp, err := http.Post(ts.URL+"/v1/restores", "application/json",
+				strings.NewReader(`{"container":"web","checkpoint":"cp1"}`))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("Expected 202, got %d", resp.StatusCode)
+			}
+		}
+	}
+	listJobs := func(ts *httptest.Server) []server.Job {
+		resp, err := http.Get(ts.URL + "/v1/jobs")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var jobs []server.Job
+		if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+			t.Fatalf("Failed to decode jobs: %v", err)
+		}
+		return jobs
+	}
+	waitFinished := func(ts *httptest.Server) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			done := true
+			for _, job := range listJobs(ts) {
+				if job.State == server.JobPending || job.State == server.JobRunning {
+					done = false
+				}
+			}
+			if done {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("Jobs did not finish")
+	}
+
+	t.Run("MaxJobs", func(t *testing.T) {
+		ts := newServer(server.ServerConfig{MaxJobs: 2})
+		runJobs(ts, 4)
+		waitFinished(ts)
+
+		jobs := listJobs(ts)
+		if len(jobs) != 2 {
+			t.Errorf("Expected the 2 newest finished jobs to be kept, got %d", len(jobs))
+		}
+	})
+
+	t.Run("JobTTL", func(t *testing.T) {
+		ts := newServer(server.ServerConfig{JobTTL: 50 * time.Millisecond})
+		runJobs(ts, 2)
+		waitFinished(ts)
+		time.Sleep(100 * time.Millisecond)
+
+		if jobs := listJobs(ts); len(jobs) != 0 {
+			t.Errorf("Expected expired jobs to be dropped, got %+v", jobs)
+		}
+	})
+}
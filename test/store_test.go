@@ -0,0 +1,152 @@
+package test
+
+import (
+	"bytes"
+	"docker-cr/pkg/checkpoint"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChunkStore(t *testing.T) {
+	output := t.TempDir()
+	store := checkpoint.StoreDir(output)
+	manager := checkpoint.NewManager(nil, setupTestLogger())
+
+	// The second checkpoint has a page inserted near the start, shifting the rest
+	pages := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(pages)
+	shifted := append(append(append([]byte(nil), pages[:100<<10]...), bytes.Repeat([]byte{7}, 4096)...), pages[100<<10:]...)
+
+	dirs := []string{filepath.Join(output, "web", "cp1"), filepath.Join(output, "web", "cp2")}
+	for i, data := range [][]byte{pages, shifted} {
+		writeTestCheckpoint(t, dirs[i], nil, nil, 0)
+		if err := os.WriteFile(filepath.Join(dirs[i], "container_metadata.json"), []byte("{}"), 0644); err != nil {
+			t.Fatalf("Failed to write container metadata: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dirs[i], "images", "pages-1.img"), data, 0644); err != nil {
+			t.Fatalf("Failed to write pages: %v", err)
+		}
+		if _, err := manager.WriteManifest(dirs[i]); err != nil {
+			t.Fatalf("WriteManifest failed: %v", err)
+		}
+		if err := manager.StoreCheckpoint(dirs[i], store); err != nil {
+			t.Fatalf("StoreCheckpoint failed: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dirs[0], "images", "pages-1.img")); !os.IsNotExist(err) {
+		t.Errorf("Stored page images should be removed from the checkpoint")
+	}
+	stored := blobBytes(t, store)
+	if stored >= int64(len(pages)+len(shifted))*3/4 {
+		t.Errorf("Expected the shifted pages to share most chunks, store holds %d bytes", stored)
+	}
+
+	dir, cleanup, err := manager.OpenCheckpoint(dirs[1], nil)
+	if err != nil {
+		t.Fatalf("OpenCheckpoint failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "images", "pages-1.img"))
+	if err != nil || !bytes.Equal(data, shifted) {
+		t.Errorf("Materialized pages differ from the original")
+	}
+	if err := manager.ValidateCheckpoint(dir); err != nil {
+		t.Errorf("Materialized checkpoint failed validation: %v", err)
+	}
+	cleanup()
+
+	// Deleting one checkpoint frees only the chunks nobody else uses
+	if err := manager.DeleteCheckpoint(dirs[0]); err != nil {
+		t.Fatalf("DeleteCheckpoint failed: %v", err)
+	}
+	freed, err := manager.CollectGarbage(store)
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if freed == 0 || freed >= int64(len(pages))/2 {
+		t.Errorf("Expected a few unshared chunks to be freed, freed %d bytes", freed)
+	}
+
+	dir, cleanup, err = manager.MaterializeCheckpoint(dirs[1])
+	if err != nil {
+		t.Fatalf("Remaining checkpoint lost chunks: %v", err)
+	}
+	defer cleanup()
+	data, err = os.ReadFile(filepath.Join(dir, "images", "pages-1.img"))
+	if err != nil || !bytes.Equal(data, shifted) {
+		t.Errorf("Materialized pages differ from the original after garbage collection")
+	}
+}
+
+func blobBytes(t *testing.T, store string) int64 {
+	t.Helper()
+
+	var total int64
+	err := filepath.Walk(filepath.Join(store, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to walk store: %v", err)
+	}
+	return total
+}
+
+func TestChunkStoreRecovery(t *testing.T) {
+	output := t.TempDir()
+	store := checkpoint.StoreDir(output)
+	manager := checkpoint.NewManager(nil, setupTestLogger())
+
+	pages := make([]byte, 1<<20)
+	rand.New(rand.NewSource(2)).Read(pages)
+
+	// Checkpoints below the output directory are found at any depth
+	dirs := []string{filepath.Join(output, "groups", "web", "cp1"), filepath.Join(output, "web", "cp2")}
+	for i, dir := range dirs {
+		writeTestCheckpoint(t, dir, nil, nil, 0)
+		if err := os.WriteFile(filepath.Join(dir, "images", "pages-1.img"), pages, 0644); err != nil {
+			t.Fatalf("Failed to write pages: %v", err)
+		}
+
+		if i == 1 {
+			// A chunk cut short by a crash is not reused
+			var blob string
+			filepath.Walk(filepath.Join(store, "blobs"), func(path string, info os.FileInfo, err error) error {
+				if err == nil && info.Mode().IsRegular() && blob == "" {
+					blob = path
+				}
+				return err
+			})
+			if err := os.Truncate(blob, 10); err != nil {
+				t.Fatalf("Failed to truncate chunk: %v", err)
+			}
+		}
+
+		if err := manager.StoreCheckpoint(dir, store); err != nil {
+			t.Fatalf("StoreCheckpoint failed: %v", err)
+		}
+	}
+
+	if freed, err := manager.CollectGarbage(store); err != nil || freed != 0 {
+		t.Errorf("Expected nothing to be collected, freed %d bytes: %v", freed, err)
+	}
+
+	for _, dir := range dirs {
+		materialized, cleanup, err := manager.MaterializeCheckpoint(dir)
+		if err != nil {
+			t.Fatalf("MaterializeCheckpoint of %s failed: %v", dir, err)
+		}
+		data, err := os.ReadFile(filepath.Join(materialized, "images", "pages-1.img"))
+		if err != nil || !bytes.Equal(data, pages) {
+			t.Errorf("Materialized pages of %s differ from the original", dir)
+		}
+		if filepath.Dir(filepath.Dir(materialized)) != filepath.Dir(dir) {
+			t.Errorf("Expected %s to be materialized next to it, got %s", dir, materialized)
+		}
+		cleanup()
+	}
+}
@@ -0,0 +1,182 @@
+package test
+
+import (
+	"context"
+	"docker-cr/pkg/restore"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransactionCommit(t *testing.T) {
+	base := t.TempDir()
+	tx := restore.NewTransaction(setupTestLogger())
+
+	dir := filepath.Join(base, "mounts", "data")
+	if err := tx.EnsureDir(dir); err != nil {
+		t.Fatalf("EnsureDir failed: %v", err)
+	}
+	file := filepath.Join(base, "ext_mount_map")
+	if err := tx.WriteFile(file, func() error { return os.WriteFile(file, []byte("new"), 0644) }); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// A successful restore never rolls back, so its changes stay
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Directory was not created: %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "new" {
+		t.Errorf("File holds %q", data)
+	}
+
+	// Nothing recorded means nothing to wrap
+	cause := errors.New("start failed")
+	if err := restore.NewTransaction(setupTestLogger()).Rollback(cause); err != cause {
+		t.Errorf("Expected the cause back from an empty rollback, got %v", err)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	base := t.TempDir()
+	tx := restore.NewTransaction(setupTestLogger())
+
+	if err := tx.EnsureDir(filepath.Join(base, "mounts", "data", "logs")); err != nil {
+		t.Fatalf("EnsureDir failed: %v", err)
+	}
+	// Existing directories are not recorded
+	if err := tx.EnsureDir(base); err != nil {
+		t.Fatalf("EnsureDir failed: %v", err)
+	}
+
+	existing := filepath.Join(base, "existing.conf")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := tx.WriteFile(existing, func() error { return os.WriteFile(existing, []byte("new"), 0644) }); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	created := filepath.Join(base, "created.conf")
+	if err := tx.WriteFile(created, func() error { return os.WriteFile(created, []byte("new"), 0644) }); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	var order []string
+	tx.Record("removed container", func(ctx context.Context) error {
+		order = append(order, "container")
+		return nil
+	})
+
+	cause := errors.New("CRIU restore failed")
+	err := tx.Rollback(cause)
+
+	var restoreErr *restore.RestoreError
+	if !errors.As(err, &restoreErr) || !errors.Is(err, cause) {
+		t.Fatalf("Expected a RestoreError wrapping the cause, got %v", err)
+	}
+	if len(restoreErr.RolledBack) != 4 || restoreErr.RolledBack[0].Description != "removed container" {
+		t.Errorf("Expected 4 steps undone newest first, got %+v", restoreErr.RolledBack)
+	}
+	if len(order) != 1 {
+		t.Errorf("Expected the recorded step to run once, ran %d times", len(order))
+	}
+
+	if _, err := os.Stat(filepath.Join(base, "mounts")); !os.IsNotExist(err) {
+		t.Errorf("Created directories were not removed")
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "old" {
+		t.Errorf("Existing file was not put back, holds %q", data)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("Created file was not removed")
+	}
+	if _, err := os.Stat(base); err != nil {
+		t.Errorf("Pre-existing directory was removed")
+	}
+
+	// Steps are only undone once
+	if err := tx.Rollback(cause); err != cause {
+		t.Errorf("Expected a second rollback to have nothing to undo, got %v", err)
+	}
+}
+
+func TestTransactionPartialRollback(t *testing.T) {
+	base := t.TempDir()
+	tx := restore.NewTransaction(setupTestLogger())
+
+	if err := tx.EnsureDir(filepath.Join(base, "mounts")); err != nil {
+		t.Fatalf("EnsureDir failed: %v", err)
+	}
+	tx.Record("removed network abc", func(ctx context.Context) error {
+		return errors.New("network has active endpoints")
+	})
+	tx.Record("removed container def", func(ctx context.Context) error { return nil })
+
+	err := tx.Rollback(errors.New("start failed"))
+
+	var restoreErr *restore.RestoreError
+	if !errors.As(err, &restoreErr) {
+		t.Fatalf("Expected a RestoreError, got %v", err)
+	}
+	if len(restoreErr.RolledBack) != 3 || restoreErr.RolledBack[1].Error == "" {
+		t.Errorf("Expected the network step to fail and the others to run, got %+v", restoreErr.RolledBack)
+	}
+
+	// A failed step does not stop the ones recorded before it
+	if _, err := os.Stat(filepath.Join(base, "mounts")); !os.IsNotExist(err) {
+		t.Errorf("Directory was not removed after a failed step")
+	}
+
+	msg := err.Error()
+	if !strings.Contains(msg, "rolled back: removed container def") ||
+		!strings.Contains(msg, "failed to roll back: removed network abc (network has active endpoints)") {
+		t.Errorf("Unexpected error message: %s", msg)
+	}
+}
+
+func TestTransactionCreateFile(t *testing.T) {
+	base := t.TempDir()
+	tx := restore.NewTransaction(setupTestLogger())
+
+	// A missing file bind mount source is created as an empty file
+	source := filepath.Join(base, "etc", "app", "config.yaml")
+	if err := tx.CreateFile(source); err != nil {
+		t.Fatalf("CreateFile failed: %v", err)
+	}
+	info, err := os.Stat(source)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != 0 {
+		t.Fatalf("Expected an empty regular file, got %v (%v)", info, err)
+	}
+
+	tx.Rollback(errors.New("restore failed"))
+	if _, err := os.Stat(filepath.Join(base, "etc")); !os.IsNotExist(err) {
+		t.Errorf("Created file and directories were not removed")
+	}
+}
+
+func TestTransactionRollbackAfterCleanup(t *testing.T) {
+	// The generated file lives in a private checkpoint copy that is
+	// already gone when the rollback runs
+	copyDir := t.TempDir()
+	tx := restore.NewTransaction(setupTestLogger())
+
+	mapFile := filepath.Join(copyDir, "ext_mount_map")
+	if err := tx.WriteFile(mapFile, func() error {
+		return os.WriteFile(mapFile, []byte("mnt[/data]:/srv/data\n"), 0644)
+	}); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.RemoveAll(copyDir); err != nil {
+		t.Fatalf("Failed to remove copy: %v", err)
+	}
+
+	err := tx.Rollback(errors.New("restore failed"))
+	var restoreErr *restore.RestoreError
+	if !errors.As(err, &restoreErr) {
+		t.Fatalf("Expected a RestoreError, got %v", err)
+	}
+	if strings.Contains(err.Error(), "failed to roll back") {
+		t.Errorf("A file that is already gone must not fail the rollback: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"docker-cr/pkg/checkpoint"
+	"docker-cr/pkg/docker"
+	"docker-cr/pkg/watch"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsStopSignal(t *testing.T) {
+	tests := []struct {
+		signal     string
+		stopSignal string
+		expected   bool
+	}{
+		{"15", "", true},
+		{"15", "SIGTERM", true},
+		{"3", "SIGQUIT", true},
+		{"3", "quit", true},
+		{"2", "2", true},
+		{"1", "", false},
+		{"15", "SIGQUIT", false},
+		{"10", "SIGBOGUS", false},
+	}
+
+	for _, tt := range tests {
+		if got := watch.IsStopSignal(tt.signal, tt.stopSignal); got != tt.expected {
+			t.Errorf("IsStopSignal(%q, %q) = %v, expected %v", tt.signal, tt.stopSignal, got, tt.expected)
+		}
+	}
+}
+
+func TestRestoreName(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first := watch.RestoreName("web", at)
+	if first != "web-restored-20240501T120000Z" {
+		t.Errorf("Unexpected restore name %s", first)
+	}
+	if second := watch.RestoreName("web", at.Add(time.Minute)); second == first {
+		t.Errorf("Restores at different times share the name %s", first)
+	}
+}
+
+// A stop checkpoint races the container's own shutdown. When the container
+// wins, the checkpoint fails cleanly and leaves nothing that could be
+// mistaken for a usable checkpoint.
+func TestCheckpointContainerExitsDuringDump(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Skipping test - requires root privileges")
+	}
+	for _, tool := range []string{"docker", "criu"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("Skipping test - %s not found", tool)
+		}
+	}
+
+	name := "docker-cr-exit-during-dump"
+	exec.Command("docker", "rm", "-f", name).Run()
+	if out, err := exec.Command("docker", "run", "-d", "--name", name, testImage, "sleep", "300").CombinedOutput(); err != nil {
+		t.Skipf("Skipping test - cannot start container: %v: %s", err, out)
+	}
+	defer exec.Command("docker", "rm", "-f", name).Run()
+
+	logger := setupTestLogger()
+	dockerManager, err := docker.NewManager(logger)
+	if err != nil {
+		t.Fatalf("Failed to create Docker manager: %v", err)
+	}
+	defer dockerManager.Close()
+
+	state, err := dockerManager.GetContainerState(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Failed to get container state: %v", err)
+	}
+
+	// The container exits on its stop signal while CRIU is dumping it
+	hooks := &checkpoint.Hooks{}
+	hooks.On(checkpoint.HookPreDump, func(ctx context.Context, event string, pid int) error {
+		return syscall.Kill(state.ProcessPID, syscall.SIGKILL)
+	})
+
+	output := t.TempDir()
+	err = checkpoint.NewManager(dockerManager, logger).Checkpoint(context.Background(), name, checkpoint.CheckpointConfig{
+		OutputDir:      output,
+		CheckpointName: "stop-race",
+		LeaveRunning:   true,
+		LogLevel:       4,
+		Hooks:          hooks,
+	})
+	if err == nil {
+		t.Fatal("Expected the checkpoint of an exiting container to fail")
+	}
+	if !strings.Contains(err.Error(), "no longer running") {
+		t.Errorf("Expected the error to report the exited container, got: %v", err)
+	}
+	if checkpoint.HasCheckpointData(filepath.Join(output, name, "stop-race")) {
+		t.Error("Partial checkpoint data was left behind")
+	}
+}